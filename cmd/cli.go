@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+const cliUsage = `usage:
+  cinema export tickets    [-format csv|xlsx] [-o file] [key=value ...]
+  cinema export box-office [-format csv|xlsx] [-o file] [from=YYYY-MM-DD] [to=YYYY-MM-DD]
+  cinema export orders     [-format csv|xlsx] [-o file] [key=value ...]
+  cinema export refunds    [-format csv|xlsx] [-o file] [key=value ...]
+
+key=value pairs are the query parameters of the matching HTTP endpoint,
+e.g. status=BOOKED from=2026-01-01 sort=-price. The CLI reads the database
+directly and sees every user's tickets and orders.
+`
+
+// runCLI runs a command instead of the server and returns the exit code.
+func runCLI(store *MovieStore, args []string) int {
+	if len(args) < 2 || args[0] != "export" {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	what := args[1]
+	if !slices.Contains([]string{"tickets", "box-office", "orders", "refunds"}, what) {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("export "+what, flag.ContinueOnError)
+	format := fs.String("format", "csv", "csv or xlsx")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args[2:]); err != nil {
+		return 2
+	}
+	q := url.Values{}
+	for _, kv := range fs.Args() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "expected key=value, got %q\n", kv)
+			return 2
+		}
+		q.Set(k, v)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	tw, err := newTableWriter(w, *format, what)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	err = runExport(tw, store, what, q)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func runExport(tw tableWriter, store *MovieStore, what string, q url.Values) error {
+	switch what {
+	case "box-office":
+		from, to, err := reportRange(q)
+		if err != nil {
+			return err
+		}
+		rep, err := store.BoxOffice(from, to)
+		if err != nil {
+			return err
+		}
+		return exportBoxOffice(tw, rep)
+	case "orders", "refunds":
+		f, err := parseOrderFilter(q)
+		if err != nil {
+			return err
+		}
+		if what == "orders" {
+			return exportOrders(tw, store, f)
+		}
+		return exportRefunds(tw, store, f)
+	}
+
+	page, err := parsePage(q, ticketSortFields, "-id", "id DESC")
+	if err != nil {
+		return err
+	}
+	f, err := parseTicketFilter(q)
+	if err != nil {
+		return err
+	}
+	return exportTickets(tw, store, f, page.OrderBy)
+}
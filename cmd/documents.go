@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pdf"
+	"Final_1/internal/qr"
+)
+
+const (
+	margin    = 60.0
+	dateFmt   = "Mon, 2 Jan 2006"
+	clockFmt  = "15:04"
+	currency  = "KZT"
+	lineSpace = 22.0
+)
+
+// includedVAT is the tax contained in a VAT-inclusive amount, rounded to the
+// nearest tenge.
+func includedVAT(gross int, percent float64) int {
+	return int(float64(gross)*percent/(100+percent) + 0.5)
+}
+
+func money(amount int) string {
+	return fmt.Sprintf("%d %s", amount, currency)
+}
+
+// fitText shortens s with an ellipsis until it fits in width.
+func fitText(s string, width, size float64, font pdf.Font) string {
+	if pdf.TextWidth(s, size, font) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdf.TextWidth(string(r)+"...", size, font) > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
+
+// writeTicketPDF renders an A4 ticket with the signed QR payload for the
+// doors.
+func writeTicketPDF(w io.Writer, t models.Ticket, si SessionInfo, holder string, payload string) error {
+	code, err := qr.Encode([]byte(payload))
+	if err != nil {
+		return err
+	}
+	doc := pdf.New(fmt.Sprintf("Ticket #%d", t.ID))
+	p := doc.AddPage()
+
+	p.Text(margin, 80, 24, pdf.Bold, "CINEMA TICKET")
+	p.TextRight(pdf.PageWidth-margin, 80, 10, pdf.Regular, fmt.Sprintf("Ticket #%d", t.ID))
+	p.Line(margin, 95, pdf.PageWidth-margin, 95, 1)
+	p.Text(margin, 135, 20, pdf.Bold, fitText(si.MovieTitle, pdf.PageWidth-2*margin, 20, pdf.Bold))
+
+	row, seat := seatPosition(t.SeatID, si.SeatsPerRow)
+	fields := [][2]string{
+		{"Date", si.Time.Format(dateFmt)},
+		{"Time", si.Time.Format(clockFmt)},
+		{"Hall", si.HallName},
+		{"Row", fmt.Sprint(row)},
+		{"Seat", fmt.Sprint(seat)},
+		{"Price", money(t.Price)},
+		{"Holder", holder},
+	}
+	if t.OrderID != 0 {
+		fields = append(fields, [2]string{"Order", fmt.Sprintf("#%d", t.OrderID)})
+	}
+	y := 175.0
+	for _, f := range fields {
+		p.Text(margin, y, 10, pdf.Regular, f[0])
+		p.Text(margin+70, y, 12, pdf.Bold, f[1])
+		y += lineSpace
+	}
+
+	// The QR code is drawn as vector squares, so it stays sharp when printed.
+	const side = 170.0
+	x0, y0 := pdf.PageWidth-margin-side, 160.0
+	module := side / float64(code.Size)
+	for j := 0; j < code.Size; j++ {
+		for i := 0; i < code.Size; i++ {
+			if code.Dark(i, j) {
+				p.Rect(x0+float64(i)*module, y0+float64(j)*module, module, module)
+			}
+		}
+	}
+	p.Text(x0, y0+side+18, 9, pdf.Regular, "Show this code at the entrance.")
+
+	p.Line(margin, y+10, pdf.PageWidth-margin, y+10, 0.5)
+	p.Text(margin, y+30, 9, pdf.Regular, "Doors open one hour before the session. The ticket is valid for one entry.")
+	_, err = doc.WriteTo(w)
+	return err
+}
+
+// writeReceiptPDF renders the receipt of an order. Prices include VAT at
+// vatPercent.
+func writeReceiptPDF(w io.Writer, o OrderDetails, vatPercent float64) error {
+	doc := pdf.New(fmt.Sprintf("Receipt for order #%d", o.ID))
+	p := doc.AddPage()
+	right := pdf.PageWidth - margin
+
+	p.Text(margin, 80, 24, pdf.Bold, "RECEIPT")
+	p.TextRight(right, 80, 10, pdf.Regular, fmt.Sprintf("Order #%d", o.ID))
+	p.Line(margin, 95, right, 95, 1)
+	p.Text(margin, 120, 10, pdf.Regular, "Date: "+o.CreatedAt.Format(dateFmt+" "+clockFmt))
+	p.Text(margin, 136, 10, pdf.Regular, fmt.Sprintf("Customer: %s <%s>", o.CustomerName, o.CustomerEmail))
+	p.Text(margin, 152, 10, pdf.Regular, "Status: "+o.Status)
+
+	y := 190.0
+	p.Text(margin, y, 10, pdf.Bold, "Item")
+	p.Text(margin+260, y, 10, pdf.Bold, "Session")
+	p.TextRight(right, y, 10, pdf.Bold, "Amount")
+	p.Line(margin, y+6, right, y+6, 0.5)
+	y += lineSpace
+	for _, l := range o.Lines {
+		item := fmt.Sprintf("%s, row %d seat %d", l.MovieTitle, l.Row, l.Seat)
+		if l.Status == "CANCELLED" {
+			item += " (cancelled)"
+		}
+		p.Text(margin, y, 10, pdf.Regular, fitText(item, 250, 10, pdf.Regular))
+		p.Text(margin+260, y, 10, pdf.Regular, l.StartsAt.Format("02.01.2006 "+clockFmt)+", "+l.HallName)
+		p.TextRight(right, y, 10, pdf.Regular, money(l.Price))
+		y += lineSpace
+	}
+	p.Line(margin, y-14, right, y-14, 0.5)
+
+	vat := includedVAT(o.Total, vatPercent)
+	totals := [][2]string{
+		{"Net amount", money(o.Total - vat)},
+		{fmt.Sprintf("VAT %g%% (included)", vatPercent), money(vat)},
+	}
+	for _, t := range totals {
+		p.Text(margin+260, y, 10, pdf.Regular, t[0])
+		p.TextRight(right, y, 10, pdf.Regular, t[1])
+		y += lineSpace - 6
+	}
+	y += 6
+	p.Text(margin+260, y, 12, pdf.Bold, "Total")
+	p.TextRight(right, y, 12, pdf.Bold, money(o.Total))
+	y += lineSpace * 2
+	p.Text(margin, y, 10, pdf.Regular, "Paid by: "+o.PaymentMethod)
+	_, err := doc.WriteTo(w)
+	return err
+}
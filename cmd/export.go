@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormats maps every supported ?format= to its content type.
+var exportFormats = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// tableWriter streams rows of a single table. Rows are written as they come
+// so exports never hold the whole result in memory.
+type tableWriter interface {
+	WriteRow(values ...any) error
+	Close() error
+}
+
+func newTableWriter(w io.Writer, format, sheet string) (tableWriter, error) {
+	switch format {
+	case "csv":
+		return &csvTable{w: csv.NewWriter(w)}, nil
+	case "xlsx":
+		return newXLSXTable(w, sheet)
+	}
+	return nil, fmt.Errorf("format must be csv or xlsx")
+}
+
+func cellText(v any) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+type csvTable struct {
+	w *csv.Writer
+}
+
+func (t *csvTable) WriteRow(values ...any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = cellText(v)
+		// Spreadsheets run text starting with these as a formula.
+		if s, ok := v.(string); ok && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+			record[i] = "'" + s
+		}
+	}
+	return t.w.Write(record)
+}
+
+func (t *csvTable) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// xlsxTable writes a one-sheet workbook. Strings are stored inline, so the
+// package needs no shared strings table and can be written in one pass.
+type xlsxTable struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+func newXLSXTable(w io.Writer, sheet string) (*xlsxTable, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(sheet))},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return &xlsxTable{zw: zw, sheet: f}, err
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (t *xlsxTable) WriteRow(values ...any) error {
+	t.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, t.row)
+	for _, v := range values {
+		switch v.(type) {
+		case int, int64, float64:
+			fmt.Fprintf(&b, `<c><v>%s</v></c>`, cellText(v))
+		default:
+			fmt.Fprintf(&b, `<c t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, xmlEscape(cellText(v)))
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(t.sheet, b.String())
+	return err
+}
+
+func (t *xlsxTable) Close() error {
+	if _, err := io.WriteString(t.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
+
+// exportTickets writes the tickets matching f with a header row.
+func exportTickets(tw tableWriter, s *MovieStore, f TicketFilter, orderBy string) error {
+	if err := tw.WriteRow("id", "session_id", "seat_id", "user_id", "price", "status", "created_at"); err != nil {
+		return err
+	}
+	return s.EachTicket(f, orderBy, func(t TicketItem) error {
+		return tw.WriteRow(t.ID, t.SessionID, t.SeatID, t.UserID, t.Price, t.Status, t.CreatedAt)
+	})
+}
+
+// exportBoxOffice flattens the report into one table; the breakdown column
+// tells the sections apart.
+func exportBoxOffice(tw tableWriter, rep BoxOfficeReport) error {
+	if err := tw.WriteRow("breakdown", "key", "revenue", "tickets", "sessions", "capacity", "occupancy"); err != nil {
+		return err
+	}
+	sections := []struct {
+		name string
+		rows []BoxOfficeRow
+	}{
+		{"total", []BoxOfficeRow{rep.Total}},
+		{"movie", rep.ByMovie},
+		{"day", rep.ByDay},
+		{"hall", rep.ByHall},
+		{"genre", rep.ByGenre},
+	}
+	for _, sec := range sections {
+		for _, r := range sec.rows {
+			if err := tw.WriteRow(sec.name, r.Key, r.Revenue, r.Tickets, r.Sessions, r.Capacity, r.Occupancy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportOrders writes the orders matching f with a header row.
+func exportOrders(tw tableWriter, s *MovieStore, f OrderFilter) error {
+	if err := tw.WriteRow("id", "user_id", "status", "payment_method", "total", "tickets", "refunded", "created_at"); err != nil {
+		return err
+	}
+	return s.EachOrder(f, func(o OrderRow) error {
+		return tw.WriteRow(o.ID, o.UserID, o.Status, o.PaymentMethod, o.Total, o.Tickets, o.Refunded, o.CreatedAt)
+	})
+}
+
+// exportRefunds writes one row per refunded ticket matching f.
+func exportRefunds(tw tableWriter, s *MovieStore, f OrderFilter) error {
+	if err := tw.WriteRow("ticket_id", "order_id", "user_id", "session_id", "movie", "amount", "payment_method",
+		"reason", "refunded_at"); err != nil {
+		return err
+	}
+	return s.EachRefund(f, func(r RefundRow) error {
+		var at any = ""
+		if r.RefundedAt != nil {
+			at = *r.RefundedAt
+		}
+		return tw.WriteRow(r.TicketID, r.OrderID, r.UserID, r.SessionID, r.MovieTitle, r.Amount, r.PaymentMethod,
+			r.Reason, at)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// The attendance model is a ridge regression of a session's final occupancy
+// (share of seats sold) on day of week, time of day, genres and how long the
+// movie has been out. Sales already made are folded in with the historical
+// sales curve, see blendForecast.
+
+const (
+	ridgeLambda         = 1.0
+	minTrainingSessions = 20
+	curveDays           = 14
+)
+
+type sessionFeatures struct {
+	StartsAt    time.Time
+	Genres      []string
+	ReleaseDate *time.Time
+}
+
+type attendanceModel struct {
+	genres  map[string]int // genre -> feature index
+	weights []float64      // nil when there was too little history
+	mean    float64        // mean occupancy of the training set
+}
+
+func partOfDay(t time.Time) int {
+	switch h := t.Hour(); {
+	case h >= 5 && h < 12:
+		return 0 // morning
+	case h >= 12 && h < 17:
+		return 1 // afternoon
+	case h >= 17 && h < 21:
+		return 2 // evening
+	}
+	return 3 // night
+}
+
+// vector lays out: bias, 7 days of week, 4 parts of day, log days since
+// release, then one column per genre seen in training.
+func (m *attendanceModel) vector(f sessionFeatures) []float64 {
+	x := make([]float64, 13+len(m.genres))
+	x[0] = 1
+	x[1+int(f.StartsAt.Weekday())] = 1
+	x[8+partOfDay(f.StartsAt)] = 1
+	days := 365.0 // unknown release dates count as old movies
+	if f.ReleaseDate != nil {
+		days = math.Max(0, f.StartsAt.Sub(*f.ReleaseDate).Hours()/24)
+	}
+	x[12] = math.Log1p(days)
+	for _, g := range f.Genres {
+		if i, ok := m.genres[g]; ok {
+			x[13+i] = 1
+		}
+	}
+	return x
+}
+
+// trainAttendanceModel fits the model on past sessions and their final
+// occupancy in [0, 1].
+func trainAttendanceModel(samples []sessionFeatures, occupancy []float64) *attendanceModel {
+	m := &attendanceModel{genres: map[string]int{}}
+	for _, y := range occupancy {
+		m.mean += y
+	}
+	if len(occupancy) > 0 {
+		m.mean /= float64(len(occupancy))
+	}
+	if len(samples) < minTrainingSessions {
+		return m
+	}
+
+	for _, s := range samples {
+		for _, g := range s.Genres {
+			if _, ok := m.genres[g]; !ok {
+				m.genres[g] = len(m.genres)
+			}
+		}
+	}
+
+	// Normal equations (XᵀX + λI) w = Xᵀy.
+	n := 13 + len(m.genres)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n)
+		a[i][i] = ridgeLambda
+	}
+	b := make([]float64, n)
+	for k, s := range samples {
+		x := m.vector(s)
+		for i := range x {
+			if x[i] == 0 {
+				continue
+			}
+			b[i] += x[i] * occupancy[k]
+			for j := range x {
+				a[i][j] += x[i] * x[j]
+			}
+		}
+	}
+	if w, ok := solveLinear(a, b); ok {
+		m.weights = w
+	}
+	return m
+}
+
+// predict returns the expected final occupancy in [0, 1].
+func (m *attendanceModel) predict(f sessionFeatures) float64 {
+	if m.weights == nil {
+		return m.mean
+	}
+	y := 0.0
+	for i, v := range m.vector(f) {
+		y += v * m.weights[i]
+	}
+	return math.Min(1, math.Max(0, y))
+}
+
+// solveLinear solves a·x = b by Gaussian elimination with partial pivoting.
+// a and b are overwritten.
+func solveLinear(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < n; r++ {
+			f := a[r][col] / a[col][col]
+			for c := col; c < n; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+			b[r] -= f * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := b[r]
+		for c := r + 1; c < n; c++ {
+			sum -= a[r][c] * x[c]
+		}
+		x[r] = sum / a[r][r]
+	}
+	return x, true
+}
+
+// salesCurve[d] is the average share of a session's final tickets that was
+// already sold d days before it started; nil when there is no history.
+type salesCurve []float64
+
+func (c salesCurve) soldShare(lead time.Duration) (float64, bool) {
+	if len(c) == 0 {
+		return 0, false
+	}
+	d := min(max(int(lead.Hours()/24), 0), len(c)-1)
+	return c[d], true
+}
+
+// blendForecast combines tickets sold so far with the model: what is sold
+// plus the model's estimate for the share that usually still comes in.
+func blendForecast(sold int, modelTickets float64, curve salesCurve, lead time.Duration, capacity int) int {
+	estimate := math.Max(float64(sold), modelTickets)
+	if share, ok := curve.soldShare(lead); ok {
+		estimate = float64(sold) + (1-share)*modelTickets
+	}
+	return min(int(math.Round(estimate)), capacity)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ExportHandler struct {
+	store *MovieStore
+}
+
+func NewExportHandler(store *MovieStore) *ExportHandler {
+	return &ExportHandler{store: store}
+}
+
+// startExport checks ?format= (csv by default) and sends the download
+// headers. Nothing is written when ok is false.
+func startExport(w http.ResponseWriter, r *http.Request, name string) (tableWriter, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportFormats[format]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be csv or xlsx"})
+		return nil, false
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s-%s.%s"`, name, time.Now().Format("20060102"), format))
+	tw, err := newTableWriter(w, format, name)
+	if err != nil {
+		http.Error(w, "Export failed: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return tw, true
+}
+
+// finishExport closes the table. Once rows are on the wire the status can no
+// longer change, so a failure is only logged and the download is cut short.
+func finishExport(tw tableWriter, name string, err error) {
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+	}
+}
+
+// Tickets handles GET /tickets/export?format=csv|xlsx with the filters and
+// sort of /tickets. Users other than admins only get their own tickets.
+func (h *ExportHandler) Tickets(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	q := r.URL.Query()
+	page, err := parsePage(q, ticketSortFields, "-id", "id DESC")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	f, err := parseTicketFilter(q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if user.Role != "admin" {
+		f.UserID = user.ID
+	}
+
+	tw, ok := startExport(w, r, "tickets")
+	if !ok {
+		return
+	}
+	finishExport(tw, "tickets", exportTickets(tw, h.store, f, page.OrderBy))
+}
+
+// BoxOffice handles GET /reports/box-office/export?format=&from=&to=.
+func (h *ExportHandler) BoxOffice(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	rep, err := h.store.BoxOffice(from, to)
+	if err != nil {
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tw, ok := startExport(w, r, "box-office")
+	if !ok {
+		return
+	}
+	finishExport(tw, "box-office", exportBoxOffice(tw, rep))
+}
+
+// parseOrderFilter reads the filters shared by the order and refund exports:
+// user_id, status, payment_method, from and to.
+func parseOrderFilter(q url.Values) (OrderFilter, error) {
+	f := OrderFilter{
+		Status:        strings.TrimSpace(q.Get("status")),
+		PaymentMethod: strings.TrimSpace(q.Get("payment_method")),
+	}
+	var err error
+	if f.UserID, _, err = queryInt(q, "user_id"); err != nil {
+		return f, err
+	}
+	f.From, f.To, err = queryRange(q)
+	return f, err
+}
+
+// orderExportFilter parses the filters and limits users other than admins
+// to their own orders.
+func orderExportFilter(w http.ResponseWriter, r *http.Request) (OrderFilter, bool) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return OrderFilter{}, false
+	}
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return f, false
+	}
+	if user.Role != "admin" {
+		f.UserID = user.ID
+	}
+	return f, true
+}
+
+// Orders handles GET /orders/export?format=&status=&payment_method=&from=&to=.
+func (h *ExportHandler) Orders(w http.ResponseWriter, r *http.Request) {
+	f, ok := orderExportFilter(w, r)
+	if !ok {
+		return
+	}
+	tw, ok := startExport(w, r, "orders")
+	if !ok {
+		return
+	}
+	finishExport(tw, "orders", exportOrders(tw, h.store, f))
+}
+
+// Refunds handles GET /refunds/export with the filters of the orders export;
+// from and to bound the refund time.
+func (h *ExportHandler) Refunds(w http.ResponseWriter, r *http.Request) {
+	f, ok := orderExportFilter(w, r)
+	if !ok {
+		return
+	}
+	tw, ok := startExport(w, r, "refunds")
+	if !ok {
+		return
+	}
+	finishExport(tw, "refunds", exportRefunds(tw, h.store, f))
+}
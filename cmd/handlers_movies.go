@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type MovieHandler struct {
@@ -33,12 +35,24 @@ func readJSON(r *http.Request, dst any) error {
 func (h *MovieHandler) Movies(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		movies, err := h.store.GetAll()
+		q := r.URL.Query()
+		page, err := parsePage(q, movieSortFields, "id", "id ASC")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		f, err := parseMovieFilter(q)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		movies, total, err := h.store.ListMovies(f, page)
 		if err != nil {
 			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		writeJSON(w, http.StatusOK, movies)
+		writeJSON(w, http.StatusOK, newPage(r, page, movies, total))
 		return
 
 	case http.MethodPost:
@@ -62,6 +76,25 @@ func (h *MovieHandler) Movies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func parseMovieFilter(q url.Values) (MovieFilter, error) {
+	f := MovieFilter{Genre: strings.TrimSpace(q.Get("genre"))}
+	for key, dst := range map[string]**int{
+		"min_price":    &f.MinPrice,
+		"max_price":    &f.MaxPrice,
+		"min_duration": &f.MinDuration,
+		"max_duration": &f.MaxDuration,
+	} {
+		n, ok, err := queryInt(q, key)
+		if err != nil {
+			return f, err
+		}
+		if ok {
+			*dst = &n
+		}
+	}
+	return f, nil
+}
+
 func (h *MovieHandler) MovieByID(w http.ResponseWriter, r *http.Request) {
 	path := strings.Trim(r.URL.Path, "/")
 	parts := strings.Split(path, "/")
@@ -124,44 +157,109 @@ func (h *MovieHandler) GetTopMovies(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, movies)
 }
 
-type StatsResponse struct {
-	TotalDuration  int      `json:"total_duration"`
-	TotalTickets   int      `json:"total_tickets"`
-	FavoriteGenres []string `json:"favorite_genres"`
+// MyStats handles GET /me/stats: the caller's own viewing statistics.
+func (h *MovieHandler) MyStats(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	stats, err := h.store.UserStats(user.ID)
+	if err != nil {
+		log.Printf("Error stats: %v", err)
+		http.Error(w, "Failed to get stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
 }
 
-func (h *MovieHandler) GetStats(w http.ResponseWriter, r *http.Request) {
-	var stats StatsResponse
+// Wrapped handles GET /me/wrapped?year=2026: the caller's year in review.
+// The year defaults to the current one.
+func (h *MovieHandler) Wrapped(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	year, ok, err := queryInt(r.URL.Query(), "year")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !ok {
+		year = time.Now().Year()
+	}
+	if year < 1900 || year > time.Now().Year() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "year out of range"})
+		return
+	}
 
-	queryStats := `
-        SELECT COALESCE(SUM(m.duration), 0), COUNT(t.id) 
-        FROM tickets t
-        JOIN movies m ON t.session_id = m.id`
+	stats, err := h.store.GetYearlyStats(user.ID, year)
+	if err != nil {
+		log.Printf("Error wrapped: %v", err)
+		http.Error(w, "Failed to get stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
 
-	err := h.store.db.QueryRow(queryStats).Scan(&stats.TotalDuration, &stats.TotalTickets)
+func (h *MovieHandler) Search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q is required"})
+		return
+	}
+	limit, _, err := queryInt(r.URL.Query(), "limit")
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		limit = defaultPageLimit
+	}
+
+	results, err := h.store.SearchMovies(q, limit)
 	if err != nil {
-		log.Printf("Error stats: %v", err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []MovieSearchResult{}
 	}
+	writeJSON(w, http.StatusOK, results)
+}
 
-	queryGenres := `
-        SELECT m.genre 
-        FROM tickets t
-        JOIN movies m ON t.session_id = m.id
-        GROUP BY m.genre
-        ORDER BY COUNT(t.id) DESC
-        LIMIT 3`
-
-	rows, err := h.store.db.Query(queryGenres)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var g string
-			if err := rows.Scan(&g); err == nil {
-				stats.FavoriteGenres = append(stats.FavoriteGenres, g)
-			}
-		}
+func (h *MovieHandler) Suggest(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(q)) < 2 {
+		writeJSON(w, http.StatusOK, []MovieSuggestion{})
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	suggestions, err := h.store.SuggestTitles(q, 8)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if suggestions == nil {
+		suggestions = []MovieSuggestion{}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, suggestions)
+}
+
+func (h *MovieHandler) Recommendations(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	limit, _, err := queryInt(r.URL.Query(), "limit")
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		limit = 10
+	}
+
+	recs, err := h.store.Recommend(user.ID, limit)
+	if err != nil {
+		http.Error(w, "Failed to build recommendations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, recs)
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type OrderHandler struct {
+	store      *MovieStore
+	vatPercent float64 // prices include VAT at this rate
+}
+
+func NewOrderHandler(store *MovieStore, vatPercent float64) *OrderHandler {
+	return &OrderHandler{store: store, vatPercent: vatPercent}
+}
+
+// Receipt handles GET /orders/{id}/receipt.pdf for the customer and admins.
+func (h *OrderHandler) Receipt(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	order, err := h.store.GetOrder(id)
+	if errors.Is(err, ErrOrderNotFound) || (err == nil && order.UserID != user.ID && user.Role != "admin") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order not found"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeReceiptPDF(&buf, order, h.vatPercent); err != nil {
+		http.Error(w, "Failed to render receipt: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%d.pdf"`, order.ID))
+	w.Header().Set("Cache-Control", "private, no-store")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+)
+
+type PricingHandler struct {
+	store *MovieStore
+}
+
+func NewPricingHandler(store *MovieStore) *PricingHandler {
+	return &PricingHandler{store: store}
+}
+
+func (h *PricingHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.store.ListPricingRules()
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// CreateRule handles POST /pricing/rules, e.g. a matinee discount:
+//
+//	{"name": "Matinee", "group": "discount", "adjust": "percent", "value": -30,
+//	 "starts_before": "12:00", "active": true}
+func (h *PricingHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	var rule pricing.Rule
+	if err := readJSON(r, &rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	created, err := h.store.CreatePricingRule(rule)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *PricingHandler) GetRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	rule, err := h.store.GetPricingRule(id)
+	if errors.Is(err, ErrRuleNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// UpdateRule handles PUT /pricing/rules/{id} with the whole rule.
+func (h *PricingHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var rule pricing.Rule
+	if err := readJSON(r, &rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	rule.ID = id
+	updated, err := h.store.UpdatePricingRule(rule)
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, updated)
+	}
+}
+
+func (h *PricingHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	err := h.store.DeletePricingRule(id)
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Quote handles GET /pricing/quote?session_id=&seat=&tariff=. Instead of a
+// seat, category prices any seat of that category. The response lists
+// every step from the base price and every rule that did not apply, with
+// the reason.
+func (h *PricingHandler) Quote(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sessionID, ok, err := queryInt(q, "session_id")
+	if err == nil && (!ok || sessionID <= 0) {
+		err = errors.New("session_id is required")
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	seat, hasSeat, err := queryInt(q, "seat")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	tariff := q.Get("tariff")
+	if tariff == "" {
+		tariff = pricing.Tariffs[0]
+	}
+	if !slices.Contains(pricing.Tariffs, tariff) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "tariff must be adult, child, student or senior"})
+		return
+	}
+	category := q.Get("category")
+	if category == "" {
+		category = models.SeatStandard
+	}
+	if !slices.Contains(models.SeatCategories, category) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category must be one of standard, vip, couple, accessible"})
+		return
+	}
+
+	si, err := h.store.GetSession(sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hasSeat && (seat < 1 || seat > si.TotalSeats) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": ErrInvalidSeat.Error()})
+		return
+	}
+
+	var sq SeatQuote
+	if hasSeat {
+		sq, err = h.store.QuoteSeat(si, seat, tariff)
+	} else {
+		var prices map[string]models.CategoryPrice
+		var rules []pricing.Rule
+		if prices, err = h.store.CategoryPrices(si.ID); err == nil {
+			if rules, err = h.store.ListPricingRules(); err == nil {
+				sq = quote(si, category, tariff, prices, rules)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, sq)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type ReportHandler struct {
+	store *MovieStore
+}
+
+func NewReportHandler(store *MovieStore) *ReportHandler {
+	return &ReportHandler{store: store}
+}
+
+// reportRange reads from/to; the default is the last 30 days. A plain "to"
+// date includes that whole day.
+func reportRange(q url.Values) (time.Time, time.Time, error) {
+	to, ok, err := queryTime(q, "to")
+	if err != nil {
+		return to, to, err
+	}
+	if !ok {
+		to = time.Now()
+	} else if len(q.Get("to")) == len("2006-01-02") {
+		to = to.AddDate(0, 0, 1)
+	}
+	from, ok, err := queryTime(q, "from")
+	if err != nil {
+		return from, to, err
+	}
+	if !ok {
+		from = to.AddDate(0, 0, -30)
+	}
+	return from, to, nil
+}
+
+// BoxOffice handles GET /reports/box-office?from=&to=.
+func (h *ReportHandler) BoxOffice(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	rep, err := h.store.BoxOffice(from, to)
+	if err != nil {
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// TimeSeries handles GET /reports/timeseries?interval=hour|day|week&from=&to=
+// with optional movie_id, hall_id, session_id and genre filters. It reads the
+// rollup tables, so numbers lag by up to one refresh interval.
+func (h *ReportHandler) TimeSeries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := reportRange(q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	f := SeriesFilter{Interval: q.Get("interval"), From: from, To: to, Genre: q.Get("genre")}
+	if f.Interval == "" {
+		f.Interval = "day"
+	}
+	for key, dst := range map[string]*int{"movie_id": &f.MovieID, "hall_id": &f.HallID, "session_id": &f.SessionID} {
+		if *dst, _, err = queryInt(q, key); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := f.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	ts, err := h.store.TimeSeries(f)
+	if err != nil {
+		http.Error(w, "Failed to build series: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ts)
+}
+
+// Forecast handles GET /reports/forecast?days=7&accuracy_days=90: predicted
+// attendance of upcoming sessions and how past forecasts did. It writes
+// nothing; the rollup job records the forecasts that accuracy is measured on.
+func (h *ReportHandler) Forecast(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days, ok, err := queryInt(q, "days")
+	if err != nil || (ok && (days < 1 || days > maxForecastDays)) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("days must be 1..%d", maxForecastDays)})
+		return
+	}
+	if !ok {
+		days = defaultForecastDays
+	}
+	accuracyDays, ok, err := queryInt(q, "accuracy_days")
+	if err != nil || (ok && accuracyDays < 1) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "accuracy_days must be a positive number"})
+		return
+	}
+	if !ok {
+		accuracyDays = 90
+	}
+
+	forecasts, err := h.store.Forecasts(days)
+	if err != nil {
+		http.Error(w, "Failed to forecast: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accuracy, err := h.store.ForecastAccuracy(time.Now().AddDate(0, 0, -accuracyDays))
+	if err != nil {
+		http.Error(w, "Failed to measure accuracy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"generated_at": time.Now(),
+		"forecasts":    forecasts,
+		"accuracy":     accuracy,
+	})
+}
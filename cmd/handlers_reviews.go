@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type ReviewHandler struct {
+	store  *MovieStore
+	filter *WordFilter
+}
+
+func NewReviewHandler(store *MovieStore, filter *WordFilter) *ReviewHandler {
+	return &ReviewHandler{store: store, filter: filter}
+}
+
+func reviewError(w http.ResponseWriter, err error) {
+	var input reviewInputError
+	switch {
+	case errors.As(err, &input):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrReviewNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrNotAttended), errors.Is(err, ErrNotReviewAuthor):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrReviewExists):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("[ERROR]: review: %v", err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + name})
+		return 0, false
+	}
+	return id, true
+}
+
+// MovieReviews handles GET (public) on /movies/{id}/reviews.
+func (h *ReviewHandler) MovieReviews(w http.ResponseWriter, r *http.Request) {
+	movieID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	page, err := parsePage(r.URL.Query(), reviewSortFields, "-created_at", "r.id DESC")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	reviews, total, err := h.store.ListReviews(movieID, 0, page)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, reviews, total))
+}
+
+// Create handles POST /movies/{id}/reviews.
+func (h *ReviewHandler) Create(w http.ResponseWriter, r *http.Request) {
+	movieID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Rating int    `json:"rating"`
+		Body   string `json:"body"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	if _, ok := h.store.Get(movieID); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "movie not found"})
+		return
+	}
+
+	rv, err := h.store.CreateReview(user.ID, movieID, req.Rating, req.Body, h.filter.Check(req.Body))
+	if err != nil {
+		reviewError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rv)
+}
+
+// MyReviews handles GET /me/reviews.
+func (h *ReviewHandler) MyReviews(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	page, err := parsePage(r.URL.Query(), reviewSortFields, "-created_at", "r.id DESC")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	reviews, total, err := h.store.ListReviews(0, user.ID, page)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, reviews, total))
+}
+
+// ReviewByID handles PATCH and DELETE on /reviews/{id}. Authors may edit and
+// delete their own reviews; admins may delete any.
+func (h *ReviewHandler) ReviewByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var p ReviewPatch
+		if err := readJSON(r, &p); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			return
+		}
+		flag := ""
+		if p.Body != nil {
+			flag = h.filter.Check(*p.Body)
+		}
+		rv, err := h.store.UpdateReview(id, user.ID, p, flag)
+		if err != nil {
+			reviewError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rv)
+
+	case http.MethodDelete:
+		if err := h.store.DeleteReview(id, user.ID, user.Role == "admin", ""); err != nil {
+			reviewError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// Report handles POST /reviews/{id}/report.
+func (h *ReviewHandler) Report(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+
+	if err := h.store.ReportReview(id, user.ID, req.Reason); err != nil {
+		if errors.Is(err, ErrAlreadyReported) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		reviewError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"status": "reported"})
+}
+
+// Queue handles GET /moderation/reviews.
+func (h *ReviewHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query(), map[string]string{}, "", "")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	items, total, err := h.store.ModerationQueue(page)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, items, total))
+}
+
+// Moderate handles POST /moderation/reviews/{id} with
+// {"action": "approve|hide|delete", "reason": "..."}.
+func (h *ReviewHandler) Moderate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	moderator, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+
+	if err := h.store.Moderate(id, moderator.ID, req.Action, req.Reason); err != nil {
+		reviewError(w, err)
+		return
+	}
+	log.Printf("[MODERATION]: %s applied %q to review %d", moderator.Email, req.Action, id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": req.Action})
+}
+
+// History handles GET /moderation/reviews/{id}/history.
+func (h *ReviewHandler) History(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	events, err := h.store.ReviewHistory(id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
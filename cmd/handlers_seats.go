@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/seating"
+)
+
+const (
+	seatHoldTTL      = 5 * time.Minute
+	seatPing         = 15 * time.Second
+	seatWriteTimeout = 10 * time.Second
+)
+
+// SeatHandler serves the seat map of a session, seat holds and the live
+// stream of seat changes.
+type SeatHandler struct {
+	store   *MovieStore
+	hub     *SeatHub
+	scoring seating.Scoring // default weights for best-seat suggestions
+}
+
+func NewSeatHandler(store *MovieStore, hub *SeatHub, scoring seating.Scoring) *SeatHandler {
+	return &SeatHandler{store: store, hub: hub, scoring: scoring}
+}
+
+// seatError writes the response for errors of the seat store functions.
+func seatError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidSeat):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrSessionCancelled), errors.Is(err, ErrSessionStarted), errors.Is(err, ErrSeatTaken),
+		errors.Is(err, ErrSeatHeld), errors.Is(err, ErrTooManyHolds), errors.Is(err, ErrCompanionSeat):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Map handles GET /sessions/{id}/seats.
+func (h *SeatHandler) Map(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	m, err := h.store.SeatMap(id)
+	if err != nil {
+		seatError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// Best handles GET /sessions/{id}/seats/best?party=2&limit=3&category=vip,
+// suggesting the best blocks of adjacent free seats, optionally of one
+// category. Held seats count as taken. The scoring weights can be
+// overridden with center, row, gap and ideal_row.
+func (h *SeatHandler) Best(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	party, limit, sc := 1, 3, h.scoring
+	for _, p := range []struct {
+		key string
+		dst *int
+		max int
+	}{{"party", &party, maxHoldsPerUser}, {"limit", &limit, 10}} {
+		n, set, err := queryInt(q, p.key)
+		if err == nil && set && (n < 1 || n > p.max) {
+			err = fmt.Errorf("%s must be between 1 and %d", p.key, p.max)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if set {
+			*p.dst = n
+		}
+	}
+	for key, dst := range map[string]*float64{"center": &sc.Center, "row": &sc.Row, "gap": &sc.Gap, "ideal_row": &sc.IdealRow} {
+		f, set, err := queryFloat(q, key)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if set {
+			*dst = f
+		}
+	}
+	category := q.Get("category")
+	if category != "" && !slices.Contains(models.SeatCategories, category) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "category must be one of standard, vip, couple, accessible"})
+		return
+	}
+	if err := sc.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	m, err := h.store.SeatMap(id)
+	if err != nil {
+		seatError(w, err)
+		return
+	}
+	hall := seating.Hall{PerRow: m.SeatsPerRow, Taken: make([]bool, len(m.Seats))}
+	for i, s := range m.Seats {
+		// Companion seats are left out; they follow their accessible seat.
+		hall.Taken[i] = s.State != SeatFree || s.CompanionOf != nil || (category != "" && s.Category != category)
+	}
+	type suggestion struct {
+		Row   int         `json:"row"`
+		Seats []SeatState `json:"seats"`
+		Cost  float64     `json:"cost"`
+	}
+	suggestions := []suggestion{}
+	for _, b := range seating.Best(hall, party, limit, sc) {
+		sg := suggestion{Row: b.Row, Cost: b.Cost}
+		for _, seat := range b.Seats {
+			sg.Seats = append(sg.Seats, m.Seats[seat-1])
+		}
+		suggestions = append(suggestions, sg)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session_id": id, "party": party, "scoring": sc, "blocks": suggestions,
+	})
+}
+
+// Hold handles POST /sessions/{id}/seats/{seat}/hold, keeping the seat for
+// the caller for a few minutes while they check out.
+func (h *SeatHandler) Hold(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	seat, ok := pathID(w, r, "seat")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	expires, err := h.store.HoldSeat(id, seat, user.ID, seatHoldTTL)
+	if err != nil {
+		seatError(w, err)
+		return
+	}
+	h.hub.Publish(SeatEvent{SessionID: id, Seat: seat, State: SeatHeld})
+	writeJSON(w, http.StatusOK, map[string]any{"session_id": id, "seat": seat, "state": SeatHeld, "expires_at": expires})
+}
+
+// Release handles DELETE /sessions/{id}/seats/{seat}/hold.
+func (h *SeatHandler) Release(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	seat, ok := pathID(w, r, "seat")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	released, err := h.store.ReleaseSeat(id, seat, user.ID)
+	if err != nil {
+		seatError(w, err)
+		return
+	}
+	if !released {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "you do not hold this seat"})
+		return
+	}
+	h.hub.Publish(SeatEvent{SessionID: id, Seat: seat, State: SeatReleased})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stream handles GET /sessions/{id}/seats/stream, a Server-Sent Events
+// stream. It starts with a "snapshot" event holding the seat map, then
+// sends a "seat" event per change. A client too slow to keep up gets a new
+// snapshot instead of the changes it missed.
+func (h *SeatHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	// Subscribe before reading the map so no change falls in between.
+	sub, stop := h.hub.Subscribe(id)
+	defer stop()
+	m, err := h.store.SeatMap(id)
+	if err != nil {
+		seatError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// A client that stops reading blocks the write until the deadline,
+	// which then ends the stream.
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(seatWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	send := func(event string, data any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return write("event: %s\ndata: %s\n\n", event, b)
+	}
+
+	if err := write("retry: 3000\n\n"); err != nil {
+		return
+	}
+	if err := send("snapshot", m); err != nil {
+		return
+	}
+	ping := time.NewTicker(seatPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-sub.events:
+			ev.Row, ev.Number = seatPosition(ev.Seat, m.SeatsPerRow)
+			err = send("seat", ev)
+		case <-sub.resync:
+			for len(sub.events) > 0 {
+				<-sub.events
+			}
+			if m, err = h.store.SeatMap(id); err == nil {
+				err = send("snapshot", m)
+			}
+		case <-ping.C:
+			err = write(": ping\n\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
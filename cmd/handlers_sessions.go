@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"Final_1/internal/models"
+)
+
+type SessionHandler struct {
+	store *MovieStore
+}
+
+func NewSessionHandler(store *MovieStore) *SessionHandler {
+	return &SessionHandler{store: store}
+}
+
+func (h *SessionHandler) ListHalls(w http.ResponseWriter, r *http.Request) {
+	halls, err := h.store.ListHalls()
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, halls)
+}
+
+func (h *SessionHandler) CreateHall(w http.ResponseWriter, r *http.Request) {
+	var hall models.Hall
+	if err := readJSON(r, &hall); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	created, err := h.store.CreateHall(hall)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// ListSessions handles GET /sessions?movie_id=&from=&to=. By default it
+// returns the next seven days.
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	movieID, _, err := queryInt(q, "movie_id")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	from, ok, err := queryTime(q, "from")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !ok {
+		from = time.Now()
+	}
+	to, ok, err := queryTime(q, "to")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !ok {
+		to = from.AddDate(0, 0, 7)
+	}
+
+	sessions, err := h.store.ListSessions(movieID, from, to)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (h *SessionHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	si, err := h.store.GetSession(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, si)
+}
+
+func (h *SessionHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	var in models.Session
+	if err := readJSON(r, &in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	created, err := h.store.CreateSession(in)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// CancelSession handles POST /sessions/{id}/cancel {"reason": "..."}. Its
+// tickets are cancelled and their holders notified by email.
+func (h *SessionHandler) CancelSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			return
+		}
+	}
+	tickets, err := h.store.CancelSession(id, req.Reason)
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, ErrSessionCancelled), errors.Is(err, ErrSessionStarted):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session_id": id, "cancelled_tickets": tickets})
+}
+
+// HallSeats handles GET /halls/{id}/seats, every seat with its category.
+func (h *SessionHandler) HallSeats(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	seats, err := h.store.HallSeats(id)
+	if errors.Is(err, ErrHallNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, seats)
+}
+
+// SetHallSeats handles PUT /halls/{id}/seats with
+// [{"id": 5, "category": "accessible"}, {"id": 6, "companion_of": 5}],
+// replacing the hall's categories. Unlisted seats become standard.
+func (h *SessionHandler) SetHallSeats(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var seats []models.Seat
+	if err := readJSON(r, &seats); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	err := h.store.SetHallSeats(id, seats)
+	if errors.Is(err, ErrHallNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.HallSeats(w, r)
+}
+
+// CategoryPrices handles GET /sessions/{id}/prices.
+func (h *SessionHandler) CategoryPrices(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetSession(id); errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	prices, err := h.store.CategoryPrices(id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, prices)
+}
+
+// SetCategoryPrices handles PUT /sessions/{id}/prices with
+// [{"category": "vip", "multiplier": 1.5}, {"category": "couple", "surcharge": 500}].
+// Categories not listed keep their current adjustment.
+func (h *SessionHandler) SetCategoryPrices(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var prices []models.CategoryPrice
+	if err := readJSON(r, &prices); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	err := h.store.SetCategoryPrices(id, prices)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.CategoryPrices(w, r)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/qr"
+	"Final_1/internal/ticketcheck"
+)
+
+type TicketHandler struct {
+	store  *MovieStore
+	signer *TicketSigner
+}
+
+func NewTicketHandler(store *MovieStore, signer *TicketSigner) *TicketHandler {
+	return &TicketHandler{store: store, signer: signer}
+}
+
+// claimsFor builds the signed claims of a ticket; it expires a little after
+// its session ends.
+func (h *TicketHandler) claimsFor(ticketID, sessionID, seat int) (ticketcheck.Claims, error) {
+	session, err := h.store.GetSession(sessionID)
+	if err != nil {
+		return ticketcheck.Claims{}, err
+	}
+	end := session.Time.Add(time.Duration(session.Duration)*time.Minute + ticketGrace)
+	return ticketcheck.Claims{TicketID: ticketID, SessionID: sessionID, Seat: seat, Expires: end}, nil
+}
+
+// ownTicket loads a ticket of the caller that can still be used, with the
+// claims for its QR code. It writes the error response itself.
+func (h *TicketHandler) ownTicket(w http.ResponseWriter, r *http.Request, id int) (models.Ticket, ticketcheck.Claims, bool) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return models.Ticket{}, ticketcheck.Claims{}, false
+	}
+	// GetTicket only finds the caller's own tickets, so others get a 404.
+	t, err := h.store.GetTicket(id, user.ID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "ticket not found"})
+		return t, ticketcheck.Claims{}, false
+	}
+	if strings.EqualFold(t.Status, "CANCELLED") {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "ticket is cancelled"})
+		return t, ticketcheck.Claims{}, false
+	}
+	claims, err := h.claimsFor(t.ID, t.SessionID, t.SeatID)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return t, ticketcheck.Claims{}, false
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return t, ticketcheck.Claims{}, false
+	}
+	return t, claims, true
+}
+
+// QR handles GET /tickets/{id}/qr?format=png|svg for the ticket's owner.
+func (h *TicketHandler) QR(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be png or svg"})
+		return
+	}
+	t, claims, ok := h.ownTicket(w, r, id)
+	if !ok {
+		return
+	}
+
+	code, err := qr.Encode([]byte(h.signer.Sign(claims)))
+	if err != nil {
+		http.Error(w, "Failed to encode QR code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(w, 8)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		err = code.WritePNG(w, 8)
+	}
+	if err != nil {
+		log.Printf("Writing QR code of ticket %d: %v", t.ID, err)
+	}
+}
+
+// PDF handles GET /tickets/{id}.pdf, a printable ticket for its owner. The
+// mux cannot match a wildcard followed by a suffix, so the route is
+// /tickets/{file} and anything but <id>.pdf is a 404.
+func (h *TicketHandler) PDF(w http.ResponseWriter, r *http.Request) {
+	name, isPDF := strings.CutSuffix(r.PathValue("file"), ".pdf")
+	id, err := strconv.Atoi(name)
+	if !isPDF || err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	t, claims, ok := h.ownTicket(w, r, id)
+	if !ok {
+		return
+	}
+	session, err := h.store.GetSession(t.SessionID)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeTicketPDF(&buf, t, session, user.Name, h.signer.Sign(claims)); err != nil {
+		http.Error(w, "Failed to render ticket: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="ticket-%d.pdf"`, t.ID))
+	w.Header().Set("Cache-Control", "private, no-store")
+	buf.WriteTo(w)
+}
+
+// Cancel handles POST /tickets/{id}/cancel: the owner gives a ticket back
+// before its session starts.
+func (h *TicketHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	t, err := h.store.CancelTicket(id, user.ID)
+	switch {
+	case errors.Is(err, ErrTicketNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, ErrTicketNotBooked), errors.Is(err, ErrSessionStarted):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// CheckIn handles POST /checkin {"payload": "<scanned QR text>", "session_id": 12}
+// for ushers. Rejections are 200 responses with accepted=false and a reason;
+// only malformed requests get an error status.
+func (h *TicketHandler) CheckIn(w http.ResponseWriter, r *http.Request) {
+	usher, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	var req struct {
+		Payload   string `json:"payload"`
+		SessionID int    `json:"session_id"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	if req.Payload == "" || req.SessionID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "payload and session_id are required"})
+		return
+	}
+
+	claims, err := h.signer.Verify(req.Payload)
+	if err != nil {
+		writeJSON(w, http.StatusOK, CheckInResult{Reason: ticketcheck.ReasonForged})
+		return
+	}
+	res, err := h.store.CheckIn(claims, req.SessionID, usher.ID, time.Now())
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res.Accepted && !res.Repeat {
+		log.Printf("[CHECK-IN]: ticket %d admitted to session %d by %s", res.TicketID, res.SessionID, usher.Email)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Manifest handles GET /sessions/{id}/manifest: the signed ticket list an
+// offline scanner validates against.
+func (h *TicketHandler) Manifest(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	m, err := h.store.SessionManifest(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signed, err := h.signer.SignManifest(m)
+	if err != nil {
+		http.Error(w, "Failed to sign manifest: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, signed)
+}
+
+// PublicKey handles GET /checkin/key, the key scanners pin to verify ticket
+// payloads and manifests.
+func (h *TicketHandler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"alg":        "Ed25519",
+		"public_key": base64.RawURLEncoding.EncodeToString(h.signer.PublicKey()),
+	})
+}
+
+// Sync handles POST /checkin/sync {"device_id": "...", "scans": [...]}, the
+// upload of an offline scanner's log.
+func (h *TicketHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	usher, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	var req struct {
+		DeviceID string             `json:"device_id"`
+		Scans    []ticketcheck.Scan `json:"scans"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	req.DeviceID = strings.TrimSpace(req.DeviceID)
+	if req.DeviceID == "" || req.DeviceID == "online" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "device_id is required"})
+		return
+	}
+
+	scans := make([]OfflineScan, 0, len(req.Scans))
+	for _, sc := range req.Scans {
+		if sc.ScanID == "" || sc.ScannedAt.IsZero() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "every scan needs scan_id and scanned_at"})
+			return
+		}
+		scan := OfflineScan{Scan: sc}
+		if c, err := h.signer.Verify(sc.Payload); err == nil {
+			scan.Claims = &c
+		}
+		scans = append(scans, scan)
+	}
+
+	results, err := h.store.SyncScans(usher.ID, req.DeviceID, scans)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
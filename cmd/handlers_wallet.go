@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+
+	"Final_1/internal/wallet"
+)
+
+// WalletHandler serves tickets as phone wallet passes. Either wallet may be
+// nil when it is not configured.
+type WalletHandler struct {
+	tickets *TicketHandler
+	apple   *wallet.PassSigner
+	google  *wallet.GoogleIssuer
+}
+
+func NewWalletHandler(tickets *TicketHandler, apple *wallet.PassSigner, google *wallet.GoogleIssuer) *WalletHandler {
+	return &WalletHandler{tickets: tickets, apple: apple, google: google}
+}
+
+// Available handles GET /wallets: which wallets passes can be added to, so
+// clients only offer those.
+func (h *WalletHandler) Available(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"apple": h.apple != nil, "google": h.google != nil})
+}
+
+// ticket loads the caller's ticket for a pass; ok is false once the error
+// response has been written.
+func (h *WalletHandler) ticket(w http.ResponseWriter, r *http.Request) (wallet.Ticket, bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return wallet.Ticket{}, false
+	}
+	t, claims, ok := h.tickets.ownTicket(w, r, id)
+	if !ok {
+		return wallet.Ticket{}, false
+	}
+	session, err := h.tickets.store.GetSession(t.SessionID)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return wallet.Ticket{}, false
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return wallet.Ticket{}, false
+	}
+	return walletTicket(t, session, user.Name, h.tickets.signer.Sign(claims), claims.Expires), true
+}
+
+// Apple handles GET /tickets/{id}/wallet.pkpass.
+func (h *WalletHandler) Apple(w http.ResponseWriter, r *http.Request) {
+	if h.apple == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Apple Wallet is not configured"})
+		return
+	}
+	t, ok := h.ticket(w, r)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := h.apple.WritePKPass(&buf, wallet.EventPass(t)); err != nil {
+		log.Printf("[ERROR]: Signing pass for ticket %d: %v", t.ID, err)
+		http.Error(w, "Failed to sign pass", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.pkpass")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%d.pkpass"`, t.ID))
+	w.Header().Set("Cache-Control", "private, no-store")
+	buf.WriteTo(w)
+}
+
+// Google handles GET /tickets/{id}/wallet/google: {"save_url": "..."}.
+func (h *WalletHandler) Google(w http.ResponseWriter, r *http.Request) {
+	if h.google == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Google Wallet is not configured"})
+		return
+	}
+	t, ok := h.ticket(w, r)
+	if !ok {
+		return
+	}
+	link, err := h.google.SaveLink(t)
+	if err != nil {
+		log.Printf("[ERROR]: Signing Google Wallet link for ticket %d: %v", t.ID, err)
+		http.Error(w, "Failed to sign save link", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"save_url": link})
+}
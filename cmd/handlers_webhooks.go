@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// WebhookHandler lets admins manage webhook subscriptions and inspect and
+// retry their deliveries.
+type WebhookHandler struct {
+	store  *MovieStore
+	client *http.Client
+}
+
+func NewWebhookHandler(store *MovieStore, client *http.Client) *WebhookHandler {
+	return &WebhookHandler{store: store, client: client}
+}
+
+// Create handles POST /webhooks with {"url", "events", "description"}. The
+// response is the only time the signing secret is shown.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	sub, err := h.store.CreateWebhook(WebhookSubscription{URL: req.URL, Events: req.Events, Description: req.Description})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.store.ListWebhooks()
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, subs)
+}
+
+// Update handles PATCH /webhooks/{id} with {"active": bool}.
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Active *bool `json:"active"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Active == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"active": true|false}`})
+		return
+	}
+	h.finish(w, id, h.store.SetWebhookActive(id, *req.Active))
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	h.finish(w, id, h.store.DeleteWebhook(id))
+}
+
+func (h *WebhookHandler) finish(w http.ResponseWriter, id int, err error) {
+	switch {
+	case errors.Is(err, ErrWebhookNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Deliveries handles GET /webhooks/{id}/deliveries?status=pending|delivered|failed.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains([]string{"pending", "delivered", "failed"}, status) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	page, err := parsePage(r.URL.Query(), map[string]string{}, "", "")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	items, total, err := h.store.ListWebhookDeliveries(id, status, page)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(r, page, items, total))
+}
+
+// Delivery handles GET /webhook-deliveries/{id}, a delivery with its
+// attempt log.
+func (h *WebhookHandler) Delivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	d, err := h.store.GetWebhookDelivery(id)
+	if errors.Is(err, ErrDeliveryNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// Redeliver handles POST /webhook-deliveries/{id}/redeliver. It sends the
+// original payload right away, with a fresh signature, and returns the
+// attempt. A success marks the delivery delivered; a failure leaves its
+// retry schedule as it was.
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	d, err := h.store.GetWebhookDelivery(id)
+	if errors.Is(err, ErrDeliveryNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a := sendWebhook(h.client, d, true)
+	status := d.Status
+	if a.Error == "" {
+		status = "delivered"
+	}
+	if err := h.store.RecordWebhookAttempt(d, a, status, a.AttemptedAt); err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims is the payload of every token we issue.
+type Claims struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeyRing holds every key that may verify a token. Only the active key signs
+// new tokens; the others stay in the ring until old tokens have expired.
+type KeyRing struct {
+	mu     sync.RWMutex
+	keys   map[string]*signingKey
+	active string
+	dir    string // where rotated keys are written; empty keeps them in memory
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string]*signingKey{}}
+}
+
+// LoadKeyRing reads every *.pem private key in dir (RSA or Ed25519, PKCS#1 or
+// PKCS#8). The file name without extension becomes the kid. activeKid picks
+// the signing key; when empty the last kid in sorted order is used, so naming
+// files by date rotates automatically. An empty dir gets a fresh Ed25519 key
+// written to it. With no dir at all the key lives in memory only, so every
+// restart logs everyone out; that is only acceptable for local development.
+func LoadKeyRing(dir, activeKid string) (*KeyRing, error) {
+	kr := NewKeyRing()
+	if dir == "" {
+		log.Print("JWT_KEYS_DIR is not set: using an ephemeral signing key, tokens will not survive a restart")
+		if _, err := kr.Rotate("EdDSA"); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+	kr.dir = dir
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		key, err := parsePrivateKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		kr.keys[kid] = key
+		kr.active = kid
+	}
+	if len(kr.keys) == 0 {
+		if activeKid != "" {
+			return nil, errors.New("active key " + activeKid + " not found")
+		}
+		kid, err := kr.Rotate("EdDSA")
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("No signing keys in %s, generated %s", dir, kid)
+		return kr, nil
+	}
+	if activeKid != "" {
+		if _, ok := kr.keys[activeKid]; !ok {
+			return nil, errors.New("active key " + activeKid + " not found")
+		}
+		kr.active = activeKid
+	}
+	return kr, nil
+}
+
+func parsePrivateKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+
+	var raw any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		raw, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		raw, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	default:
+		return nil, errors.New("unsupported key type, use RSA or Ed25519")
+	}
+}
+
+// Rotate generates a fresh key, makes it the signing key and keeps the
+// previous ones for verification. alg is "RS256" or "EdDSA". The kid starts
+// with the time so it sorts last, and the key is written to the key dir
+// before it is used, so a restart keeps signing with it.
+func (kr *KeyRing) Rotate(alg string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+	var key *signingKey
+
+	switch alg {
+	case "RS256":
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		key = &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: priv, public: &priv.PublicKey}
+	case "EdDSA", "":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		key = &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: priv, public: pub}
+	default:
+		return "", errors.New("unsupported alg: " + alg)
+	}
+	if err := kr.save(key); err != nil {
+		return "", err
+	}
+
+	kr.mu.Lock()
+	kr.keys[kid] = key
+	kr.active = kid
+	kr.mu.Unlock()
+	return kid, nil
+}
+
+// save writes key to the key dir as PKCS#8 PEM. The file appears under its
+// final name only once it is complete.
+func (kr *KeyRing) save(key *signingKey) error {
+	if kr.dir == "" {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(kr.dir, ".key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := pem.Encode(tmp, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(kr.dir, key.kid+".pem"))
+}
+
+// Retire removes a key so tokens signed with it stop verifying. Its file is
+// renamed to *.pem.retired so it is not loaded again on restart.
+func (kr *KeyRing) Retire(kid string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kid == kr.active {
+		return errors.New("cannot retire the active key")
+	}
+	if _, ok := kr.keys[kid]; !ok {
+		return errors.New("key not found")
+	}
+	if kr.dir != "" {
+		f := filepath.Join(kr.dir, kid+".pem")
+		if err := os.Rename(f, f+".retired"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(kr.keys, kid)
+	return nil
+}
+
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	kr.mu.RLock()
+	key := kr.keys[kr.active]
+	kr.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("no signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resolves the verification key from the token's kid and refuses
+// any algorithm other than the one the key was created for.
+func (kr *KeyRing) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	kr.mu.RLock()
+	key := kr.keys[kid]
+	kr.mu.RUnlock()
+	if key == nil {
+		return nil, errors.New("unknown kid")
+	}
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+func (kr *KeyRing) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, kr.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func (kr *KeyRing) JWKS() []JWK {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	b64 := base64.RawURLEncoding
+	set := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := kr.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64.EncodeToString(pub.N.Bytes())
+			jwk.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64.EncodeToString(pub)
+		}
+		set = append(set, jwk)
+	}
+	return set
+}
+
+func (kr *KeyRing) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, map[string][]JWK{"keys": kr.JWKS()})
+}
+
+// RotateHandler lets an admin roll the signing key without a restart.
+func (kr *KeyRing) RotateHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Alg string `json:"alg"`
+		}
+		if r.ContentLength > 0 {
+			if err := readJSON(r, &req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+				return
+			}
+		}
+		kid, err := kr.Rotate(req.Alg)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"kid": kid})
+
+	case http.MethodDelete:
+		if err := kr.Retire(r.URL.Query().Get("kid")); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "retired"})
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// tokenFromRequest prefers an Authorization: Bearer header and falls back to
+// the cookie set by the web UI.
+func tokenFromRequest(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", false
+		}
+		return strings.TrimSpace(token), true
+	}
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
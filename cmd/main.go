@@ -4,17 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata" // CINEMA_TZ must load on hosts without a zoneinfo database
 
 	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+	"Final_1/internal/seating"
 	_ "github.com/lib/pq"
 )
 
@@ -23,11 +30,28 @@ var (
 
 	store *MovieStore
 	h     *MovieHandler
+	keys  *KeyRing
+
+	// cinemaTZ is where the cinema is, from CINEMA_TZ. Pricing rules about
+	// weekdays and times of day are read in it, whatever the server's zone.
+	cinemaTZ = time.Local
 
 	tickets = map[int]models.Ticket{}
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "wallet" {
+		os.Exit(runWalletCLI(os.Args[2:]))
+	}
+	if name := os.Getenv("CINEMA_TZ"); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			log.Fatal("Invalid CINEMA_TZ: ", err)
+		}
+		cinemaTZ = loc
+	} else {
+		log.Printf("CINEMA_TZ not set, pricing rules use the server's time zone (%s)", time.Local)
+	}
 	connStr := "user=postgres password=exzou8520 dbname=ADV host=localhost port=5432 sslmode=disable"
 
 	var err error
@@ -39,6 +63,17 @@ func main() {
 	if err := store.db.Ping(); err != nil {
 		log.Fatal("Database is unavailable: ", err)
 	}
+	if err := store.Migrate(); err != nil {
+		log.Fatal("Unable to migrate the database: ", err)
+	}
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(store, os.Args[1:]))
+	}
+
+	keys, err = LoadKeyRing(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		log.Fatal("Unable to load signing keys: ", err)
+	}
 
 	h = NewMovieHandler(store)
 	movieHandler := &MovieHandler{store: store}
@@ -47,21 +82,166 @@ func main() {
 	http.Handle("/", fs)
 
 	adminOnly := AuthMiddleware("admin")
+	usherOnly := AuthMiddleware("usher")
 	anyUser := AuthMiddleware("user")
 
 	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("/.well-known/jwks.json", keys.JWKSHandler)
+	http.HandleFunc("/auth/keys", adminOnly(keys.RotateHandler))
+
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirect := os.Getenv("OIDC_REDIRECT_URL")
+		if redirect == "" {
+			redirect = "http://localhost:8080/auth/oidc/callback"
+		}
+		oidc := NewOIDCClient(OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirect,
+			RoleClaim:    os.Getenv("OIDC_ROLE_CLAIM"),
+			AdminValues:  strings.Split(envOr("OIDC_ADMIN_GROUPS", "cinema-admins"), ","),
+			UsherValues:  strings.Split(envOr("OIDC_USHER_GROUPS", "cinema-ushers"), ","),
+		}, store)
+		http.HandleFunc("/auth/oidc/login", oidc.LoginHandler)
+		http.HandleFunc("/auth/oidc/callback", oidc.CallbackHandler)
+	}
 	http.HandleFunc("/movies", h.Movies)
 	http.HandleFunc("/movies/top", movieHandler.GetTopMovies)
+	http.HandleFunc("/movies/search", h.Search)
+	http.HandleFunc("/movies/suggest", h.Suggest)
 
 	http.HandleFunc("/book", anyUser(bookHandler))
 	http.HandleFunc("/ticket", anyUser(ticketHandler))
-	http.HandleFunc("/tickets", anyUser(getAllTicketsHandler(store.db)))
-	http.HandleFunc("/movies/stats", anyUser(movieHandler.GetStats))
+	http.HandleFunc("/tickets", anyUser(getAllTicketsHandler(store)))
+
+	signer, err := LoadTicketSigner(os.Getenv("TICKET_SIGNING_KEY"))
+	if err != nil {
+		log.Fatal("Unable to load ticket signing key: ", err)
+	}
+	ticketsAPI := NewTicketHandler(store, signer)
+	http.HandleFunc("GET /tickets/{id}/qr", anyUser(ticketsAPI.QR))
+	http.HandleFunc("GET /tickets/{file}", anyUser(ticketsAPI.PDF))
+	http.HandleFunc("POST /tickets/{id}/cancel", anyUser(ticketsAPI.Cancel))
+
+	applePasses, googlePasses, err := loadWallets()
+	if err != nil {
+		log.Fatal("Unable to load wallet credentials: ", err)
+	}
+	wallets := NewWalletHandler(ticketsAPI, applePasses, googlePasses)
+	http.HandleFunc("GET /wallets", wallets.Available)
+	http.HandleFunc("GET /tickets/{id}/wallet.pkpass", anyUser(wallets.Apple))
+	http.HandleFunc("GET /tickets/{id}/wallet/google", anyUser(wallets.Google))
+	http.HandleFunc("POST /checkin", usherOnly(ticketsAPI.CheckIn))
+	http.HandleFunc("GET /checkin/key", ticketsAPI.PublicKey)
+	http.HandleFunc("POST /checkin/sync", usherOnly(ticketsAPI.Sync))
+	http.HandleFunc("GET /sessions/{id}/manifest", usherOnly(ticketsAPI.Manifest))
+
+	vatPercent, err := strconv.ParseFloat(envOr("VAT_PERCENT", "12"), 64)
+	if err != nil || vatPercent < 0 {
+		log.Fatal("Invalid VAT_PERCENT: ", os.Getenv("VAT_PERCENT"))
+	}
+	orders := NewOrderHandler(store, vatPercent)
+	http.HandleFunc("GET /orders/{id}/receipt.pdf", anyUser(orders.Receipt))
+
+	webhookClient := &http.Client{Timeout: webhookTimeout}
+	webhooks := NewWebhookHandler(store, webhookClient)
+	http.HandleFunc("POST /webhooks", adminOnly(webhooks.Create))
+	http.HandleFunc("GET /webhooks", adminOnly(webhooks.List))
+	http.HandleFunc("PATCH /webhooks/{id}", adminOnly(webhooks.Update))
+	http.HandleFunc("DELETE /webhooks/{id}", adminOnly(webhooks.Delete))
+	http.HandleFunc("GET /webhooks/{id}/deliveries", adminOnly(webhooks.Deliveries))
+	http.HandleFunc("GET /webhook-deliveries/{id}", adminOnly(webhooks.Delivery))
+	http.HandleFunc("POST /webhook-deliveries/{id}/redeliver", adminOnly(webhooks.Redeliver))
+
+	http.HandleFunc("/me/stats", anyUser(h.MyStats))
+	http.HandleFunc("/me/wrapped", anyUser(h.Wrapped))
+	http.HandleFunc("/me/recommendations", anyUser(h.Recommendations))
 
 	http.HandleFunc("/register", registerHandler)
+	http.HandleFunc("PUT /users/{id}/role", adminOnly(setRoleHandler))
+
+	sessions := NewSessionHandler(store)
+	http.HandleFunc("GET /halls", sessions.ListHalls)
+	http.HandleFunc("POST /halls", adminOnly(sessions.CreateHall))
+	http.HandleFunc("GET /halls/{id}/seats", sessions.HallSeats)
+	http.HandleFunc("PUT /halls/{id}/seats", adminOnly(sessions.SetHallSeats))
+	http.HandleFunc("GET /sessions", sessions.ListSessions)
+	http.HandleFunc("POST /sessions", adminOnly(sessions.CreateSession))
+	http.HandleFunc("GET /sessions/{id}", sessions.GetSession)
+	http.HandleFunc("POST /sessions/{id}/cancel", adminOnly(sessions.CancelSession))
+	http.HandleFunc("GET /sessions/{id}/prices", sessions.CategoryPrices)
+	http.HandleFunc("PUT /sessions/{id}/prices", adminOnly(sessions.SetCategoryPrices))
+
+	prices := NewPricingHandler(store)
+	http.HandleFunc("GET /pricing/quote", prices.Quote)
+	http.HandleFunc("GET /pricing/rules", adminOnly(prices.ListRules))
+	http.HandleFunc("POST /pricing/rules", adminOnly(prices.CreateRule))
+	http.HandleFunc("GET /pricing/rules/{id}", adminOnly(prices.GetRule))
+	http.HandleFunc("PUT /pricing/rules/{id}", adminOnly(prices.UpdateRule))
+	http.HandleFunc("DELETE /pricing/rules/{id}", adminOnly(prices.DeleteRule))
+
+	seatHub := NewSeatHub()
+	scoring := seating.DefaultScoring
+	if v := os.Getenv("SEAT_SCORING"); v != "" {
+		if err := json.Unmarshal([]byte(v), &scoring); err != nil {
+			log.Fatal("Invalid SEAT_SCORING: ", err)
+		}
+		if err := scoring.Validate(); err != nil {
+			log.Fatal("Invalid SEAT_SCORING: ", err)
+		}
+	}
+	seats := NewSeatHandler(store, seatHub, scoring)
+	http.HandleFunc("GET /sessions/{id}/seats", seats.Map)
+	http.HandleFunc("GET /sessions/{id}/seats/best", seats.Best)
+	http.HandleFunc("GET /sessions/{id}/seats/stream", seats.Stream)
+	http.HandleFunc("POST /sessions/{id}/seats/{seat}/hold", anyUser(seats.Hold))
+	http.HandleFunc("DELETE /sessions/{id}/seats/{seat}/hold", anyUser(seats.Release))
+
+	reports := NewReportHandler(store)
+	http.HandleFunc("/reports/box-office", adminOnly(reports.BoxOffice))
+	http.HandleFunc("GET /reports/timeseries", adminOnly(reports.TimeSeries))
+	http.HandleFunc("GET /reports/forecast", adminOnly(reports.Forecast))
+
+	exports := NewExportHandler(store)
+	http.HandleFunc("GET /tickets/export", anyUser(exports.Tickets))
+	http.HandleFunc("GET /reports/box-office/export", adminOnly(exports.BoxOffice))
+	http.HandleFunc("GET /orders/export", anyUser(exports.Orders))
+	http.HandleFunc("GET /refunds/export", anyUser(exports.Refunds))
 
 	http.HandleFunc("/movies/", adminOnly(h.MovieByID))
 
+	wordFilter, err := LoadWordFilter(os.Getenv("REVIEW_WORDLIST"))
+	if err != nil {
+		log.Fatal("Unable to load review word list: ", err)
+	}
+	reviews := NewReviewHandler(store, wordFilter)
+	http.HandleFunc("GET /movies/{id}/reviews", reviews.MovieReviews)
+	http.HandleFunc("POST /movies/{id}/reviews", anyUser(reviews.Create))
+	http.HandleFunc("/reviews/{id}", anyUser(reviews.ReviewByID))
+	http.HandleFunc("/me/reviews", anyUser(reviews.MyReviews))
+	http.HandleFunc("POST /reviews/{id}/report", anyUser(reviews.Report))
+	http.HandleFunc("GET /moderation/reviews", adminOnly(reviews.Queue))
+	http.HandleFunc("POST /moderation/reviews/{id}", adminOnly(reviews.Moderate))
+	http.HandleFunc("GET /moderation/reviews/{id}/history", adminOnly(reviews.History))
+
+	rollupEvery, err := time.ParseDuration(envOr("ROLLUP_INTERVAL", "5m"))
+	if err != nil {
+		log.Fatal("Invalid ROLLUP_INTERVAL: ", err)
+	}
+	go runRollups(store, rollupEvery)
+
+	remindBefore, err := time.ParseDuration(envOr("REMINDER_BEFORE", "3h"))
+	if err != nil {
+		log.Fatal("Invalid REMINDER_BEFORE: ", err)
+	}
+	dispatcher := NewDispatcher(store)
+	registerOutboxHandlers(dispatcher, seatHub)
+	go runOutbox(store, dispatcher, 2*time.Second)
+	go runNotifications(store, loadNotifier(), remindBefore, 30*time.Second)
+	go runWebhooks(store, webhookClient, 15*time.Second)
+	go runSeatHolds(store, seatHub, 5*time.Second)
+
 	go func() {
 		for {
 			time.Sleep(20 * time.Second)
@@ -78,6 +258,13 @@ func main() {
 	}
 }
 
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
 func bookHandler(w http.ResponseWriter, r *http.Request) {
 	email, ok := r.Context().Value(userEmailKey).(string)
 	if !ok {
@@ -93,53 +280,68 @@ func bookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		SessionID int `json:"session_id"`
-		SeatID    int `json:"seat_id"`
+		SessionID     int    `json:"session_id"`
+		SeatID        int    `json:"seat_id"`
+		Tariff        string `json:"tariff"`
+		PaymentMethod string `json:"payment_method"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-
-	movie, ok := store.Get(req.SessionID)
-	if !ok {
-		http.Error(w, "Movie not found", http.StatusNotFound)
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = paymentMethods[0]
+	}
+	if !slices.Contains(paymentMethods, req.PaymentMethod) {
+		http.Error(w, "Invalid payment method", http.StatusBadRequest)
+		return
+	}
+	if req.Tariff == "" {
+		req.Tariff = pricing.Tariffs[0]
+	}
+	if !slices.Contains(pricing.Tariffs, req.Tariff) {
+		http.Error(w, "Invalid tariff", http.StatusBadRequest)
 		return
 	}
 
-	var newID int
-	query := `
-		INSERT INTO tickets (session_id, seat_id, user_id, price, status) 
-		VALUES ($1, $2, $3, $4, $5) 
-		RETURNING id`
-
-	err = store.db.QueryRow(query, req.SessionID, req.SeatID, user.ID, movie.Price, "BOOKED").Scan(&newID)
+	session, err := store.GetSession(req.SessionID)
 	if err != nil {
-		log.Printf("[ERROR]: Failed to save ticket: %v", err)
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if session.CancelledAt != nil {
+		http.Error(w, "Session is cancelled", http.StatusConflict)
+		return
+	}
+	if req.SeatID < 1 || req.SeatID > session.TotalSeats {
+		http.Error(w, "Invalid seat", http.StatusBadRequest)
+		return
+	}
+	quote, err := store.QuoteSeat(session, req.SeatID, req.Tariff)
+	if err != nil {
+		log.Printf("[ERROR]: Failed to price seat: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	t := models.Ticket{
-		ID:        newID,
-		SessionID: req.SessionID,
-		SeatID:    req.SeatID,
-		UserID:    user.ID,
-		Status:    "BOOKED",
-		Price:     movie.Price,
+	t, err := store.BookTicket(user.ID, req.SessionID, req.SeatID, quote.Price, req.Tariff, req.PaymentMethod)
+	if errors.Is(err, ErrSeatTaken) || errors.Is(err, ErrSeatHeld) || errors.Is(err, ErrCompanionSeat) ||
+		errors.Is(err, ErrSessionCancelled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR]: Failed to save ticket: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
 	mu.Lock()
-	tickets[newID] = t
+	tickets[t.ID] = t
 	mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(t)
-
-	go func(ticketID int, price int) {
-		time.Sleep(3 * time.Second)
-		fmt.Printf("[SYSTEM]: Ticket #%d confirmed for %s. Price: %d KZT\n", ticketID, email, price)
-	}(t.ID, t.Price)
 }
 
 func ticketHandler(w http.ResponseWriter, r *http.Request) {
@@ -187,7 +389,7 @@ func ticketHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(t)
 }
 
-func getAllTicketsHandler(db *sql.DB) http.HandlerFunc {
+func getAllTicketsHandler(store *MovieStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		email, ok := r.Context().Value(userEmailKey).(string)
 		if !ok {
@@ -201,45 +403,77 @@ func getAllTicketsHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		var rows *sql.Rows
-		query := "SELECT id, price, status FROM tickets"
-
-		if user.Role == "admin" {
-			rows, err = db.Query(query + " ORDER BY id DESC")
-		} else {
-			rows, err = db.Query(query+" WHERE user_id = $1 ORDER BY id DESC", user.ID)
+		q := r.URL.Query()
+		page, err := parsePage(q, ticketSortFields, "-id", "id DESC")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		f, err := parseTicketFilter(q)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if user.Role != "admin" {
+			f.UserID = user.ID
 		}
 
+		items, total, err := store.ListTickets(f, page)
 		if err != nil {
 			http.Error(w, "Database query error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
+		writeJSON(w, http.StatusOK, newPage(r, page, items, total))
+	}
+}
 
-		type TicketItem struct {
-			ID     int    `json:"id"`
-			Price  int    `json:"price"`
-			Status string `json:"status"`
-		}
+func parseTicketFilter(q url.Values) (TicketFilter, error) {
+	f := TicketFilter{Status: strings.TrimSpace(q.Get("status"))}
 
-		var results []TicketItem
-		for rows.Next() {
-			var t TicketItem
-			if err := rows.Scan(&t.ID, &t.Price, &t.Status); err == nil {
-				results = append(results, t)
-			}
+	var err error
+	if f.UserID, _, err = queryInt(q, "user_id"); err != nil {
+		return f, err
+	}
+	if f.SessionID, _, err = queryInt(q, "session_id"); err != nil {
+		return f, err
+	}
+	for key, dst := range map[string]**int{"min_price": &f.MinPrice, "max_price": &f.MaxPrice} {
+		n, ok, err := queryInt(q, key)
+		if err != nil {
+			return f, err
 		}
-
-		if results == nil {
-			results = []TicketItem{}
+		if ok {
+			*dst = &n
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(results)
 	}
+
+	f.From, f.To, err = queryRange(q)
+	return f, err
 }
 
-var jwtKey = []byte("your_secret_key_2026")
+// queryRange reads the optional from and to filters. A plain to date means
+// "up to the end of that day".
+func queryRange(q url.Values) (*time.Time, *time.Time, error) {
+	var fromPtr, toPtr *time.Time
+	from, ok, err := queryTime(q, "from")
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		fromPtr = &from
+	}
+	to, ok, err := queryTime(q, "to")
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		if len(q.Get("to")) == len("2006-01-02") {
+			to = to.AddDate(0, 0, 1)
+		}
+		toPtr = &to
+	}
+	return fromPtr, toPtr, nil
+}
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var credentials struct {
@@ -260,19 +494,35 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokenString, err := issueToken(w, user)
+	if err != nil {
+		log.Printf("[ERROR]: Failed to sign token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":  user.Role,
+		"name":  user.Name,
+		"id":    user.ID,
+		"token": tokenString,
+	})
+}
+
+// issueToken signs a session token for user and sets it as the web UI cookie.
+// The token is also returned for clients that use the Authorization header.
+func issueToken(w http.ResponseWriter, user *models.User) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &struct {
-		Email string `json:"email"`
-		Role  string `json:"role"`
-		jwt.RegisteredClaims
-	}{
+	claims := &Claims{
 		Email:            user.Email,
 		Role:             user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expirationTime)},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString(jwtKey)
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
@@ -281,38 +531,34 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		Path:     "/",
 		HttpOnly: true,
 	})
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"role": user.Role,
-		"name": user.Name,
-		"id":   user.ID,
-	})
+	return tokenString, nil
 }
 
 type contextKey string
 
 const userEmailKey contextKey = "userEmail"
 
+// currentUser loads the user behind the token checked by AuthMiddleware.
+func currentUser(r *http.Request) (*models.User, error) {
+	email, ok := r.Context().Value(userEmailKey).(string)
+	if !ok {
+		return nil, errors.New("unauthorized")
+	}
+	user, _, err := store.GetUserByEmail(email)
+	return user, err
+}
+
 func AuthMiddleware(requiredRole string) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("token")
-			if err != nil {
+			tokenString, ok := tokenFromRequest(r)
+			if !ok {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			claims := &struct {
-				Email string `json:"email"`
-				Role  string `json:"role"`
-				jwt.RegisteredClaims
-			}{}
-
-			token, err := jwt.ParseWithClaims(cookie.Value, claims, func(t *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
-			})
-
-			if err != nil || !token.Valid {
+			claims, err := keys.Parse(tokenString)
+			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
@@ -321,7 +567,11 @@ func AuthMiddleware(requiredRole string) func(http.HandlerFunc) http.HandlerFunc
 				http.Error(w, "Forbidden: Admins only", http.StatusForbidden)
 				return
 			}
-			if requiredRole == "user" && (claims.Role != "user" && claims.Role != "admin") {
+			if requiredRole == "usher" && claims.Role != "usher" && claims.Role != "admin" {
+				http.Error(w, "Forbidden: Ushers only", http.StatusForbidden)
+				return
+			}
+			if requiredRole == "user" && !validRoles[claims.Role] {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
@@ -332,6 +582,36 @@ func AuthMiddleware(requiredRole string) func(http.HandlerFunc) http.HandlerFunc
 	}
 }
 
+// validRoles are the roles a user can hold. Ushers scan tickets at the door
+// and can otherwise do what users do.
+var validRoles = map[string]bool{"user": true, "usher": true, "admin": true}
+
+// setRoleHandler handles PUT /users/{id}/role {"role": "usher"}. The new role
+// is in the user's tokens from their next sign-in.
+func setRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := readJSON(r, &body); err != nil || !validRoles[body.Role] {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "role must be user, usher or admin"})
+		return
+	}
+	user, err := store.SetUserRole(id, body.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
 func registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"text/template"
+	"time"
+
+	"Final_1/internal/notify"
+)
+
+const (
+	maxNotificationAttempts = 8
+	notificationLease       = 5 * time.Minute
+	notificationBatch       = 20
+)
+
+// emailTemplate is one kind of email; the subject and both bodies are
+// templates over emailData.
+type emailTemplate struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+type emailData struct {
+	Name     string
+	TicketID int
+	Movie    string
+	Hall     string
+	Row      int
+	Seat     int
+	Price    string
+	StartsAt string
+	Reason   string
+}
+
+func newEmailTemplate(subject, text, html string) emailTemplate {
+	return emailTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		text:    template.Must(template.New("text").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New("html").Parse(html)),
+	}
+}
+
+var emailTemplates = map[string]emailTemplate{
+	NotifyBooking: newEmailTemplate(
+		`Your ticket for {{.Movie}}`,
+		`Hi {{.Name}},
+
+your booking is confirmed.
+
+  {{.Movie}}
+  {{.StartsAt}}, {{.Hall}}
+  Row {{.Row}}, seat {{.Seat}}
+  Price: {{.Price}}
+
+Ticket #{{.TicketID}}. Show the QR code from the app or the PDF ticket at the entrance.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>your booking is confirmed.</p>
+<p><strong>{{.Movie}}</strong><br>{{.StartsAt}}, {{.Hall}}<br>Row {{.Row}}, seat {{.Seat}}<br>Price: {{.Price}}</p>
+<p>Ticket #{{.TicketID}}. Show the QR code from the app or the PDF ticket at the entrance.</p>`),
+
+	NotifyReminder: newEmailTemplate(
+		`Reminder: {{.Movie}} at {{.StartsAt}}`,
+		`Hi {{.Name}},
+
+a reminder that {{.Movie}} starts {{.StartsAt}} in {{.Hall}}.
+Your seat: row {{.Row}}, seat {{.Seat}} (ticket #{{.TicketID}}).
+
+Doors open one hour before the session.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>a reminder that <strong>{{.Movie}}</strong> starts {{.StartsAt}} in {{.Hall}}.<br>
+Your seat: row {{.Row}}, seat {{.Seat}} (ticket #{{.TicketID}}).</p>
+<p>Doors open one hour before the session.</p>`),
+
+	NotifySessionCancelled: newEmailTemplate(
+		`Cancelled: {{.Movie}} on {{.StartsAt}}`,
+		`Hi {{.Name}},
+
+we are sorry, the session of {{.Movie}} on {{.StartsAt}} in {{.Hall}} has been cancelled.
+{{if .Reason}}Reason: {{.Reason}}
+{{end}}
+Your ticket #{{.TicketID}} ({{.Price}}) is cancelled and will be refunded.
+`,
+		`<p>Hi {{.Name}},</p>
+<p>we are sorry, the session of <strong>{{.Movie}}</strong> on {{.StartsAt}} in {{.Hall}} has been cancelled.</p>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}
+<p>Your ticket #{{.TicketID}} ({{.Price}}) is cancelled and will be refunded.</p>`),
+}
+
+func renderEmail(pn PendingNotification) (notify.Message, error) {
+	tmpl, ok := emailTemplates[pn.Kind]
+	if !ok {
+		return notify.Message{}, fmt.Errorf("no template for %q", pn.Kind)
+	}
+	row, seat := seatPosition(pn.Seat, pn.Session.SeatsPerRow)
+	data := emailData{
+		Name:     pn.Name,
+		TicketID: pn.TicketID,
+		Movie:    pn.Session.MovieTitle,
+		Hall:     pn.Session.HallName,
+		Row:      row,
+		Seat:     seat,
+		Price:    money(pn.Price),
+		StartsAt: pn.Session.Time.Format(dateFmt + " " + clockFmt),
+		Reason:   pn.Session.CancelReason,
+	}
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return notify.Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return notify.Message{}, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return notify.Message{}, err
+	}
+	return notify.Message{To: pn.Email, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+// stale tells whether an email no longer makes sense, e.g. a reminder for a
+// ticket cancelled since it was queued.
+func stale(pn PendingNotification, now time.Time) bool {
+	switch pn.Kind {
+	case NotifyBooking:
+		return pn.TicketStatus == "CANCELLED"
+	case NotifyReminder:
+		return pn.TicketStatus != "BOOKED" || pn.Session.CancelledAt != nil || !pn.Session.Time.After(now)
+	}
+	return false
+}
+
+// notificationBackoff doubles from a minute up to six hours.
+func notificationBackoff(attempts int) time.Duration {
+	d := time.Minute << min(attempts, 9)
+	return min(d, 6*time.Hour)
+}
+
+// loadNotifier picks SMTP when SMTP_ADDR is set and otherwise writes .eml
+// files to MAIL_DIR.
+func loadNotifier() notify.Notifier {
+	from := envOr("MAIL_FROM", "Cinema <no-reply@cinema.local>")
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return &notify.SMTP{Addr: addr, From: from, Username: os.Getenv("SMTP_USER"), Password: os.Getenv("SMTP_PASSWORD")}
+	}
+	dir := envOr("MAIL_DIR", "mail-out")
+	log.Printf("SMTP_ADDR not set, emails are written to %s", dir)
+	return &notify.FileSink{Dir: dir, From: from}
+}
+
+// runNotifications queues reminders and works through the email queue
+// every interval. Failed sends are retried with backoff until
+// maxNotificationAttempts.
+func runNotifications(store *MovieStore, n notify.Notifier, remindBefore, interval time.Duration) {
+	for {
+		if _, err := store.QueueReminders(remindBefore); err != nil {
+			log.Printf("[NOTIFY]: queueing reminders: %v", err)
+		}
+		for {
+			batch, err := store.ClaimNotifications(notificationBatch, notificationLease)
+			if err != nil {
+				log.Printf("[NOTIFY]: reading queue: %v", err)
+				break
+			}
+			for _, pn := range batch {
+				deliverNotification(store, n, pn)
+			}
+			if len(batch) < notificationBatch {
+				break
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func deliverNotification(store *MovieStore, n notify.Notifier, pn PendingNotification) {
+	now := time.Now()
+	status, next := "sent", now
+	var err error
+	if stale(pn, now) {
+		status = "skipped"
+	} else {
+		var msg notify.Message
+		msg, err = renderEmail(pn)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = n.Send(ctx, msg)
+			cancel()
+		}
+		switch {
+		case err == nil:
+			log.Printf("[NOTIFY]: %s email for ticket %d sent to %s", pn.Kind, pn.TicketID, pn.Email)
+		case notify.IsPermanent(err) || pn.Attempts+1 >= maxNotificationAttempts:
+			status = "failed"
+			log.Printf("[NOTIFY]: giving up on %s email for ticket %d: %v", pn.Kind, pn.TicketID, err)
+		default:
+			status, next = "pending", now.Add(notificationBackoff(pn.Attempts))
+			log.Printf("[NOTIFY]: %s email for ticket %d failed, retrying at %s: %v",
+				pn.Kind, pn.TicketID, next.Format(time.TimeOnly), err)
+		}
+	}
+	if dbErr := store.FinishNotification(pn.ID, status, err, next); dbErr != nil {
+		log.Printf("[NOTIFY]: recording result of notification %d: %v", pn.ID, dbErr)
+	}
+}
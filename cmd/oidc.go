@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"Final_1/internal/models"
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// RoleClaim names the ID token claim holding the user's groups/roles.
+	RoleClaim string
+	// AdminValues are the RoleClaim values that grant the admin role.
+	AdminValues []string
+	// UsherValues grant the usher role when no admin value is present.
+	UsherValues []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// pendingLogin is what we remember between the redirect to the provider and
+// the callback.
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// userMapper turns a verified identity into a local user; *MovieStore is
+// the real one.
+type userMapper interface {
+	UpsertExternalUser(ExternalIdentity) (*models.User, error)
+}
+
+// OIDCClient implements the authorization-code flow with PKCE (S256).
+type OIDCClient struct {
+	cfg    OIDCConfig
+	client *http.Client
+	users  userMapper
+
+	mu      sync.Mutex
+	disc    *oidcDiscovery
+	jwks    map[string]crypto.PublicKey
+	pending map[string]pendingLogin
+}
+
+func NewOIDCClient(cfg OIDCConfig, users userMapper) *OIDCClient {
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "groups"
+	}
+	return &OIDCClient{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		users:   users,
+		jwks:    map[string]crypto.PublicKey{},
+		pending: map[string]pendingLogin{},
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c *OIDCClient) getJSON(u string, dst any) error {
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// discovery is fetched lazily so the server can start before the provider.
+func (c *OIDCClient) discovery() (*oidcDiscovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disc != nil {
+		return c.disc, nil
+	}
+
+	var d oidcDiscovery
+	if err := c.getJSON(strings.TrimSuffix(c.cfg.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != c.cfg.Issuer {
+		return nil, errors.New("issuer mismatch in discovery document")
+	}
+	c.disc = &d
+	return c.disc, nil
+}
+
+func (c *OIDCClient) refreshJWKS(uri string) error {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := c.getJSON(uri, &set); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		pub, err := jwkPublicKey(k)
+		if err != nil {
+			log.Printf("[OIDC]: skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.jwks = keys
+	c.mu.Unlock()
+	return nil
+}
+
+func jwkPublicKey(k JWK) (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("unsupported kty " + k.Kty)
+	}
+}
+
+// verifyIDToken checks signature, issuer, audience, expiry and nonce.
+func (c *OIDCClient) verifyIDToken(raw, nonce string, disc *oidcDiscovery) (jwt.MapClaims, error) {
+	keyfunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		c.mu.Lock()
+		key, ok := c.jwks[kid]
+		c.mu.Unlock()
+		if !ok {
+			// The provider may have rotated; try once more.
+			if err := c.refreshJWKS(disc.JWKSURI); err != nil {
+				return nil, err
+			}
+			c.mu.Lock()
+			key, ok = c.jwks[kid]
+			c.mu.Unlock()
+			if !ok {
+				return nil, errors.New("unknown kid")
+			}
+		}
+		return key, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithIssuer(c.cfg.Issuer),
+		jwt.WithAudience(c.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	return claims, nil
+}
+
+// roleFromClaims maps provider groups/roles onto our local roles. It returns
+// "" when the token carries no role claim, so the local role is kept.
+func (c *OIDCClient) roleFromClaims(claims jwt.MapClaims) string {
+	var values []string
+	switch v := claims[c.cfg.RoleClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	default:
+		return ""
+	}
+	for _, v := range values {
+		if slices.Contains(c.cfg.AdminValues, v) {
+			return "admin"
+		}
+	}
+	for _, v := range values {
+		if slices.Contains(c.cfg.UsherValues, v) {
+			return "usher"
+		}
+	}
+	return "user"
+}
+
+func (c *OIDCClient) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	disc, err := c.discovery()
+	if err != nil {
+		log.Printf("[OIDC]: discovery failed: %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state := randomString(24)
+	verifier := randomString(32)
+	nonce := randomString(16)
+	sum := sha256.Sum256([]byte(verifier))
+
+	c.mu.Lock()
+	now := time.Now()
+	for k, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, k)
+		}
+	}
+	c.pending[state] = pendingLogin{verifier: verifier, nonce: nonce, expires: now.Add(10 * time.Minute)}
+	c.mu.Unlock()
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if hint := r.URL.Query().Get("login_hint"); hint != "" {
+		q.Set("login_hint", hint)
+	}
+	http.Redirect(w, r, disc.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+func (c *OIDCClient) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	state := q.Get("state")
+	c.mu.Lock()
+	p, ok := c.pending[state]
+	delete(c.pending, state)
+	c.mu.Unlock()
+	if !ok || time.Now().After(p.expires) {
+		http.Error(w, "invalid or expired state", http.StatusBadRequest)
+		return
+	}
+
+	disc, err := c.discovery()
+	if err != nil {
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"client_id":     {c.cfg.ClientID},
+		"code_verifier": {p.verifier},
+	}
+	req, _ := http.NewRequest(http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[OIDC]: token exchange failed: %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || resp.StatusCode != http.StatusOK || tok.IDToken == "" {
+		log.Printf("[OIDC]: token endpoint returned %s %s", resp.Status, tok.Error)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := c.verifyIDToken(tok.IDToken, p.nonce, disc)
+	if err != nil {
+		log.Printf("[SECURITY]: rejected ID token: %v", err)
+		http.Error(w, "invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = email
+	}
+	verified, _ := claims["email_verified"].(bool)
+
+	user, err := c.users.UpsertExternalUser(ExternalIdentity{
+		Issuer:        c.cfg.Issuer,
+		Subject:       sub,
+		Email:         email,
+		Name:          name,
+		Role:          c.roleFromClaims(claims),
+		EmailVerified: verified,
+	})
+	if errors.Is(err, ErrEmailNotVerified) {
+		http.Error(w, "email not verified", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("[OIDC]: user mapping failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := issueToken(w, user); err != nil {
+		log.Printf("[ERROR]: Failed to sign token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[SYSTEM]: SSO login for %s (%s)", user.Email, user.Role)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
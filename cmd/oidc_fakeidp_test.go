@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// FakeUser is an account known to the FakeIdP.
+type FakeUser struct {
+	Subject    string
+	Name       string
+	Groups     []string
+	Unverified bool // send email_verified: false
+}
+
+type fakeCode struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	expires     time.Time
+}
+
+// FakeIdP is a minimal in-process OpenID provider for tests. It approves
+// every authorization request without a login page: the user is picked from
+// the login_hint parameter.
+type FakeIdP struct {
+	Issuer string
+	Users  map[string]FakeUser
+
+	keys  *KeyRing
+	mu    sync.Mutex
+	codes map[string]fakeCode
+}
+
+func NewFakeIdP(issuer string) *FakeIdP {
+	kr := NewKeyRing()
+	_, _ = kr.Rotate("RS256")
+	return &FakeIdP{
+		Issuer: issuer,
+		Users: map[string]FakeUser{
+			"staff@cinema.test": {Subject: "staff-1", Name: "Staff Admin", Groups: []string{"cinema-admins"}},
+			"usher@cinema.test": {Subject: "usher-1", Name: "Door Usher", Groups: []string{"cinema-ushers"}},
+			"guest@cinema.test": {Subject: "guest-1", Name: "Guest User"},
+		},
+		keys:  kr,
+		codes: map[string]fakeCode{},
+	}
+}
+
+func (f *FakeIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, oidcDiscovery{
+			Issuer:                f.Issuer,
+			AuthorizationEndpoint: f.Issuer + "/authorize",
+			TokenEndpoint:         f.Issuer + "/token",
+			JWKSURI:               f.Issuer + "/jwks",
+		})
+	case "/jwks":
+		f.keys.JWKSHandler(w, r)
+	case "/authorize":
+		f.authorize(w, r)
+	case "/token":
+		f.token(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+func (f *FakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("response_type") != "code" || redirectURI == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "PKCE S256 required"})
+		return
+	}
+
+	email := strings.ToLower(q.Get("login_hint"))
+	if email == "" {
+		email = "guest@cinema.test"
+	}
+
+	code := randomString(24)
+	f.mu.Lock()
+	f.codes[code] = fakeCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: redirectURI,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		email:       email,
+		expires:     time.Now().Add(time.Minute),
+	}
+	f.mu.Unlock()
+
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, redirectURI+"?"+back.Encode(), http.StatusFound)
+}
+
+func (f *FakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	f.mu.Lock()
+	c, ok := f.codes[code]
+	delete(f.codes, code)
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(c.expires),
+		r.PostForm.Get("grant_type") != "authorization_code",
+		r.PostForm.Get("redirect_uri") != c.redirectURI,
+		r.PostForm.Get("client_id") != c.clientID,
+		base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	u, known := f.Users[c.email]
+	if !known {
+		u = FakeUser{Subject: "auto-" + c.email, Name: c.email}
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            f.Issuer,
+		"sub":            u.Subject,
+		"aud":            c.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          c.nonce,
+		"email":          c.email,
+		"email_verified": !u.Unverified,
+		"name":           u.Name,
+	}
+	if u.Groups != nil {
+		claims["groups"] = u.Groups
+	}
+	idToken, err := f.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(24),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"Final_1/internal/models"
+)
+
+// recordingUsers stands in for the database and remembers the last identity.
+type recordingUsers struct {
+	got ExternalIdentity
+}
+
+func (u *recordingUsers) UpsertExternalUser(id ExternalIdentity) (*models.User, error) {
+	u.got = id
+	role := id.Role
+	if role == "" {
+		role = "user"
+	}
+	return &models.User{ID: 1, Name: id.Name, Email: id.Email, Role: role}, nil
+}
+
+func newTestOIDC(t *testing.T) (*FakeIdP, *OIDCClient, *recordingUsers) {
+	t.Helper()
+	keys = NewKeyRing()
+	if _, err := keys.Rotate("EdDSA"); err != nil {
+		t.Fatal(err)
+	}
+
+	idp := NewFakeIdP("")
+	srv := httptest.NewServer(idp)
+	t.Cleanup(srv.Close)
+	idp.Issuer = srv.URL
+
+	users := &recordingUsers{}
+	client := NewOIDCClient(OIDCConfig{
+		Issuer:      srv.URL,
+		ClientID:    "cinema",
+		RedirectURL: "http://cinema.test/auth/oidc/callback",
+		AdminValues: []string{"cinema-admins"},
+		UsherValues: []string{"cinema-ushers"},
+	}, users)
+	return idp, client, users
+}
+
+// authorize runs /auth/oidc/login and the provider's authorize step and
+// returns the callback query the browser would bring back.
+func authorize(t *testing.T, c *OIDCClient, email string) url.Values {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c.LoginHandler(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?login_hint="+url.QueryEscape(email), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("login did not send a PKCE challenge: %s", loc)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(loc.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(back.String(), "http://cinema.test/auth/oidc/callback?") {
+		t.Fatalf("authorize redirected to %q", resp.Header.Get("Location"))
+	}
+	return back.Query()
+}
+
+func callback(c *OIDCClient, q url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c.CallbackHandler(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil))
+	return rec
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	tests := []struct {
+		email    string
+		wantRole string // role passed to the store; "" keeps the local one
+	}{
+		{"staff@cinema.test", "admin"},
+		{"usher@cinema.test", "usher"},
+		{"guest@cinema.test", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			_, c, users := newTestOIDC(t)
+			rec := callback(c, authorize(t, c, tt.email))
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+				t.Fatalf("callback: status %d, location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+			}
+			if users.got.Email != tt.email || users.got.Role != tt.wantRole || !users.got.EmailVerified {
+				t.Fatalf("store got %+v", users.got)
+			}
+
+			var token string
+			for _, ck := range rec.Result().Cookies() {
+				if ck.Name == "token" {
+					token = ck.Value
+				}
+			}
+			claims, err := keys.Parse(token)
+			if err != nil {
+				t.Fatalf("issued token does not verify: %v", err)
+			}
+			if claims.Email != tt.email {
+				t.Fatalf("token email = %q", claims.Email)
+			}
+		})
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	_, c, _ := newTestOIDC(t)
+	q := authorize(t, c, "guest@cinema.test")
+	if rec := callback(c, q); rec.Code != http.StatusFound {
+		t.Fatalf("first callback: status %d", rec.Code)
+	}
+	if rec := callback(c, q); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: status %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	idp, c, users := newTestOIDC(t)
+	idp.Users["new@cinema.test"] = FakeUser{Subject: "new-1", Name: "New", Unverified: true}
+	callback(c, authorize(t, c, "new@cinema.test"))
+	if users.got.EmailVerified {
+		t.Fatal("unverified email was reported as verified")
+	}
+}
+
+func TestFakeIdPRequiresPKCEVerifier(t *testing.T) {
+	idp, _, _ := newTestOIDC(t)
+	verifier := "correct-verifier"
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"cinema"},
+		"redirect_uri":          {"http://cinema.test/cb"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	exchange := func(verifier string) int {
+		rec := httptest.NewRecorder()
+		idp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authorize?"+q.Encode(), nil))
+		loc, _ := url.Parse(rec.Header().Get("Location"))
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {loc.Query().Get("code")},
+			"redirect_uri":  {"http://cinema.test/cb"},
+			"client_id":     {"cinema"},
+			"code_verifier": {verifier},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec = httptest.NewRecorder()
+		idp.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := exchange("wrong-verifier"); code != http.StatusBadRequest {
+		t.Fatalf("wrong verifier: status %d, want 400", code)
+	}
+	if code := exchange(verifier); code != http.StatusOK {
+		t.Fatalf("right verifier: status %d, want 200", code)
+	}
+}
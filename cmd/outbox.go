@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"Final_1/internal/models"
+)
+
+const (
+	outboxLease = time.Minute
+	outboxBatch = 50
+	outboxKeep  = 7 * 24 * time.Hour
+)
+
+// OutboxHandler reacts to one domain event. Writes that should happen once
+// go through tx, which commits together with the note that the handler is
+// done.
+type OutboxHandler func(tx *sql.Tx, ev OutboxEvent) error
+
+type namedHandler struct {
+	name string
+	fn   OutboxHandler
+}
+
+// Dispatcher delivers outbox events to the handlers registered for their
+// type, at least once each. A handler that fails is retried with the rest
+// of the event later; the ones that succeeded are not run again.
+type Dispatcher struct {
+	store    *MovieStore
+	handlers map[string][]namedHandler
+}
+
+func NewDispatcher(store *MovieStore) *Dispatcher {
+	return &Dispatcher{store: store, handlers: map[string][]namedHandler{}}
+}
+
+// Handle registers fn for eventType under name, which must stay the same
+// across restarts since progress is recorded by it.
+func (d *Dispatcher) Handle(eventType, name string, fn OutboxHandler) {
+	d.handlers[eventType] = append(d.handlers[eventType], namedHandler{name, fn})
+}
+
+func (d *Dispatcher) dispatch(ev OutboxEvent) error {
+	done, err := d.store.OutboxHandled(ev.ID)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, h := range d.handlers[ev.Type] {
+		if done[h.name] {
+			continue
+		}
+		if err := d.store.HandleOutboxEvent(ev, h.name, h.fn); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// outboxBackoff doubles from 10 seconds up to an hour. Events are never
+// given up on.
+func outboxBackoff(attempts int) time.Duration {
+	return min(10*time.Second<<min(attempts, 9), time.Hour)
+}
+
+// runOutbox dispatches due outbox events every interval and prunes old
+// dispatched ones.
+func runOutbox(store *MovieStore, d *Dispatcher, interval time.Duration) {
+	lastPrune := time.Time{}
+	for {
+		for {
+			batch, err := store.ClaimOutbox(outboxBatch, outboxLease)
+			if err != nil {
+				log.Printf("[OUTBOX]: reading outbox: %v", err)
+				break
+			}
+			for _, ev := range batch {
+				err := d.dispatch(ev)
+				next := time.Now().Add(outboxBackoff(ev.Attempts))
+				if err != nil {
+					log.Printf("[OUTBOX]: %s event %s failed, retrying at %s: %v",
+						ev.Type, ev.EventID, next.Format(time.TimeOnly), err)
+				}
+				if err := store.FinishOutboxEvent(ev.ID, err, next); err != nil {
+					log.Printf("[OUTBOX]: recording result of event %s: %v", ev.EventID, err)
+				}
+			}
+			if len(batch) < outboxBatch {
+				break
+			}
+		}
+		if time.Since(lastPrune) > time.Hour {
+			if n, err := store.PruneOutbox(outboxKeep); err != nil {
+				log.Printf("[OUTBOX]: pruning: %v", err)
+			} else if n > 0 {
+				log.Printf("[OUTBOX]: pruned %d dispatched events", n)
+			}
+			lastPrune = time.Now()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// registerOutboxHandlers wires the side effects of bookings and
+// cancellations: emails, webhooks, live seat updates and the booking log.
+func registerOutboxHandlers(d *Dispatcher, seats *SeatHub) {
+	for _, eventType := range webhookEvents {
+		d.Handle(eventType, "webhooks", emitWebhook)
+	}
+
+	d.Handle(EventTicketBooked, "email", func(tx *sql.Tx, ev OutboxEvent) error {
+		var t models.Ticket
+		if err := json.Unmarshal(ev.Data, &t); err != nil {
+			return err
+		}
+		return enqueueNotification(tx, NotifyBooking, t.ID)
+	})
+	d.Handle(EventTicketBooked, "log", func(tx *sql.Tx, ev OutboxEvent) error {
+		var t models.Ticket
+		if err := json.Unmarshal(ev.Data, &t); err != nil {
+			return err
+		}
+		log.Printf("[BOOKING]: ticket %d, session %d, seat %d booked by user %d for %d",
+			t.ID, t.SessionID, t.SeatID, t.UserID, t.Price)
+		return nil
+	})
+
+	seatChange := func(state string) OutboxHandler {
+		return func(tx *sql.Tx, ev OutboxEvent) error {
+			var t models.Ticket
+			if err := json.Unmarshal(ev.Data, &t); err != nil {
+				return err
+			}
+			seats.Publish(SeatEvent{SessionID: t.SessionID, Seat: t.SeatID, State: state, At: ev.CreatedAt.UTC()})
+			return nil
+		}
+	}
+	d.Handle(EventTicketBooked, "seats", seatChange(SeatBooked))
+	d.Handle(EventTicketCancelled, "seats", seatChange(SeatReleased))
+
+	d.Handle(EventSessionCancelled, "email", func(tx *sql.Tx, ev OutboxEvent) error {
+		var sc SessionCancelledEvent
+		if err := json.Unmarshal(ev.Data, &sc); err != nil {
+			return err
+		}
+		for _, ticketID := range sc.CancelledTickets {
+			if err := enqueueNotification(tx, NotifySessionCancelled, ticketID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
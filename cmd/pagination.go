@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// PageParams are the paging and sorting options shared by all list endpoints:
+// ?limit=20&cursor=<opaque>&sort=-price,title
+type PageParams struct {
+	Limit   int
+	Offset  int
+	OrderBy string
+}
+
+// Page is the response envelope of every paginated list.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
+	Prev       string `json:"prev,omitempty"`
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(c string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil || !strings.HasPrefix(string(raw), "o:") {
+		return 0, errors.New("invalid cursor")
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "o:"))
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return offset, nil
+}
+
+// parsePage reads limit, cursor and sort from the query string. sortable maps
+// the public field name to its SQL column; anything else is rejected so user
+// input never reaches ORDER BY. tieBreak keeps the order stable between pages.
+func parsePage(q url.Values, sortable map[string]string, defaultSort, tieBreak string) (PageParams, error) {
+	p := PageParams{Limit: defaultPageLimit}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, errors.New("limit must be a positive number")
+		}
+		p.Limit = min(n, maxPageLimit)
+	}
+	if v := q.Get("cursor"); v != "" {
+		offset, err := decodeCursor(v)
+		if err != nil {
+			return p, err
+		}
+		p.Offset = offset
+	}
+
+	sortParam := q.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	var order []string
+	for _, field := range strings.Split(sortParam, ",") {
+		if field == "" {
+			continue
+		}
+		field = strings.TrimSpace(field)
+		dir := "ASC"
+		if strings.HasPrefix(field, "-") {
+			dir = "DESC"
+			field = field[1:]
+		}
+		col, ok := sortable[field]
+		if !ok {
+			return p, fmt.Errorf("cannot sort by %q", field)
+		}
+		order = append(order, col+" "+dir)
+	}
+	if tieBreak != "" {
+		order = append(order, tieBreak)
+	}
+	p.OrderBy = strings.Join(order, ", ")
+	return p, nil
+}
+
+// newPage fills the envelope and builds next/prev links from the request URL.
+func newPage[T any](r *http.Request, p PageParams, items []T, total int) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	page := Page[T]{Items: items, Total: total, Limit: p.Limit}
+
+	link := func(offset int) string {
+		u := *r.URL
+		q := u.Query()
+		if offset > 0 {
+			q.Set("cursor", encodeCursor(offset))
+		} else {
+			q.Del("cursor")
+		}
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	if next := p.Offset + len(items); next < total {
+		page.NextCursor = encodeCursor(next)
+		page.Next = link(next)
+	}
+	if p.Offset > 0 {
+		page.Prev = link(max(p.Offset-p.Limit, 0))
+	}
+	return page
+}
+
+// whereBuilder collects SQL conditions with numbered placeholders.
+type whereBuilder struct {
+	conds []string
+	args  []any
+}
+
+// add appends a condition; every %d in cond is replaced by the placeholder
+// number of arg.
+func (b *whereBuilder) add(cond string, arg any) {
+	b.args = append(b.args, arg)
+	n := len(b.args)
+	b.conds = append(b.conds, strings.ReplaceAll(cond, "%d", strconv.Itoa(n)))
+}
+
+func (b *whereBuilder) sql() string {
+	if len(b.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conds, " AND ")
+}
+
+// queryInt parses an optional integer filter; ok is false when it is absent.
+func queryInt(q url.Values, key string) (n int, ok bool, err error) {
+	v := q.Get(key)
+	if v == "" {
+		return 0, false, nil
+	}
+	n, err = strconv.Atoi(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s must be a number", key)
+	}
+	return n, true, nil
+}
+
+// queryTime accepts RFC 3339 timestamps or plain dates (2006-01-02).
+func queryTime(q url.Values, key string) (t time.Time, ok bool, err error) {
+	v := q.Get(key)
+	if v == "" {
+		return t, false, nil
+	}
+	if t, err = time.Parse(time.RFC3339, v); err == nil {
+		return t, true, nil
+	}
+	if t, err = time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	return t, false, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", key)
+}
+
+func queryFloat(q url.Values, key string) (f float64, ok bool, err error) {
+	v := q.Get(key)
+	if v == "" {
+		return 0, false, nil
+	}
+	f, err = strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false, fmt.Errorf("%s must be a number", key)
+	}
+	return f, true, nil
+}
@@ -0,0 +1,369 @@
+package main
+
+// schema lists the tables added on top of the original movies/users/tickets
+// setup. Every statement must be idempotent because Migrate runs on each start.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		issuer     TEXT NOT NULL,
+		subject    TEXT NOT NULL,
+		user_id    INT  NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (issuer, subject)
+	)`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`CREATE INDEX IF NOT EXISTS tickets_user_id_idx ON tickets (user_id, id)`,
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING gin (title gin_trgm_ops)`,
+	`ALTER TABLE movies
+		ADD COLUMN IF NOT EXISTS rating       DOUBLE PRECISION NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS description  TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS release_date DATE,
+		ADD COLUMN IF NOT EXISTS language     TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS subtitles    TEXT[] NOT NULL DEFAULT '{}',
+		ADD COLUMN IF NOT EXISTS age_rating   TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS poster_url   TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS trailer_url  TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS genres (
+		id   SERIAL PRIMARY KEY,
+		name TEXT NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS genres_name_idx ON genres (lower(name))`,
+	`CREATE TABLE IF NOT EXISTS movie_genres (
+		movie_id INT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+		genre_id INT NOT NULL REFERENCES genres(id) ON DELETE CASCADE,
+		PRIMARY KEY (movie_id, genre_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS movie_credits (
+		movie_id       INT  NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+		role           TEXT NOT NULL CHECK (role IN ('director', 'cast')),
+		position       INT  NOT NULL,
+		name           TEXT NOT NULL,
+		character_name TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (movie_id, role, position)
+	)`,
+	// Move the old single genre string into the genres relation, once.
+	`DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+		           WHERE table_name = 'movies' AND column_name = 'genre') THEN
+			INSERT INTO genres (name)
+				SELECT DISTINCT ON (lower(trim(genre))) trim(genre) FROM movies
+				WHERE coalesce(trim(genre), '') <> ''
+				ON CONFLICT DO NOTHING;
+			INSERT INTO movie_genres (movie_id, genre_id)
+				SELECT m.id, g.id FROM movies m JOIN genres g ON lower(g.name) = lower(trim(m.genre))
+				ON CONFLICT DO NOTHING;
+			ALTER TABLE movies DROP COLUMN genre;
+		END IF;
+	END $$`,
+	`ALTER TABLE movies
+		ADD COLUMN IF NOT EXISTS rating_count INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS rating_sum   INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS rating_hist  INT[] NOT NULL DEFAULT '{0,0,0,0,0}'`,
+	// Ratings used to be typed in by admins; only reviews count now.
+	`UPDATE movies SET rating = 0 WHERE rating_count = 0 AND rating <> 0`,
+	`CREATE TABLE IF NOT EXISTS reviews (
+		id         SERIAL PRIMARY KEY,
+		movie_id   INT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+		user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		rating     SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		body       TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (movie_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id)`,
+	`ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'visible'
+		CHECK (status IN ('visible', 'flagged', 'hidden'))`,
+	`CREATE TABLE IF NOT EXISTS review_reports (
+		id          SERIAL PRIMARY KEY,
+		review_id   INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+		reporter_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		reason      TEXT NOT NULL,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+		resolved_at TIMESTAMPTZ,
+		UNIQUE (review_id, reporter_id)
+	)`,
+	// No foreign key on review_id: the history must outlive deleted reviews.
+	`CREATE TABLE IF NOT EXISTS review_moderation_log (
+		id         SERIAL PRIMARY KEY,
+		review_id  INT NOT NULL,
+		actor_id   INT REFERENCES users(id) ON DELETE SET NULL,
+		action     TEXT NOT NULL,
+		reason     TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS review_moderation_log_review_idx ON review_moderation_log (review_id)`,
+	`CREATE TABLE IF NOT EXISTS halls (
+		id          SERIAL PRIMARY KEY,
+		name        TEXT NOT NULL UNIQUE,
+		total_seats INT  NOT NULL CHECK (total_seats > 0)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id        SERIAL PRIMARY KEY,
+		movie_id  INT NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+		hall_id   INT NOT NULL REFERENCES halls(id),
+		starts_at TIMESTAMPTZ NOT NULL,
+		price     INT NOT NULL CHECK (price >= 0)
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_starts_at_idx ON sessions (starts_at)`,
+	`CREATE INDEX IF NOT EXISTS tickets_session_id_idx ON tickets (session_id)`,
+	// Tickets booked before sessions existed stored the movie id as
+	// session_id. Give each of those a session with the same id in a
+	// default hall so old tickets keep their movie.
+	`DO $$
+	DECLARE hall INT;
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM sessions) AND EXISTS (SELECT 1 FROM tickets) THEN
+			INSERT INTO halls (name, total_seats) VALUES ('Hall 1', 80)
+				ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id INTO hall;
+			INSERT INTO sessions (id, movie_id, hall_id, starts_at, price)
+				SELECT t.session_id, m.id, hall, MIN(t.created_at), m.price
+				FROM tickets t JOIN movies m ON m.id = t.session_id
+				GROUP BY t.session_id, m.id, m.price;
+			PERFORM setval(pg_get_serial_sequence('sessions', 'id'), (SELECT MAX(id) FROM sessions));
+		END IF;
+	END $$`,
+	`CREATE TABLE IF NOT EXISTS wrapped_cache (
+		user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		year        INT NOT NULL,
+		stats       JSONB NOT NULL,
+		computed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (user_id, year)
+	)`,
+	// Fingerprint of the tickets a cached wrapped was computed from.
+	`ALTER TABLE wrapped_cache ADD COLUMN IF NOT EXISTS source_version TEXT NOT NULL DEFAULT ''`,
+	// Rollups are kept up to date by RefreshRollups; dashboards read only these.
+	`CREATE TABLE IF NOT EXISTS sales_rollup (
+		bucket     TIMESTAMPTZ NOT NULL, -- hour the tickets were sold
+		session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		movie_id   INT NOT NULL,
+		hall_id    INT NOT NULL,
+		tickets    INT NOT NULL,
+		revenue    INT NOT NULL,
+		PRIMARY KEY (session_id, bucket)
+	)`,
+	`CREATE INDEX IF NOT EXISTS sales_rollup_bucket_idx ON sales_rollup (bucket)`,
+	`CREATE TABLE IF NOT EXISTS session_rollup (
+		session_id INT PRIMARY KEY REFERENCES sessions(id) ON DELETE CASCADE,
+		movie_id   INT NOT NULL,
+		hall_id    INT NOT NULL,
+		starts_at  TIMESTAMPTZ NOT NULL,
+		capacity   INT NOT NULL,
+		tickets    INT NOT NULL,
+		revenue    INT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS session_rollup_starts_at_idx ON session_rollup (starts_at)`,
+	`CREATE TABLE IF NOT EXISTS rollup_state (
+		name         TEXT PRIMARY KEY,
+		refreshed_at TIMESTAMPTZ NOT NULL
+	)`,
+	// One forecast per session and day it was made, to measure accuracy later.
+	`CREATE TABLE IF NOT EXISTS session_forecasts (
+		session_id   INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		made_on      DATE NOT NULL DEFAULT CURRENT_DATE,
+		horizon_days INT NOT NULL,
+		predicted    INT NOT NULL,
+		PRIMARY KEY (session_id, made_on)
+	)`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS used_at TIMESTAMPTZ`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS checked_in_by INT REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS checked_in_device TEXT`, // NULL when checked in online
+	// Scans uploaded by offline scanners; (device_id, scan_id) makes uploads
+	// repeatable.
+	`CREATE TABLE IF NOT EXISTS checkin_scans (
+		id               SERIAL PRIMARY KEY,
+		device_id        TEXT NOT NULL,
+		scan_id          TEXT NOT NULL,
+		usher_id         INT REFERENCES users(id) ON DELETE SET NULL,
+		ticket_id        INT, -- NULL when the payload did not verify
+		session_id       INT NOT NULL,
+		scanned_at       TIMESTAMPTZ NOT NULL,
+		uploaded_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		accepted_offline BOOLEAN NOT NULL,
+		outcome          TEXT NOT NULL CHECK (outcome IN ('admitted', 'duplicate', 'invalid', 'rejected')),
+		reason           TEXT NOT NULL DEFAULT '',
+		UNIQUE (device_id, scan_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS checkin_scans_ticket_idx ON checkin_scans (ticket_id)`,
+	`ALTER TABLE halls ADD COLUMN IF NOT EXISTS seats_per_row INT NOT NULL DEFAULT 10 CHECK (seats_per_row > 0)`,
+	`CREATE TABLE IF NOT EXISTS orders (
+		id             SERIAL PRIMARY KEY,
+		user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status         TEXT NOT NULL DEFAULT 'paid',
+		payment_method TEXT NOT NULL,
+		total          INT NOT NULL CHECK (total >= 0),
+		created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id)`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS order_id INT REFERENCES orders(id)`,
+	// Tickets booked before orders existed each get an order of their own.
+	`DO $$
+	DECLARE t RECORD; ord INT;
+	BEGIN
+		FOR t IN SELECT id, user_id, price, created_at FROM tickets WHERE order_id IS NULL ORDER BY id LOOP
+			INSERT INTO orders (user_id, payment_method, total, created_at)
+				VALUES (t.user_id, 'unknown', t.price, t.created_at) RETURNING id INTO ord;
+			UPDATE tickets SET order_id = ord WHERE id = t.id;
+		END LOOP;
+	END $$`,
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMPTZ`,
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS cancel_reason TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMPTZ`,
+	// Email queue. Messages are rendered when sent, so they show the
+	// session as it is then; (kind, ticket_id) keeps each one unique.
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id              SERIAL PRIMARY KEY,
+		kind            TEXT NOT NULL CHECK (kind IN ('booking', 'reminder', 'session_cancelled')),
+		ticket_id       INT NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+		status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed', 'skipped')),
+		attempts        INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error      TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		sent_at         TIMESTAMPTZ,
+		UNIQUE (kind, ticket_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS notifications_due_idx ON notifications (next_attempt_at) WHERE status = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id          SERIAL PRIMARY KEY,
+		url         TEXT NOT NULL,
+		secret      TEXT NOT NULL,
+		events      TEXT[] NOT NULL, -- event types, or '*' for all
+		description TEXT NOT NULL DEFAULT '',
+		active      BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// One row per event and subscription; the payload is fixed when the
+	// event happens so a redelivery sends the same bytes.
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id              SERIAL PRIMARY KEY,
+		subscription_id INT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+		event_id        TEXT NOT NULL,
+		event_type      TEXT NOT NULL,
+		payload         JSONB NOT NULL,
+		status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+		attempts        INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at    TIMESTAMPTZ,
+		UNIQUE (subscription_id, event_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, id)`,
+	`CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id           SERIAL PRIMARY KEY,
+		delivery_id  INT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		attempted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		status_code  INT, -- NULL when no response arrived
+		error        TEXT NOT NULL DEFAULT '',
+		duration_ms  INT NOT NULL,
+		manual       BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_idx ON webhook_attempts (delivery_id, id)`,
+	// Domain events, written in the transaction that causes them and handed
+	// to in-process handlers by the dispatcher.
+	`CREATE TABLE IF NOT EXISTS outbox (
+		id              BIGSERIAL PRIMARY KEY,
+		event_id        TEXT NOT NULL UNIQUE,
+		event_type      TEXT NOT NULL,
+		data            JSONB NOT NULL,
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		attempts        INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error      TEXT NOT NULL DEFAULT '',
+		dispatched_at   TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS outbox_due_idx ON outbox (next_attempt_at) WHERE dispatched_at IS NULL`,
+	`CREATE TABLE IF NOT EXISTS outbox_handled (
+		outbox_id  BIGINT NOT NULL REFERENCES outbox(id) ON DELETE CASCADE,
+		handler    TEXT NOT NULL,
+		handled_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (outbox_id, handler)
+	)`,
+	// Seats set aside for a customer while they check out.
+	`CREATE TABLE IF NOT EXISTS seat_holds (
+		session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		seat       INT NOT NULL,
+		user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (session_id, seat)
+	)`,
+	`CREATE INDEX IF NOT EXISTS seat_holds_expires_idx ON seat_holds (expires_at)`,
+	// Seats that are not standard, and companion seats kept next to
+	// accessible ones.
+	`CREATE TABLE IF NOT EXISTS hall_seats (
+		hall_id      INT NOT NULL REFERENCES halls(id) ON DELETE CASCADE,
+		seat         INT NOT NULL,
+		category     TEXT NOT NULL DEFAULT 'standard' CHECK (category IN ('standard', 'vip', 'couple', 'accessible')),
+		companion_of INT,
+		PRIMARY KEY (hall_id, seat)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_category_prices (
+		session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		category   TEXT NOT NULL CHECK (category IN ('standard', 'vip', 'couple', 'accessible')),
+		multiplier NUMERIC(6, 3) NOT NULL DEFAULT 1 CHECK (multiplier >= 0),
+		surcharge  INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (session_id, category)
+	)`,
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT '2D'`,
+	`ALTER TABLE tickets ADD COLUMN IF NOT EXISTS tariff TEXT NOT NULL DEFAULT 'adult'`,
+	// Price adjustments; see package pricing for how they combine.
+	`CREATE TABLE IF NOT EXISTS pricing_rules (
+		id            SERIAL PRIMARY KEY,
+		name          TEXT NOT NULL,
+		priority      INT NOT NULL DEFAULT 0,
+		group_name    TEXT NOT NULL DEFAULT '',
+		adjust        TEXT NOT NULL CHECK (adjust IN ('percent', 'amount', 'fixed')),
+		value         NUMERIC(10, 2) NOT NULL,
+		tariffs       TEXT[] NOT NULL DEFAULT '{}',
+		weekdays      TEXT[] NOT NULL DEFAULT '{}',
+		formats       TEXT[] NOT NULL DEFAULT '{}',
+		categories    TEXT[] NOT NULL DEFAULT '{}',
+		starts_after  TEXT NOT NULL DEFAULT '',
+		starts_before TEXT NOT NULL DEFAULT '',
+		valid_from    DATE,
+		valid_until   DATE,
+		active        BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// The search document pulls in genres and credits, which an index
+	// expression cannot, so it is stored and kept up to date by the store.
+	`ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_doc tsvector`,
+	`UPDATE movies m SET search_doc = ` + movieSearchDoc + ` WHERE search_doc IS NULL`,
+	`CREATE INDEX IF NOT EXISTS movies_search_doc_idx ON movies USING gin (search_doc)`,
+	// Rebuild the rating aggregates from visible reviews only; flagged and
+	// hidden ones used to be counted too.
+	`UPDATE movies m SET rating_count = a.n, rating_sum = a.total, rating_hist = a.hist,
+		rating = CASE WHEN a.n > 0 THEN a.total::float8 / a.n ELSE 0 END
+	FROM (
+		SELECT mv.id,
+		       COUNT(r.id)::int AS n,
+		       COALESCE(SUM(r.rating), 0)::int AS total,
+		       ARRAY[COUNT(*) FILTER (WHERE r.rating = 1), COUNT(*) FILTER (WHERE r.rating = 2),
+		             COUNT(*) FILTER (WHERE r.rating = 3), COUNT(*) FILTER (WHERE r.rating = 4),
+		             COUNT(*) FILTER (WHERE r.rating = 5)]::int[] AS hist
+		FROM movies mv LEFT JOIN reviews r ON r.movie_id = mv.id AND r.status = 'visible'
+		GROUP BY mv.id
+	) a
+	WHERE a.id = m.id AND (m.rating_count, m.rating_sum, m.rating_hist) IS DISTINCT FROM (a.n, a.total, a.hist)`,
+	// RefreshRollups looks up tickets sold or cancelled since its watermark.
+	`CREATE INDEX IF NOT EXISTS tickets_created_at_idx ON tickets (created_at)`,
+	`CREATE INDEX IF NOT EXISTS tickets_cancelled_at_idx ON tickets (cancelled_at) WHERE cancelled_at IS NOT NULL`,
+	// A scan that synced after another check-in of the same ticket keeps a
+	// record of which check-in won.
+	`ALTER TABLE checkin_scans DROP CONSTRAINT IF EXISTS checkin_scans_outcome_check,
+		ADD CONSTRAINT checkin_scans_outcome_check
+		CHECK (outcome IN ('admitted', 'duplicate', 'conflict', 'invalid', 'rejected'))`,
+	`ALTER TABLE checkin_scans ADD COLUMN IF NOT EXISTS winner_device TEXT`,
+	`ALTER TABLE checkin_scans ADD COLUMN IF NOT EXISTS winner_at TIMESTAMPTZ`,
+}
+
+func (s *MovieStore) Migrate() error {
+	for _, stmt := range schema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// seatBuffer is how many events a stream may fall behind before it is
+// resynchronised from the database instead.
+const seatBuffer = 32
+
+// SeatEvent is one seat changing state: held, booked or released.
+type SeatEvent struct {
+	SessionID int       `json:"session_id"`
+	Seat      int       `json:"seat"`
+	Row       int       `json:"row"`
+	Number    int       `json:"number"`
+	State     string    `json:"state"`
+	At        time.Time `json:"at"`
+}
+
+// seatSubscriber is one connected stream. Publishing never blocks on it:
+// when events is full, the backlog is abandoned and resync is signalled so
+// the stream sends a fresh seat map instead.
+type seatSubscriber struct {
+	events chan SeatEvent
+	resync chan struct{}
+}
+
+// SeatHub fans seat events out to the streams watching each session.
+type SeatHub struct {
+	mu   sync.Mutex
+	subs map[int]map[*seatSubscriber]struct{}
+}
+
+func NewSeatHub() *SeatHub {
+	return &SeatHub{subs: map[int]map[*seatSubscriber]struct{}{}}
+}
+
+// Subscribe starts watching a session. The returned func stops it.
+func (h *SeatHub) Subscribe(sessionID int) (*seatSubscriber, func()) {
+	sub := &seatSubscriber{events: make(chan SeatEvent, seatBuffer), resync: make(chan struct{}, 1)}
+	h.mu.Lock()
+	if h.subs[sessionID] == nil {
+		h.subs[sessionID] = map[*seatSubscriber]struct{}{}
+	}
+	h.subs[sessionID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub, func() {
+		h.mu.Lock()
+		delete(h.subs[sessionID], sub)
+		if len(h.subs[sessionID]) == 0 {
+			delete(h.subs, sessionID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *SeatHub) Publish(ev SeatEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now().UTC()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[ev.SessionID] {
+		select {
+		case sub.events <- ev:
+		default:
+			select {
+			case sub.resync <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// runSeatHolds frees expired holds every interval and announces them.
+func runSeatHolds(store *MovieStore, hub *SeatHub, interval time.Duration) {
+	for {
+		freed, err := store.ExpireHolds()
+		if err != nil {
+			log.Printf("[SEATS]: expiring holds: %v", err)
+		}
+		for _, ev := range freed {
+			hub.Publish(ev)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"Final_1/internal/models"
+)
+
+// companionRelease is how long before the start unsold companion seats
+// open to everyone.
+const companionRelease = time.Hour
+
+var (
+	ErrHallNotFound  = errors.New("hall not found")
+	ErrCompanionSeat = errors.New("companion seat is kept for whoever books its accessible seat")
+)
+
+// HallSeats returns every seat of a hall with its category.
+func (s *MovieStore) HallSeats(hallID int) ([]models.Seat, error) {
+	var total, perRow int
+	err := s.db.QueryRow(`SELECT total_seats, seats_per_row FROM halls WHERE id = $1`, hallID).Scan(&total, &perRow)
+	if err == sql.ErrNoRows {
+		return nil, ErrHallNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	seats := make([]models.Seat, total)
+	for i := range seats {
+		seats[i] = models.Seat{ID: i + 1, HallID: hallID, Category: models.SeatStandard}
+		seats[i].Row, seats[i].Number = seatPosition(i+1, perRow)
+	}
+
+	rows, err := s.db.Query(`SELECT seat, category, companion_of FROM hall_seats WHERE hall_id = $1`, hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seat int
+		var category string
+		var companionOf *int
+		if err := rows.Scan(&seat, &category, &companionOf); err != nil {
+			return nil, err
+		}
+		// Seats beyond a hall that shrank are ignored.
+		if seat >= 1 && seat <= total {
+			seats[seat-1].Category, seats[seat-1].CompanionOf = category, companionOf
+		}
+	}
+	return seats, rows.Err()
+}
+
+// SetHallSeats replaces the categories of a hall's seats. Only seats that
+// are not plain standard ones need to be listed. A companion seat must
+// point at an accessible seat of the same row.
+func (s *MovieStore) SetHallSeats(hallID int, seats []models.Seat) error {
+	var total, perRow int
+	err := s.db.QueryRow(`SELECT total_seats, seats_per_row FROM halls WHERE id = $1`, hallID).Scan(&total, &perRow)
+	if err == sql.ErrNoRows {
+		return ErrHallNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	category := map[int]string{}
+	for i, seat := range seats {
+		if seat.Category == "" {
+			seats[i].Category = models.SeatStandard
+		}
+		if seat.ID < 1 || seat.ID > total {
+			return fmt.Errorf("seat %d does not exist, the hall has %d", seat.ID, total)
+		}
+		if _, dup := category[seat.ID]; dup {
+			return fmt.Errorf("seat %d is listed twice", seat.ID)
+		}
+		if !slices.Contains(models.SeatCategories, seats[i].Category) {
+			return fmt.Errorf("seat %d: category must be one of standard, vip, couple, accessible", seat.ID)
+		}
+		category[seat.ID] = seats[i].Category
+	}
+	for _, seat := range seats {
+		if seat.CompanionOf == nil {
+			continue
+		}
+		of := *seat.CompanionOf
+		if category[of] != models.SeatAccessible || seat.Category == models.SeatAccessible {
+			return fmt.Errorf("seat %d: companion_of must name an accessible seat and the companion cannot be one", seat.ID)
+		}
+		if row, _ := seatPosition(of, perRow); row != (seat.ID-1)/perRow+1 {
+			return fmt.Errorf("seat %d: a companion seat must be in the row of seat %d", seat.ID, of)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM hall_seats WHERE hall_id = $1`, hallID); err != nil {
+		return err
+	}
+	for _, seat := range seats {
+		if seat.Category == models.SeatStandard && seat.CompanionOf == nil {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO hall_seats (hall_id, seat, category, companion_of) VALUES ($1, $2, $3, $4)`,
+			hallID, seat.ID, seat.Category, seat.CompanionOf); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CategoryPrices returns the price adjustment of every category for a
+// session; categories without one keep the base price.
+func (s *MovieStore) CategoryPrices(sessionID int) (map[string]models.CategoryPrice, error) {
+	prices := map[string]models.CategoryPrice{}
+	for _, c := range models.SeatCategories {
+		prices[c] = models.CategoryPrice{Category: c, Multiplier: 1}
+	}
+	rows, err := s.db.Query(`SELECT category, multiplier, surcharge FROM session_category_prices WHERE session_id = $1`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.CategoryPrice
+		if err := rows.Scan(&p.Category, &p.Multiplier, &p.Surcharge); err != nil {
+			return nil, err
+		}
+		prices[p.Category] = p
+	}
+	return prices, rows.Err()
+}
+
+// SetCategoryPrices sets the adjustments of the listed categories for a
+// session. A multiplier of 0 is read as "not given" and means 1.
+func (s *MovieStore) SetCategoryPrices(sessionID int, prices []models.CategoryPrice) error {
+	for i, p := range prices {
+		if !slices.Contains(models.SeatCategories, p.Category) {
+			return fmt.Errorf("category must be one of standard, vip, couple, accessible, not %q", p.Category)
+		}
+		if p.Multiplier < 0 || p.Multiplier > 100 {
+			return errors.New("multiplier must be between 0 and 100")
+		}
+		if p.Multiplier == 0 {
+			prices[i].Multiplier = 1
+		}
+	}
+	if _, err := s.GetSession(sessionID); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range prices {
+		if _, err := tx.Exec(`INSERT INTO session_category_prices (session_id, category, multiplier, surcharge)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (session_id, category) DO UPDATE SET multiplier = EXCLUDED.multiplier, surcharge = EXCLUDED.surcharge`,
+			sessionID, p.Category, p.Multiplier, p.Surcharge); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// categoryPrice applies a category adjustment to a base price. It never
+// goes below zero.
+func categoryPrice(base float64, p models.CategoryPrice) int {
+	return max(int(math.Round(base*p.Multiplier))+p.Surcharge, 0)
+}
+
+// checkCompanion enforces the companion rule inside lockSeat: until
+// companionRelease before the start, a companion seat goes only to the
+// customer who holds or booked its accessible seat.
+func checkCompanion(tx *sql.Tx, hallID, sessionID, seat, userID int, startsAt time.Time) error {
+	var companionOf sql.NullInt64
+	err := tx.QueryRow(`SELECT companion_of FROM hall_seats WHERE hall_id = $1 AND seat = $2`, hallID, seat).Scan(&companionOf)
+	if err == sql.ErrNoRows || (err == nil && !companionOf.Valid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Until(startsAt) <= companionRelease {
+		return nil
+	}
+	var entitled bool
+	err = tx.QueryRow(`SELECT
+		EXISTS (SELECT 1 FROM tickets WHERE session_id = $1 AND seat_id = $2 AND user_id = $3
+		        AND upper(COALESCE(status, 'BOOKED')) <> 'CANCELLED')
+		OR EXISTS (SELECT 1 FROM seat_holds WHERE session_id = $1 AND seat = $2 AND user_id = $3 AND expires_at > now())`,
+		sessionID, companionOf.Int64, userID).Scan(&entitled)
+	if err != nil {
+		return err
+	}
+	if !entitled {
+		return ErrCompanionSeat
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"Final_1/internal/ticketcheck"
+)
+
+const (
+	// checkInOpens is how long before the start the doors open.
+	checkInOpens = time.Hour
+	// doubleScanWindow accepts a repeat scan by the same usher as a no-op.
+	doubleScanWindow = 2 * time.Minute
+)
+
+type CheckInResult struct {
+	Accepted  bool       `json:"accepted"`
+	Reason    string     `json:"reason,omitempty"`
+	Repeat    bool       `json:"repeat,omitempty"` // the same usher scanned it moments ago
+	TicketID  int        `json:"ticket_id,omitempty"`
+	SessionID int        `json:"session_id,omitempty"`
+	Seat      int        `json:"seat,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+func rejected(reason string, c ticketcheck.Claims) CheckInResult {
+	return CheckInResult{Reason: reason, TicketID: c.TicketID, SessionID: c.SessionID, Seat: c.Seat}
+}
+
+// CheckIn marks the ticket in verified claims as used for sessionID, the
+// session the usher is admitting. The ticket row is locked, so two doors
+// scanning the same ticket cannot both let it in.
+func (s *MovieStore) CheckIn(c ticketcheck.Claims, sessionID, usherID int, now time.Time) (CheckInResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return CheckInResult{}, err
+	}
+	defer tx.Rollback()
+
+	var ticketSession, seat, duration int
+	var status string
+	var usedAt sql.NullTime
+	var usedBy sql.NullInt64
+	var startsAt time.Time
+	err = tx.QueryRow(`
+		SELECT t.session_id, t.seat_id, upper(COALESCE(t.status, 'BOOKED')), t.used_at, t.checked_in_by, s.starts_at, m.duration
+		FROM tickets t
+		JOIN sessions s ON s.id = t.session_id
+		JOIN movies m ON m.id = s.movie_id
+		WHERE t.id = $1
+		FOR UPDATE OF t`, c.TicketID).
+		Scan(&ticketSession, &seat, &status, &usedAt, &usedBy, &startsAt, &duration)
+	if err == sql.ErrNoRows {
+		return rejected(ticketcheck.ReasonNotFound, c), nil
+	}
+	if err != nil {
+		return CheckInResult{}, err
+	}
+
+	switch {
+	// A genuine signature over data that no longer matches the ticket.
+	case ticketSession != c.SessionID || seat != c.Seat:
+		return rejected(ticketcheck.ReasonForged, c), nil
+	case ticketSession != sessionID:
+		return rejected(ticketcheck.ReasonWrongSession, c), nil
+	case status == "CANCELLED":
+		return rejected(ticketcheck.ReasonCancelled, c), nil
+	case status == "USED":
+		res := rejected(ticketcheck.ReasonAlreadyUsed, c)
+		if usedAt.Valid {
+			res.UsedAt = &usedAt.Time
+		}
+		if usedBy.Valid && int(usedBy.Int64) == usherID && usedAt.Valid && now.Sub(usedAt.Time) < doubleScanWindow {
+			res.Accepted, res.Repeat, res.Reason = true, true, ""
+		}
+		return res, nil
+	case now.Before(startsAt.Add(-checkInOpens)):
+		return rejected(ticketcheck.ReasonTooEarly, c), nil
+	case now.After(startsAt.Add(time.Duration(duration)*time.Minute)) || now.After(c.Expires):
+		return rejected(ticketcheck.ReasonExpired, c), nil
+	}
+
+	if _, err := tx.Exec(`UPDATE tickets SET status = 'USED', used_at = $1, checked_in_by = $2, checked_in_device = NULL
+		WHERE id = $3`, now, usherID, c.TicketID); err != nil {
+		return CheckInResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CheckInResult{}, err
+	}
+	return CheckInResult{Accepted: true, TicketID: c.TicketID, SessionID: c.SessionID, Seat: c.Seat, UsedAt: &now}, nil
+}
+
+// SessionManifest lists the tickets of a session for offline scanners.
+func (s *MovieStore) SessionManifest(sessionID int) (ticketcheck.Manifest, error) {
+	si, err := s.GetSession(sessionID)
+	if err != nil {
+		return ticketcheck.Manifest{}, err
+	}
+	m := ticketcheck.Manifest{
+		SessionID: si.ID,
+		StartsAt:  si.Time,
+		EndsAt:    si.Time.Add(time.Duration(si.Duration) * time.Minute),
+		OpensAt:   si.Time.Add(-checkInOpens),
+		IssuedAt:  time.Now().UTC(),
+		Tickets:   []ticketcheck.ManifestTicket{},
+	}
+
+	rows, err := s.db.Query(`SELECT id, seat_id, upper(COALESCE(status, 'BOOKED')) FROM tickets
+		WHERE session_id = $1 ORDER BY id`, sessionID)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t ticketcheck.ManifestTicket
+		if err := rows.Scan(&t.TicketID, &t.Seat, &t.Status); err != nil {
+			return m, err
+		}
+		switch t.Status {
+		case "USED":
+			t.Status = ticketcheck.StatusUsed
+		case "CANCELLED":
+			t.Status = ticketcheck.StatusCancelled
+		default:
+			t.Status = ticketcheck.StatusValid
+		}
+		m.Tickets = append(m.Tickets, t)
+	}
+	return m, rows.Err()
+}
+
+// OfflineScan is an uploaded scan; Claims is nil when its payload did not
+// verify.
+type OfflineScan struct {
+	ticketcheck.Scan
+	Claims *ticketcheck.Claims
+}
+
+// Outcomes of an uploaded scan.
+const (
+	OutcomeAdmitted  = "admitted"  // this scan is the ticket's check-in
+	OutcomeDuplicate = "duplicate" // the ticket was checked in earlier elsewhere
+	OutcomeConflict  = "conflict"  // scanned before the check-in on record, but synced after it
+	OutcomeInvalid   = "invalid"   // admitted offline, but the ticket was not valid
+	OutcomeRejected  = "rejected"  // the scanner already turned it away
+)
+
+type SyncResult struct {
+	ScanID       string     `json:"scan_id"`
+	Outcome      string     `json:"outcome"`
+	Reason       string     `json:"reason,omitempty"`
+	TicketID     int        `json:"ticket_id,omitempty"`
+	WinnerDevice string     `json:"winner_device,omitempty"` // for duplicates and conflicts; "online" for the check-in endpoint
+	WinnerAt     *time.Time `json:"winner_at,omitempty"`
+}
+
+// SyncScans records a scanner's log. When two devices admitted the same
+// ticket the first check-in recorded by the server stands; a later scan is a
+// duplicate, and an earlier one that synced too late is kept as a conflict
+// next to the winner for staff to review. Uploading the same log again
+// changes nothing.
+func (s *MovieStore) SyncScans(usherID int, deviceID string, scans []OfflineScan) ([]SyncResult, error) {
+	sort.SliceStable(scans, func(i, j int) bool { return scans[i].ScannedAt.Before(scans[j].ScannedAt) })
+	results := make([]SyncResult, 0, len(scans))
+	for _, sc := range scans {
+		res, err := s.syncScan(usherID, deviceID, sc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (s *MovieStore) syncScan(usherID int, deviceID string, sc OfflineScan) (SyncResult, error) {
+	res := SyncResult{ScanID: sc.ScanID}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	var ticketID sql.NullInt64
+	var winnerDevice sql.NullString
+	var winnerAt sql.NullTime
+	err = tx.QueryRow(`SELECT outcome, reason, ticket_id, winner_device, winner_at
+		FROM checkin_scans WHERE device_id = $1 AND scan_id = $2`,
+		deviceID, sc.ScanID).Scan(&res.Outcome, &res.Reason, &ticketID, &winnerDevice, &winnerAt)
+	if err == nil {
+		res.TicketID = int(ticketID.Int64)
+		res.WinnerDevice = winnerDevice.String
+		if winnerAt.Valid {
+			res.WinnerAt = &winnerAt.Time
+		}
+		return res, nil
+	}
+	if err != sql.ErrNoRows {
+		return res, err
+	}
+
+	if err := resolveScan(tx, usherID, deviceID, sc, &res); err != nil {
+		return res, err
+	}
+	if res.TicketID != 0 {
+		ticketID = sql.NullInt64{Int64: int64(res.TicketID), Valid: true}
+	}
+	if res.WinnerAt != nil {
+		winnerDevice = sql.NullString{String: res.WinnerDevice, Valid: true}
+		winnerAt = sql.NullTime{Time: *res.WinnerAt, Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO checkin_scans
+		(device_id, scan_id, usher_id, ticket_id, session_id, scanned_at, accepted_offline, outcome, reason,
+		 winner_device, winner_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		deviceID, sc.ScanID, usherID, ticketID, sc.SessionID, sc.ScannedAt, sc.Accepted, res.Outcome, res.Reason,
+		winnerDevice, winnerAt)
+	if err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+func resolveScan(tx *sql.Tx, usherID int, deviceID string, sc OfflineScan, res *SyncResult) error {
+	if sc.Claims == nil {
+		res.Outcome, res.Reason = OutcomeRejected, ticketcheck.ReasonForged
+		if sc.Accepted {
+			res.Outcome = OutcomeInvalid
+		}
+		return nil
+	}
+	c := *sc.Claims
+	res.TicketID = c.TicketID
+	if !sc.Accepted {
+		res.Outcome, res.Reason = OutcomeRejected, sc.Reason
+		return nil
+	}
+
+	var session, seat int
+	var status string
+	var usedAt sql.NullTime
+	var usedDevice sql.NullString
+	err := tx.QueryRow(`SELECT session_id, seat_id, upper(COALESCE(status, 'BOOKED')), used_at, checked_in_device
+		FROM tickets WHERE id = $1 FOR UPDATE`, c.TicketID).Scan(&session, &seat, &status, &usedAt, &usedDevice)
+	if err == sql.ErrNoRows {
+		res.Outcome, res.Reason = OutcomeInvalid, ticketcheck.ReasonNotFound
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	res.Outcome = OutcomeInvalid
+	switch {
+	case session != c.SessionID || seat != c.Seat:
+		res.Reason = ticketcheck.ReasonForged
+		return nil
+	case session != sc.SessionID:
+		res.Reason = ticketcheck.ReasonWrongSession
+		return nil
+	case status == "CANCELLED":
+		res.Reason = ticketcheck.ReasonCancelled
+		return nil
+	case status == "USED":
+		// The check-in on record stands even when this scan is older: the
+		// guest is already inside and the other device's log may be gone.
+		res.Outcome, res.Reason = OutcomeDuplicate, ticketcheck.ReasonAlreadyUsed
+		res.WinnerDevice = "online"
+		if usedDevice.Valid {
+			res.WinnerDevice = usedDevice.String
+		}
+		if usedAt.Valid {
+			res.WinnerAt = &usedAt.Time
+			if usedAt.Time.After(sc.ScannedAt) {
+				res.Outcome = OutcomeConflict
+			}
+		}
+		return nil
+	}
+
+	if _, err := tx.Exec(`UPDATE tickets SET status = 'USED', used_at = $1, checked_in_by = $2, checked_in_device = $3
+		WHERE id = $4`, sc.ScannedAt, usherID, deviceID, c.TicketID); err != nil {
+		return err
+	}
+	res.Outcome = OutcomeAdmitted
+	return nil
+}
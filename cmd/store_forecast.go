@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// trainingWindow is how far back past sessions are used to fit the model.
+	trainingWindow      = 365 * 24 * time.Hour
+	defaultForecastDays = 7
+	maxForecastDays     = 30
+)
+
+type Forecast struct {
+	SessionID     int       `json:"session_id"`
+	MovieID       int       `json:"movie_id"`
+	MovieTitle    string    `json:"movie_title"`
+	HallName      string    `json:"hall_name"`
+	StartsAt      time.Time `json:"starts_at"`
+	Capacity      int       `json:"capacity"`
+	Sold          int       `json:"sold"`
+	ModelEstimate int       `json:"model_estimate"` // from features alone
+	Predicted     int       `json:"predicted"`      // blended with sales so far
+	Occupancy     float64   `json:"occupancy"`      // predicted, percent
+}
+
+type HorizonAccuracy struct {
+	Horizon  string  `json:"horizon"` // days between forecast and start, e.g. "2-3"
+	Sessions int     `json:"sessions"`
+	MAE      float64 `json:"mae"`  // mean absolute error in tickets
+	RMSE     float64 `json:"rmse"` // root mean squared error in tickets
+	MAPE     float64 `json:"mape"` // mean absolute percentage error; sessions that sold nothing are left out
+	Bias     float64 `json:"bias"` // mean of predicted - actual
+}
+
+type ForecastAccuracy struct {
+	Since     time.Time         `json:"since"`
+	Overall   HorizonAccuracy   `json:"overall"`
+	ByHorizon []HorizonAccuracy `json:"by_horizon"`
+}
+
+type sessionSample struct {
+	sessionFeatures
+	ID         int
+	MovieID    int
+	MovieTitle string
+	HallName   string
+	Capacity   int
+	Tickets    int
+}
+
+// loadSessions reads sessions starting in [from, to) with their features and
+// tickets sold so far (from the rollup, so it lags by one refresh).
+func (s *MovieStore) loadSessions(from, to time.Time) ([]sessionSample, error) {
+	rows, err := s.db.Query(`
+		SELECT s.id, s.movie_id, m.title, h.name, s.starts_at, h.total_seats, COALESCE(sr.tickets, 0), m.release_date,
+		       ARRAY(SELECT g.name FROM movie_genres mg JOIN genres g ON g.id = mg.genre_id WHERE mg.movie_id = m.id)
+		FROM sessions s
+		JOIN movies m ON m.id = s.movie_id
+		JOIN halls h ON h.id = s.hall_id
+		LEFT JOIN session_rollup sr ON sr.session_id = s.id
+		WHERE s.starts_at >= $1 AND s.starts_at < $2
+		ORDER BY s.starts_at, s.id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []sessionSample
+	for rows.Next() {
+		var ss sessionSample
+		var release sql.NullTime
+		if err := rows.Scan(&ss.ID, &ss.MovieID, &ss.MovieTitle, &ss.HallName, &ss.StartsAt, &ss.Capacity, &ss.Tickets,
+			&release, pq.Array(&ss.Genres)); err != nil {
+			return nil, err
+		}
+		if release.Valid {
+			ss.ReleaseDate = &release.Time
+		}
+		out = append(out, ss)
+	}
+	return out, rows.Err()
+}
+
+// loadSalesCurve averages, over sessions that sold anything, the share of
+// their tickets sold 0..curveDays days before they started.
+func (s *MovieStore) loadSalesCurve(since time.Time) (salesCurve, error) {
+	rows, err := s.db.Query(`
+		SELECT d, AVG(LEAST(c.sold::float / sr.tickets, 1))
+		FROM session_rollup sr
+		CROSS JOIN generate_series(0, $2::int) AS d
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(x.tickets), 0) AS sold FROM sales_rollup x
+			WHERE x.session_id = sr.session_id AND x.bucket < sr.starts_at - make_interval(days => d)
+		) c
+		WHERE sr.starts_at >= $1 AND sr.starts_at < now() AND sr.tickets > 0
+		GROUP BY d ORDER BY d`, since, curveDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var curve salesCurve
+	for rows.Next() {
+		var d int
+		var share float64
+		if err := rows.Scan(&d, &share); err != nil {
+			return nil, err
+		}
+		curve = append(curve, share)
+	}
+	return curve, rows.Err()
+}
+
+// Forecasts predicts the final attendance of the sessions in the next days.
+// It only reads; RecordForecasts stores predictions for accuracy tracking.
+func (s *MovieStore) Forecasts(days int) ([]Forecast, error) {
+	now := time.Now()
+	past, err := s.loadSessions(now.Add(-trainingWindow), now)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]sessionFeatures, len(past))
+	occ := make([]float64, len(past))
+	for i, p := range past {
+		samples[i] = p.sessionFeatures
+		occ[i] = float64(p.Tickets) / float64(p.Capacity)
+	}
+	model := trainAttendanceModel(samples, occ)
+
+	curve, err := s.loadSalesCurve(now.Add(-trainingWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	upcoming, err := s.loadSessions(now, now.AddDate(0, 0, days))
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts := []Forecast{}
+	for _, u := range upcoming {
+		modelTickets := model.predict(u.sessionFeatures) * float64(u.Capacity)
+		f := Forecast{
+			SessionID:     u.ID,
+			MovieID:       u.MovieID,
+			MovieTitle:    u.MovieTitle,
+			HallName:      u.HallName,
+			StartsAt:      u.StartsAt,
+			Capacity:      u.Capacity,
+			Sold:          u.Tickets,
+			ModelEstimate: int(modelTickets + 0.5),
+			Predicted:     blendForecast(u.Tickets, modelTickets, curve, u.StartsAt.Sub(now), u.Capacity),
+		}
+		f.Occupancy = occupancy(f.Predicted, f.Capacity)
+		forecasts = append(forecasts, f)
+	}
+	return forecasts, nil
+}
+
+// RecordForecasts stores today's prediction for every session in the next
+// days, so ForecastAccuracy can score them once the sessions have run. The
+// rollup job calls it on every run.
+func (s *MovieStore) RecordForecasts(days int) error {
+	forecasts, err := s.Forecasts(days)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, f := range forecasts {
+		_, err := tx.Exec(`INSERT INTO session_forecasts (session_id, horizon_days, predicted) VALUES ($1, $2, $3)
+			ON CONFLICT (session_id, made_on) DO UPDATE SET horizon_days = EXCLUDED.horizon_days, predicted = EXCLUDED.predicted`,
+			f.SessionID, int(f.StartsAt.Sub(now).Hours()/24), f.Predicted)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ForecastAccuracy compares recorded forecasts of sessions that started since
+// with what they actually sold.
+func (s *MovieStore) ForecastAccuracy(since time.Time) (ForecastAccuracy, error) {
+	acc := ForecastAccuracy{Since: since, Overall: HorizonAccuracy{Horizon: "all"}, ByHorizon: []HorizonAccuracy{}}
+	rows, err := s.db.Query(`
+		SELECT CASE WHEN f.horizon_days <= 1 THEN '0-1'
+		            WHEN f.horizon_days <= 3 THEN '2-3'
+		            WHEN f.horizon_days <= 7 THEN '4-7'
+		            ELSE '8+' END AS horizon,
+		       COUNT(*),
+		       AVG(abs(f.predicted - sr.tickets)),
+		       sqrt(AVG((f.predicted - sr.tickets)^2)),
+		       COALESCE(AVG(abs(f.predicted - sr.tickets)::float / NULLIF(sr.tickets, 0)) * 100, 0),
+		       AVG(f.predicted - sr.tickets)
+		FROM session_forecasts f
+		JOIN session_rollup sr ON sr.session_id = f.session_id
+		WHERE sr.starts_at >= $1 AND sr.starts_at < now()
+		GROUP BY ROLLUP (horizon)
+		ORDER BY horizon NULLS FIRST`, since)
+	if err != nil {
+		return acc, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h HorizonAccuracy
+		var horizon sql.NullString
+		if err := rows.Scan(&horizon, &h.Sessions, &h.MAE, &h.RMSE, &h.MAPE, &h.Bias); err != nil {
+			return acc, err
+		}
+		if !horizon.Valid {
+			h.Horizon = "all"
+			acc.Overall = h
+			continue
+		}
+		h.Horizon = horizon.String
+		acc.ByHorizon = append(acc.ByHorizon, h)
+	}
+	return acc, rows.Err()
+}
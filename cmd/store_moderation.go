@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Final_1/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	ReviewVisible = "visible"
+	ReviewFlagged = "flagged"
+	ReviewHidden  = "hidden"
+)
+
+// reportsToFlag is how many distinct reports pull a visible review out of
+// public listings until a moderator looks at it.
+const reportsToFlag = 3
+
+var ErrAlreadyReported = errors.New("you have already reported this review")
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func logModeration(db execer, reviewID int, actorID *int, action, reason string) error {
+	_, err := db.Exec(`INSERT INTO review_moderation_log (review_id, actor_id, action, reason)
+		VALUES ($1, $2, $3, $4)`, reviewID, actorID, action, reason)
+	return err
+}
+
+func (s *MovieStore) ReportReview(reviewID, reporterID int, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return reviewInputError("reason is required")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var movieID, stars int
+	err = tx.QueryRow(`SELECT status, movie_id, rating FROM reviews WHERE id = $1 FOR UPDATE`,
+		reviewID).Scan(&status, &movieID, &stars)
+	if err == sql.ErrNoRows {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO review_reports (review_id, reporter_id, reason) VALUES ($1, $2, $3)
+		ON CONFLICT (review_id, reporter_id) DO NOTHING`, reviewID, reporterID, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAlreadyReported
+	}
+	if err := logModeration(tx, reviewID, &reporterID, "reported", reason); err != nil {
+		return err
+	}
+
+	var open int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM review_reports WHERE review_id = $1 AND resolved_at IS NULL`,
+		reviewID).Scan(&open)
+	if err != nil {
+		return err
+	}
+	if status == ReviewVisible && open >= reportsToFlag {
+		if _, err := tx.Exec(`UPDATE reviews SET status = $1 WHERE id = $2`, ReviewFlagged, reviewID); err != nil {
+			return err
+		}
+		if err := logModeration(tx, reviewID, nil, "auto_flag", fmt.Sprintf("%d open reports", open)); err != nil {
+			return err
+		}
+		if err := rerateReview(tx, movieID, stars, status, stars, ReviewFlagged); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// QueueItem is a review waiting for a moderator with its open reports.
+type QueueItem struct {
+	models.Review
+	ReportCount int                   `json:"report_count"`
+	Reports     []models.ReviewReport `json:"reports"`
+}
+
+// ModerationQueue lists flagged reviews and reviews with unresolved reports,
+// most reported first.
+func (s *MovieStore) ModerationQueue(p PageParams) ([]QueueItem, int, error) {
+	const where = ` WHERE r.status = 'flagged'
+		OR EXISTS (SELECT 1 FROM review_reports rr WHERE rr.review_id = r.id AND rr.resolved_at IS NULL)`
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM reviews r` + where).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+		       (SELECT COUNT(*) FROM review_reports rr WHERE rr.review_id = r.id AND rr.resolved_at IS NULL) AS reports
+		FROM reviews r JOIN users u ON u.id = r.user_id%s
+		ORDER BY reports DESC, r.updated_at ASC LIMIT %d OFFSET %d`, reviewColumns, where, p.Limit, p.Offset)
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []QueueItem
+	byID := map[int]int{}
+	for rows.Next() {
+		var it QueueItem
+		rv, err := scanReview(rows, &it.ReportCount)
+		if err != nil {
+			return nil, 0, err
+		}
+		it.Review = rv
+		it.Reports = []models.ReviewReport{}
+		byID[rv.ID] = len(items)
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil || len(items) == 0 {
+		return items, total, err
+	}
+
+	ids := make([]int64, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, int64(it.ID))
+	}
+	reports, err := s.db.Query(`SELECT id, review_id, reporter_id, reason, created_at FROM review_reports
+		WHERE review_id = ANY($1) AND resolved_at IS NULL ORDER BY created_at`, pq.Array(ids))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reports.Close()
+	for reports.Next() {
+		var rr models.ReviewReport
+		if err := reports.Scan(&rr.ID, &rr.ReviewID, &rr.ReporterID, &rr.Reason, &rr.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		i := byID[rr.ReviewID]
+		items[i].Reports = append(items[i].Reports, rr)
+	}
+	return items, total, reports.Err()
+}
+
+// Moderate applies a moderator decision and resolves the open reports.
+// action is approve, hide or delete.
+func (s *MovieStore) Moderate(reviewID, moderatorID int, action, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if action != "approve" && reason == "" {
+		return reviewInputError("reason is required to hide or delete a review")
+	}
+	if action == "delete" {
+		// DeleteReview writes the log entry; reports go with the review.
+		return s.DeleteReview(reviewID, moderatorID, true, reason)
+	}
+
+	var status, logged string
+	switch action {
+	case "approve":
+		status, logged = ReviewVisible, "approved"
+	case "hide":
+		status, logged = ReviewHidden, "hidden"
+	default:
+		return reviewInputError("action must be approve, hide or delete")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldStatus string
+	var movieID, stars int
+	err = tx.QueryRow(`SELECT status, movie_id, rating FROM reviews WHERE id = $1 FOR UPDATE`,
+		reviewID).Scan(&oldStatus, &movieID, &stars)
+	if err == sql.ErrNoRows {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE reviews SET status = $1 WHERE id = $2`, status, reviewID); err != nil {
+		return err
+	}
+	if err := rerateReview(tx, movieID, stars, oldStatus, stars, status); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE review_reports SET resolved_at = now() WHERE review_id = $1 AND resolved_at IS NULL`,
+		reviewID); err != nil {
+		return err
+	}
+	if err := logModeration(tx, reviewID, &moderatorID, logged, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReviewHistory returns every moderation event of a review, oldest first. It
+// keeps working after the review itself was deleted.
+func (s *MovieStore) ReviewHistory(reviewID int) ([]models.ModerationEvent, error) {
+	rows, err := s.db.Query(`SELECT id, review_id, actor_id, action, reason, created_at
+		FROM review_moderation_log WHERE review_id = $1 ORDER BY id`, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ModerationEvent{}
+	for rows.Next() {
+		var e models.ModerationEvent
+		var actor sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.ReviewID, &actor, &e.Action, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if actor.Valid {
+			id := int(actor.Int64)
+			e.ActorID = &id
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -3,22 +3,65 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"slices"
 	"strings"
 
 	"Final_1/internal/models"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type MovieStore struct {
 	db *sql.DB
 }
 type MoviePatch struct {
-	Title    *string  `json:"title"`
-	Genre    *string  `json:"genre"`
-	Duration *int     `json:"duration"`
-	Price    *int     `json:"price"`
-	Rating   *float64 `json:"rating"`
+	Title       *string              `json:"title"`
+	Genres      *[]string            `json:"genres"`
+	Duration    *int                 `json:"duration"`
+	Price       *int                 `json:"price"`
+	Description *string              `json:"description"`
+	ReleaseDate *models.Date         `json:"release_date"`
+	Language    *string              `json:"language"`
+	Subtitles   *[]string            `json:"subtitles"`
+	AgeRating   *string              `json:"age_rating"`
+	Directors   *[]string            `json:"directors"`
+	Cast        *[]models.CastMember `json:"cast"`
+	PosterURL   *string              `json:"poster_url"`
+	TrailerURL  *string              `json:"trailer_url"`
+}
+
+// ageRatings are the certifications we accept: the local 0+..18+ scale and
+// the MPA letters used by distributors.
+var ageRatings = []string{"", "0+", "6+", "12+", "14+", "16+", "18+", "G", "PG", "PG-13", "R", "NC-17"}
+
+// movieColumns must stay in sync with scanMovie.
+const movieColumns = `m.id, m.title, m.duration, m.price, m.rating, m.rating_count, m.rating_hist,
+	m.description, m.release_date, m.language, m.subtitles, m.age_rating, m.poster_url, m.trailer_url`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMovie reads movieColumns plus any extra selected columns.
+func scanMovie(row rowScanner, extra ...any) (models.Movie, error) {
+	var m models.Movie
+	var release sql.NullTime
+	var hist []int64
+	dest := []any{&m.ID, &m.Title, &m.Duration, &m.Price, &m.Rating, &m.RatingCount, pq.Array(&hist),
+		&m.Description, &release, &m.Language, pq.Array(&m.Subtitles), &m.AgeRating, &m.PosterURL, &m.TrailerURL}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return m, err
+	}
+	m.RatingHistogram = make([]int, len(hist))
+	for i, n := range hist {
+		m.RatingHistogram[i] = int(n)
+	}
+	if release.Valid {
+		m.ReleaseDate = &models.Date{Time: release.Time}
+	}
+	return m, nil
 }
 
 func NewMovieStore(connStr string) (*MovieStore, error) {
@@ -32,36 +75,183 @@ func NewMovieStore(connStr string) (*MovieStore, error) {
 	return &MovieStore{db: db}, nil
 }
 
-func (s *MovieStore) Create(m models.Movie) (models.Movie, error) {
+func cleanList(items []string) []string {
+	out := []string{}
+	for _, it := range items {
+		it = strings.TrimSpace(it)
+		if it != "" && !slices.Contains(out, it) {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+func validURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// normalizeMovie trims and validates a movie before it is written.
+func normalizeMovie(m *models.Movie) error {
 	m.Title = strings.TrimSpace(m.Title)
-	m.Genre = strings.TrimSpace(m.Genre)
+	m.Description = strings.TrimSpace(m.Description)
+	m.Language = strings.ToLower(strings.TrimSpace(m.Language))
+	m.AgeRating = strings.ToUpper(strings.TrimSpace(m.AgeRating))
+	m.PosterURL = strings.TrimSpace(m.PosterURL)
+	m.TrailerURL = strings.TrimSpace(m.TrailerURL)
+	m.Genres = cleanList(m.Genres)
+	m.Directors = cleanList(m.Directors)
+	m.Subtitles = cleanList(m.Subtitles)
+	for i := range m.Subtitles {
+		m.Subtitles[i] = strings.ToLower(m.Subtitles[i])
+	}
+
+	cast := []models.CastMember{}
+	for _, c := range m.Cast {
+		c.Name = strings.TrimSpace(c.Name)
+		c.Character = strings.TrimSpace(c.Character)
+		if c.Name != "" {
+			cast = append(cast, c)
+		}
+	}
+	m.Cast = cast
 
 	if m.Title == "" {
-		return models.Movie{}, errors.New("title is required")
+		return errors.New("title is required")
 	}
 	if m.Duration <= 0 {
-		return models.Movie{}, errors.New("duration must be > 0")
+		return errors.New("duration must be > 0")
 	}
 	if m.Price < 0 {
-		return models.Movie{}, errors.New("price cannot be negative")
+		return errors.New("price cannot be negative")
+	}
+	if !slices.Contains(ageRatings, m.AgeRating) {
+		return fmt.Errorf("age_rating must be one of %s", strings.Join(ageRatings[1:], ", "))
 	}
-	if m.Rating < 0 || m.Rating > 5 {
-		return models.Movie{}, errors.New("rating must be between 0 and 5")
+	if m.Language != "" && len(m.Language) != 2 {
+		return errors.New("language must be a two-letter ISO 639-1 code")
 	}
+	for _, l := range m.Subtitles {
+		if len(l) != 2 {
+			return errors.New("subtitles must be two-letter ISO 639-1 codes")
+		}
+	}
+	if !validURL(m.PosterURL) || !validURL(m.TrailerURL) {
+		return errors.New("poster_url and trailer_url must be http(s) URLs")
+	}
+	return nil
+}
 
-	query := `INSERT INTO movies (title, genre, duration, price, rating) 
-          VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err := s.db.QueryRow(query, m.Title, m.Genre, m.Duration, m.Price, m.Rating).Scan(&m.ID)
+// saveMovieRelations replaces the genres and credits of a movie and refreshes
+// its search document.
+func saveMovieRelations(tx *sql.Tx, m models.Movie) error {
+	if _, err := tx.Exec(`DELETE FROM movie_genres WHERE movie_id = $1`, m.ID); err != nil {
+		return err
+	}
+	for _, g := range m.Genres {
+		var genreID int
+		err := tx.QueryRow(`
+			WITH ins AS (
+				INSERT INTO genres (name) VALUES ($1) ON CONFLICT DO NOTHING RETURNING id
+			)
+			SELECT id FROM ins
+			UNION ALL
+			SELECT id FROM genres WHERE lower(name) = lower($1)
+			LIMIT 1`, g).Scan(&genreID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO movie_genres (movie_id, genre_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			m.ID, genreID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM movie_credits WHERE movie_id = $1`, m.ID); err != nil {
+		return err
+	}
+	insertCredit := `INSERT INTO movie_credits (movie_id, role, position, name, character_name) VALUES ($1, $2, $3, $4, $5)`
+	for i, d := range m.Directors {
+		if _, err := tx.Exec(insertCredit, m.ID, "director", i, d, ""); err != nil {
+			return err
+		}
+	}
+	for i, c := range m.Cast {
+		if _, err := tx.Exec(insertCredit, m.ID, "cast", i, c.Name, c.Character); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE movies m SET search_doc = `+movieSearchDoc+` WHERE m.id = $1`, m.ID)
+	return err
+}
+
+// loadMovieRelations fills genres, directors and cast for a batch of movies
+// with two queries instead of two per movie.
+func (s *MovieStore) loadMovieRelations(movies []models.Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(movies))
+	byID := make(map[int]*models.Movie, len(movies))
+	for i := range movies {
+		ids[i] = int64(movies[i].ID)
+		byID[movies[i].ID] = &movies[i]
+		movies[i].Genres = []string{}
+		movies[i].Directors = []string{}
+		movies[i].Cast = []models.CastMember{}
+		if movies[i].Subtitles == nil {
+			movies[i].Subtitles = []string{}
+		}
+	}
+
+	rows, err := s.db.Query(`
+		SELECT mg.movie_id, g.name FROM movie_genres mg
+		JOIN genres g ON g.id = mg.genre_id
+		WHERE mg.movie_id = ANY($1) ORDER BY g.name`, pq.Array(ids))
 	if err != nil {
-		return models.Movie{}, err
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Genres = append(byID[id].Genres, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return m, nil
+	credits, err := s.db.Query(`
+		SELECT movie_id, role, name, character_name FROM movie_credits
+		WHERE movie_id = ANY($1) ORDER BY movie_id, role, position`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer credits.Close()
+	for credits.Next() {
+		var id int
+		var role, name, character string
+		if err := credits.Scan(&id, &role, &name, &character); err != nil {
+			return err
+		}
+		if role == "director" {
+			byID[id].Directors = append(byID[id].Directors, name)
+		} else {
+			byID[id].Cast = append(byID[id].Cast, models.CastMember{Name: name, Character: character})
+		}
+	}
+	return credits.Err()
 }
 
-func (s *MovieStore) GetAll() ([]models.Movie, error) {
-	query := `SELECT id, title, genre, duration, price, rating FROM movies ORDER BY id ASC`
-	rows, err := s.db.Query(query)
+// queryMovies runs a query selecting movieColumns and loads the relations.
+func (s *MovieStore) queryMovies(query string, args ...any) ([]models.Movie, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,24 +259,119 @@ func (s *MovieStore) GetAll() ([]models.Movie, error) {
 
 	var movies []models.Movie
 	for rows.Next() {
-		var m models.Movie
-		if err := rows.Scan(&m.ID, &m.Title, &m.Genre, &m.Duration, &m.Price, &m.Rating); err != nil {
+		m, err := scanMovie(rows)
+		if err != nil {
 			return nil, err
 		}
 		movies = append(movies, m)
 	}
-	return movies, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, s.loadMovieRelations(movies)
 }
 
-func (s *MovieStore) Get(id int) (models.Movie, bool) {
-	var m models.Movie
-	query := `SELECT id, title, genre, duration, price, rating FROM movies WHERE id = $1`
+func releaseDateArg(d *models.Date) any {
+	if d == nil {
+		return nil
+	}
+	return d.Time
+}
+
+func (s *MovieStore) Create(m models.Movie) (models.Movie, error) {
+	if err := normalizeMovie(&m); err != nil {
+		return models.Movie{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Movie{}, err
+	}
+	defer tx.Rollback()
+
+	// The rating is aggregated from reviews, so a new movie starts unrated.
+	m.Rating, m.RatingCount, m.RatingHistogram = 0, 0, []int{0, 0, 0, 0, 0}
+
+	query := `INSERT INTO movies (title, duration, price, description, release_date, language,
+              subtitles, age_rating, poster_url, trailer_url)
+          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err = tx.QueryRow(query, m.Title, m.Duration, m.Price, m.Description, releaseDateArg(m.ReleaseDate),
+		m.Language, pq.Array(m.Subtitles), m.AgeRating, m.PosterURL, m.TrailerURL).Scan(&m.ID)
+	if err != nil {
+		return models.Movie{}, err
+	}
+	if err := saveMovieRelations(tx, m); err != nil {
+		return models.Movie{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Movie{}, err
+	}
+
+	return m, nil
+}
+
+func (s *MovieStore) GetAll() ([]models.Movie, error) {
+	return s.queryMovies(`SELECT ` + movieColumns + ` FROM movies m ORDER BY m.id ASC`)
+}
+
+// MovieFilter narrows ListMovies; nil fields are not applied.
+type MovieFilter struct {
+	Genre       string
+	MinPrice    *int
+	MaxPrice    *int
+	MinDuration *int
+	MaxDuration *int
+}
 
-	err := s.db.QueryRow(query, id).Scan(&m.ID, &m.Title, &m.Genre, &m.Duration, &m.Price, &m.Rating)
+var movieSortFields = map[string]string{
+	"id":           "m.id",
+	"title":        "m.title",
+	"duration":     "m.duration",
+	"price":        "m.price",
+	"rating":       "m.rating",
+	"rating_count": "m.rating_count",
+	"release_date": "m.release_date",
+}
+
+func (s *MovieStore) ListMovies(f MovieFilter, p PageParams) ([]models.Movie, int, error) {
+	var where whereBuilder
+	if f.Genre != "" {
+		where.add(`EXISTS (SELECT 1 FROM movie_genres mg JOIN genres g ON g.id = mg.genre_id
+			WHERE mg.movie_id = m.id AND lower(g.name) = lower($%d))`, f.Genre)
+	}
+	if f.MinPrice != nil {
+		where.add("m.price >= $%d", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		where.add("m.price <= $%d", *f.MaxPrice)
+	}
+	if f.MinDuration != nil {
+		where.add("m.duration >= $%d", *f.MinDuration)
+	}
+	if f.MaxDuration != nil {
+		where.add("m.duration <= $%d", *f.MaxDuration)
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM movies m`+where.sql(), where.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM movies m%s ORDER BY %s LIMIT %d OFFSET %d`,
+		movieColumns, where.sql(), p.OrderBy, p.Limit, p.Offset)
+	movies, err := s.queryMovies(query, where.args...)
 	if err != nil {
-		return m, false
+		return nil, 0, err
 	}
-	return m, true
+	return movies, total, nil
+}
+
+func (s *MovieStore) Get(id int) (models.Movie, bool) {
+	movies, err := s.queryMovies(`SELECT `+movieColumns+` FROM movies m WHERE m.id = $1`, id)
+	if err != nil || len(movies) == 0 {
+		return models.Movie{}, false
+	}
+	return movies[0], true
 }
 
 func (s *MovieStore) Update(id int, p MoviePatch) (models.Movie, error) {
@@ -101,8 +386,8 @@ func (s *MovieStore) Update(id int, p MoviePatch) (models.Movie, error) {
 			return models.Movie{}, errors.New("title cannot be empty")
 		}
 	}
-	if p.Genre != nil {
-		m.Genre = strings.TrimSpace(*p.Genre)
+	if p.Genres != nil {
+		m.Genres = *p.Genres
 	}
 	if p.Duration != nil {
 		if *p.Duration <= 0 {
@@ -116,18 +401,56 @@ func (s *MovieStore) Update(id int, p MoviePatch) (models.Movie, error) {
 		}
 		m.Price = *p.Price
 	}
-	if p.Rating != nil {
-		if *p.Rating < 0 || *p.Rating > 5 {
-			return models.Movie{}, errors.New("rating must be between 0 and 10")
-		}
-		m.Rating = *p.Rating
+	if p.Description != nil {
+		m.Description = *p.Description
+	}
+	if p.ReleaseDate != nil {
+		m.ReleaseDate = p.ReleaseDate
+	}
+	if p.Language != nil {
+		m.Language = *p.Language
+	}
+	if p.Subtitles != nil {
+		m.Subtitles = *p.Subtitles
+	}
+	if p.AgeRating != nil {
+		m.AgeRating = *p.AgeRating
+	}
+	if p.Directors != nil {
+		m.Directors = *p.Directors
+	}
+	if p.Cast != nil {
+		m.Cast = *p.Cast
+	}
+	if p.PosterURL != nil {
+		m.PosterURL = *p.PosterURL
+	}
+	if p.TrailerURL != nil {
+		m.TrailerURL = *p.TrailerURL
+	}
+	if err := normalizeMovie(&m); err != nil {
+		return models.Movie{}, err
 	}
 
-	query := `UPDATE movies SET title=$1, genre=$2, duration=$3, price=$4, rating=$5 WHERE id=$6`
-	_, err := s.db.Exec(query, m.Title, m.Genre, m.Duration, m.Price, m.Rating, id)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return models.Movie{}, err
 	}
+	defer tx.Rollback()
+
+	query := `UPDATE movies SET title=$1, duration=$2, price=$3, description=$4, release_date=$5,
+              language=$6, subtitles=$7, age_rating=$8, poster_url=$9, trailer_url=$10 WHERE id=$11`
+	_, err = tx.Exec(query, m.Title, m.Duration, m.Price, m.Description, releaseDateArg(m.ReleaseDate),
+		m.Language, pq.Array(m.Subtitles), m.AgeRating, m.PosterURL, m.TrailerURL, id)
+	if err != nil {
+		return models.Movie{}, err
+	}
+	if err := saveMovieRelations(tx, m); err != nil {
+		return models.Movie{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Movie{}, err
+	}
 
 	return m, nil
 }
@@ -142,22 +465,7 @@ func (s *MovieStore) Delete(id int) bool {
 	return rowsAffected > 0
 }
 func (s *MovieStore) GetTopRated() ([]models.Movie, error) {
-	query := `SELECT id, title, genre, duration, price, rating FROM movies ORDER BY rating DESC, title ASC`
-	rows, err := s.db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var movies []models.Movie
-	for rows.Next() {
-		var m models.Movie
-		if err := rows.Scan(&m.ID, &m.Title, &m.Genre, &m.Duration, &m.Price, &m.Rating); err != nil {
-			return nil, err
-		}
-		movies = append(movies, m)
-	}
-	return movies, nil
+	return s.queryMovies(`SELECT ` + movieColumns + ` FROM movies m ORDER BY m.rating DESC, m.rating_count DESC, m.title ASC`)
 }
 
 type GenreStat struct {
@@ -165,52 +473,6 @@ type GenreStat struct {
 	Count int    `json:"count"`
 }
 
-type YearlyStats struct {
-	TotalMinutes int            `json:"total_minutes"`
-	TotalMovies  int            `json:"total_movies"`
-	TopGenres    []GenreStat    `json:"top_genres"`
-	TopMovies    []models.Movie `json:"top_movies"`
-}
-
-func (s *MovieStore) GetYearlyStats() (YearlyStats, error) {
-	var stats YearlyStats
-
-	stats.TopGenres = []GenreStat{}
-
-	queryMain := `
-        SELECT 
-            COALESCE(SUM(m.duration), 0), 
-            COUNT(t.id) 
-        FROM movies m 
-        JOIN tickets t ON m.id = t.session_id`
-
-	err := s.db.QueryRow(queryMain).Scan(&stats.TotalMinutes, &stats.TotalMovies)
-	if err != nil {
-		return stats, err
-	}
-
-	queryGenres := `
-        SELECT m.genre, COUNT(t.id) as sales 
-        FROM movies m 
-        JOIN tickets t ON m.id = t.session_id 
-        GROUP BY m.genre 
-        ORDER BY sales DESC 
-        LIMIT 3`
-
-	rows, err := s.db.Query(queryGenres)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var gs GenreStat
-			if err := rows.Scan(&gs.Genre, &gs.Count); err == nil {
-				stats.TopGenres = append(stats.TopGenres, gs)
-			}
-		}
-	}
-
-	return stats, nil
-}
-
 func (s *MovieStore) GetUserByEmail(email string) (*models.User, string, error) {
 	var u models.User
 	var passwordHash string
@@ -236,11 +498,11 @@ func (s *MovieStore) CreateUser(name, email, password, role string) error {
 
 func (s *MovieStore) GetTicket(ticketID int, userID int) (models.Ticket, error) {
 	var t models.Ticket
-	query := `SELECT id, session_id, seat_id, user_id, price, status 
+	query := `SELECT id, session_id, seat_id, user_id, COALESCE(order_id, 0), price, status, tariff
               FROM tickets WHERE id = $1 AND user_id = $2`
 
 	err := s.db.QueryRow(query, ticketID, userID).Scan(
-		&t.ID, &t.SessionID, &t.SeatID, &t.UserID, &t.Price, &t.Status,
+		&t.ID, &t.SessionID, &t.SeatID, &t.UserID, &t.OrderID, &t.Price, &t.Status, &t.Tariff,
 	)
 
 	if err != nil {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Kinds of notification.
+const (
+	NotifyBooking          = "booking"
+	NotifyReminder         = "reminder"
+	NotifySessionCancelled = "session_cancelled"
+)
+
+// enqueueNotification queues an email about a ticket inside the caller's
+// transaction, so it exists exactly when the change it reports does.
+func enqueueNotification(tx *sql.Tx, kind string, ticketID int) error {
+	_, err := tx.Exec(`INSERT INTO notifications (kind, ticket_id) VALUES ($1, $2)
+		ON CONFLICT (kind, ticket_id) DO NOTHING`, kind, ticketID)
+	return err
+}
+
+// QueueReminders queues a reminder for every booked ticket whose session
+// starts within before. Tickets booked closer to the start get one too.
+func (s *MovieStore) QueueReminders(before time.Duration) (int, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO notifications (kind, ticket_id)
+		SELECT 'reminder', t.id
+		FROM tickets t JOIN sessions s ON s.id = t.session_id
+		WHERE upper(COALESCE(t.status, 'BOOKED')) = 'BOOKED'
+		  AND s.cancelled_at IS NULL
+		  AND s.starts_at > now() AND s.starts_at <= now() + make_interval(secs => $1)
+		ON CONFLICT (kind, ticket_id) DO NOTHING`, before.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// PendingNotification is a claimed queue entry with what its email needs.
+type PendingNotification struct {
+	ID           int
+	Kind         string
+	Attempts     int
+	TicketID     int
+	TicketStatus string
+	Seat         int
+	Price        int
+	Name         string
+	Email        string
+	Session      SessionInfo
+}
+
+// ClaimNotifications takes up to limit due entries and hides them from
+// other workers for lease, so an instance that dies mid-send only delays
+// them.
+func (s *MovieStore) ClaimNotifications(limit int, lease time.Duration) ([]PendingNotification, error) {
+	rows, err := s.db.Query(`
+		UPDATE notifications n SET next_attempt_at = now() + make_interval(secs => $2)
+		WHERE n.id IN (
+			SELECT id FROM notifications
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING n.id, n.kind, n.attempts, n.ticket_id`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	var claimed []PendingNotification
+	for rows.Next() {
+		var pn PendingNotification
+		if err := rows.Scan(&pn.ID, &pn.Kind, &pn.Attempts, &pn.TicketID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed = append(claimed, pn)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// An entry whose ticket or session is gone fails on its own; one that
+	// hit a database error is retried later. Neither holds up the batch.
+	ready := claimed[:0]
+	for _, pn := range claimed {
+		err := s.loadNotification(&pn)
+		if err == nil {
+			ready = append(ready, pn)
+			continue
+		}
+		status, next := "failed", time.Now()
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrSessionNotFound) {
+			log.Printf("[NOTIFY]: giving up on notification %d for ticket %d: %v", pn.ID, pn.TicketID, err)
+		} else {
+			status, next = "pending", next.Add(notificationBackoff(pn.Attempts))
+			log.Printf("[NOTIFY]: loading notification %d for ticket %d failed, retrying at %s: %v",
+				pn.ID, pn.TicketID, next.Format(time.TimeOnly), err)
+		}
+		if err := s.FinishNotification(pn.ID, status, err, next); err != nil {
+			return nil, err
+		}
+	}
+	return ready, nil
+}
+
+// loadNotification fills in the ticket, user and session of a claimed entry.
+func (s *MovieStore) loadNotification(pn *PendingNotification) error {
+	var sessionID int
+	err := s.db.QueryRow(`
+		SELECT upper(COALESCE(t.status, 'BOOKED')), t.seat_id, t.price, t.session_id, u.name, u.email
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE t.id = $1`, pn.TicketID).
+		Scan(&pn.TicketStatus, &pn.Seat, &pn.Price, &sessionID, &pn.Name, &pn.Email)
+	if err != nil {
+		return err
+	}
+	pn.Session, err = s.GetSession(sessionID)
+	return err
+}
+
+// FinishNotification records the outcome of a send: status is sent, failed
+// or skipped, or pending with the time of the next attempt.
+func (s *MovieStore) FinishNotification(id int, status string, sendErr error, next time.Time) error {
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+	_, err := s.db.Exec(`UPDATE notifications
+		SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3,
+		    sent_at = CASE WHEN $1 = 'sent' THEN now() END
+		WHERE id = $4`, status, lastError, next, id)
+	return err
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"Final_1/internal/models"
+)
+
+var (
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrTicketNotFound  = errors.New("ticket not found")
+	ErrTicketNotBooked = errors.New("ticket is not booked")
+)
+
+// paymentMethods are the ways a booking can be paid for; the first is the
+// default.
+var paymentMethods = []string{"card", "cash"}
+
+// OrderLine is one ticket of an order as printed on the receipt.
+type OrderLine struct {
+	TicketID   int       `json:"ticket_id"`
+	SessionID  int       `json:"session_id"`
+	MovieTitle string    `json:"movie_title"`
+	StartsAt   time.Time `json:"starts_at"`
+	HallName   string    `json:"hall_name"`
+	Row        int       `json:"row"`
+	Seat       int       `json:"seat"`
+	Status     string    `json:"status"`
+	Price      int       `json:"price"`
+}
+
+// OrderEvent is the data of an order.paid event.
+type OrderEvent struct {
+	models.Order
+	TicketIDs []int `json:"ticket_ids"`
+}
+
+type OrderDetails struct {
+	models.Order
+	CustomerName  string      `json:"customer_name"`
+	CustomerEmail string      `json:"customer_email"`
+	Lines         []OrderLine `json:"lines"`
+}
+
+// BookTicket creates a paid order holding one ticket and records the
+// ticket.booked and order.paid events. The seat must be free or held by
+// userID; the hold is used up.
+func (s *MovieStore) BookTicket(userID, sessionID, seat, price int, tariff, payment string) (models.Ticket, error) {
+	t := models.Ticket{SessionID: sessionID, SeatID: seat, UserID: userID, Status: "BOOKED", Price: price, Tariff: tariff}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockSeat(tx, sessionID, seat, userID); err != nil {
+		return t, err
+	}
+	if _, err := tx.Exec(`DELETE FROM seat_holds WHERE session_id = $1 AND seat = $2`, sessionID, seat); err != nil {
+		return t, err
+	}
+	order := OrderEvent{Order: models.Order{UserID: userID, Status: "paid", PaymentMethod: payment, Total: price}}
+	err = tx.QueryRow(`INSERT INTO orders (user_id, payment_method, total) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		userID, payment, price).Scan(&order.ID, &order.CreatedAt)
+	if err != nil {
+		return t, err
+	}
+	err = tx.QueryRow(`INSERT INTO tickets (session_id, seat_id, user_id, price, status, order_id, tariff)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		sessionID, seat, userID, price, t.Status, order.ID, tariff).Scan(&t.ID)
+	t.OrderID = order.ID
+	if err != nil {
+		return t, err
+	}
+	if err := recordEvent(tx, EventTicketBooked, t); err != nil {
+		return t, err
+	}
+	order.TicketIDs = []int{t.ID}
+	if err := recordEvent(tx, EventOrderPaid, order); err != nil {
+		return t, err
+	}
+	return t, tx.Commit()
+}
+
+// CancelTicket cancels one of the user's tickets before its session starts.
+// An order whose tickets are all cancelled becomes refunded.
+func (s *MovieStore) CancelTicket(ticketID, userID int) (models.Ticket, error) {
+	var t models.Ticket
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	var startsAt time.Time
+	err = tx.QueryRow(`
+		SELECT t.id, t.session_id, t.seat_id, t.user_id, COALESCE(t.order_id, 0),
+		       upper(COALESCE(t.status, 'BOOKED')), t.price, s.starts_at
+		FROM tickets t JOIN sessions s ON s.id = t.session_id
+		WHERE t.id = $1 AND t.user_id = $2
+		FOR UPDATE OF t`, ticketID, userID).
+		Scan(&t.ID, &t.SessionID, &t.SeatID, &t.UserID, &t.OrderID, &t.Status, &t.Price, &startsAt)
+	if err == sql.ErrNoRows {
+		return t, ErrTicketNotFound
+	}
+	if err != nil {
+		return t, err
+	}
+	if t.Status != "BOOKED" {
+		return t, ErrTicketNotBooked
+	}
+	if !startsAt.After(time.Now()) {
+		return t, ErrSessionStarted
+	}
+
+	t.Status = "CANCELLED"
+	if _, err := tx.Exec(`UPDATE tickets SET status = 'CANCELLED', cancelled_at = now() WHERE id = $1`, t.ID); err != nil {
+		return t, err
+	}
+	if err := refundEmptyOrders(tx, t.OrderID); err != nil {
+		return t, err
+	}
+	if err := recordEvent(tx, EventTicketCancelled, t); err != nil {
+		return t, err
+	}
+	return t, tx.Commit()
+}
+
+// refundEmptyOrders marks the given orders refunded once none of their
+// tickets is still booked or used.
+func refundEmptyOrders(tx *sql.Tx, orderIDs ...int) error {
+	_, err := tx.Exec(`UPDATE orders o SET status = 'refunded'
+		WHERE o.id = ANY($1) AND o.status = 'paid'
+		  AND NOT EXISTS (SELECT 1 FROM tickets t
+		                  WHERE t.order_id = o.id AND upper(COALESCE(t.status, 'BOOKED')) <> 'CANCELLED')`,
+		pq.Array(orderIDs))
+	return err
+}
+
+func (s *MovieStore) GetOrder(id int) (OrderDetails, error) {
+	var o OrderDetails
+	err := s.db.QueryRow(`
+		SELECT o.id, o.user_id, o.status, o.payment_method, o.total, o.created_at, u.name, u.email
+		FROM orders o JOIN users u ON u.id = o.user_id
+		WHERE o.id = $1`, id).
+		Scan(&o.ID, &o.UserID, &o.Status, &o.PaymentMethod, &o.Total, &o.CreatedAt, &o.CustomerName, &o.CustomerEmail)
+	if err == sql.ErrNoRows {
+		return o, ErrOrderNotFound
+	}
+	if err != nil {
+		return o, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT t.id, s.id, m.title, s.starts_at, h.name, h.seats_per_row, t.seat_id,
+		       upper(COALESCE(t.status, 'BOOKED')), t.price
+		FROM tickets t
+		JOIN sessions s ON s.id = t.session_id
+		JOIN movies m ON m.id = s.movie_id
+		JOIN halls h ON h.id = s.hall_id
+		WHERE t.order_id = $1
+		ORDER BY t.id`, id)
+	if err != nil {
+		return o, err
+	}
+	defer rows.Close()
+	o.Lines = []OrderLine{}
+	for rows.Next() {
+		var l OrderLine
+		var perRow, seat int
+		if err := rows.Scan(&l.TicketID, &l.SessionID, &l.MovieTitle, &l.StartsAt, &l.HallName, &perRow, &seat,
+			&l.Status, &l.Price); err != nil {
+			return o, err
+		}
+		l.Row, l.Seat = seatPosition(seat, perRow)
+		o.Lines = append(o.Lines, l)
+	}
+	return o, rows.Err()
+}
+
+// OrderFilter narrows the order and refund exports; zero fields are not
+// applied. From and To bound the order time, or the refund time for refunds.
+type OrderFilter struct {
+	UserID        int
+	Status        string
+	PaymentMethod string
+	From          *time.Time
+	To            *time.Time
+}
+
+// OrderRow is one line of the orders export.
+type OrderRow struct {
+	models.Order
+	Tickets  int `json:"tickets"`
+	Refunded int `json:"refunded"`
+}
+
+// EachOrder calls fn for every order matching f, oldest first.
+func (s *MovieStore) EachOrder(f OrderFilter, fn func(OrderRow) error) error {
+	var where whereBuilder
+	if f.UserID != 0 {
+		where.add("o.user_id = $%d", f.UserID)
+	}
+	if f.Status != "" {
+		where.add("o.status = lower($%d)", f.Status)
+	}
+	if f.PaymentMethod != "" {
+		where.add("o.payment_method = lower($%d)", f.PaymentMethod)
+	}
+	if f.From != nil {
+		where.add("o.created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		where.add("o.created_at < $%d", *f.To)
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT o.id, o.user_id, o.status, o.payment_method, o.total, o.created_at,
+		       (SELECT COUNT(*) FROM tickets t WHERE t.order_id = o.id),
+		       (SELECT COALESCE(SUM(t.price), 0) FROM tickets t
+		        WHERE t.order_id = o.id AND upper(t.status) = 'CANCELLED')
+		FROM orders o%s
+		ORDER BY o.id`, where.sql()), where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o OrderRow
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.PaymentMethod, &o.Total, &o.CreatedAt,
+			&o.Tickets, &o.Refunded); err != nil {
+			return err
+		}
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// RefundRow is one cancelled ticket of a paid order: the money that went
+// back to the customer.
+type RefundRow struct {
+	TicketID      int        `json:"ticket_id"`
+	OrderID       int        `json:"order_id"`
+	UserID        int        `json:"user_id"`
+	SessionID     int        `json:"session_id"`
+	MovieTitle    string     `json:"movie_title"`
+	Amount        int        `json:"amount"`
+	PaymentMethod string     `json:"payment_method"`
+	Reason        string     `json:"reason"` // customer or session_cancelled
+	RefundedAt    *time.Time `json:"refunded_at"`
+}
+
+// EachRefund calls fn for every refunded ticket matching f, oldest first.
+// f.Status is ignored. Tickets cancelled before cancelled_at was recorded
+// fall back to the session's cancellation time, or have none.
+func (s *MovieStore) EachRefund(f OrderFilter, fn func(RefundRow) error) error {
+	const refundedAt = `COALESCE(t.cancelled_at, s.cancelled_at)`
+	var where whereBuilder
+	where.add("upper(t.status) = $%d", "CANCELLED")
+	if f.UserID != 0 {
+		where.add("t.user_id = $%d", f.UserID)
+	}
+	if f.PaymentMethod != "" {
+		where.add("o.payment_method = lower($%d)", f.PaymentMethod)
+	}
+	if f.From != nil {
+		where.add(refundedAt+" >= $%d", *f.From)
+	}
+	if f.To != nil {
+		where.add(refundedAt+" < $%d", *f.To)
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT t.id, o.id, t.user_id, s.id, m.title, t.price, o.payment_method,
+		       CASE WHEN s.cancelled_at IS NOT NULL AND (t.cancelled_at IS NULL OR t.cancelled_at >= s.cancelled_at)
+		            THEN 'session_cancelled' ELSE 'customer' END,
+		       %s
+		FROM tickets t
+		JOIN orders o ON o.id = t.order_id
+		JOIN sessions s ON s.id = t.session_id
+		JOIN movies m ON m.id = s.movie_id%s
+		ORDER BY %s NULLS FIRST, t.id`, refundedAt, where.sql(), refundedAt), where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r RefundRow
+		if err := rows.Scan(&r.TicketID, &r.OrderID, &r.UserID, &r.SessionID, &r.MovieTitle, &r.Amount,
+			&r.PaymentMethod, &r.Reason, &r.RefundedAt); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
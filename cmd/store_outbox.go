@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Domain event types written to the outbox.
+const (
+	EventTicketBooked     = "ticket.booked"
+	EventTicketCancelled  = "ticket.cancelled"
+	EventOrderPaid        = "order.paid"
+	EventSessionCancelled = "session.cancelled"
+)
+
+// SessionCancelledEvent is the data of a session.cancelled event.
+type SessionCancelledEvent struct {
+	SessionID        int    `json:"session_id"`
+	Reason           string `json:"reason"`
+	CancelledTickets []int  `json:"cancelled_tickets"`
+}
+
+// OutboxEvent is a domain event as stored in the outbox.
+type OutboxEvent struct {
+	ID        int64
+	EventID   string // public id, stable across redeliveries
+	Type      string
+	Data      json.RawMessage
+	CreatedAt time.Time
+	Attempts  int
+}
+
+func newEventID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// recordEvent writes a domain event to the outbox inside the caller's
+// transaction, so the event exists exactly when the change it describes
+// does. The dispatcher hands it to the registered handlers later.
+func recordEvent(tx *sql.Tx, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (event_id, event_type, data) VALUES ($1, $2, $3)`,
+		newEventID(), eventType, payload)
+	return err
+}
+
+// ClaimOutbox takes up to limit undispatched events that are due and hides
+// them from other dispatchers for lease.
+func (s *MovieStore) ClaimOutbox(limit int, lease time.Duration) ([]OutboxEvent, error) {
+	rows, err := s.db.Query(`
+		UPDATE outbox o SET next_attempt_at = now() + make_interval(secs => $2)
+		WHERE o.id IN (
+			SELECT id FROM outbox
+			WHERE dispatched_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING o.id, o.event_id, o.event_type, o.data, o.created_at, o.attempts`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []OutboxEvent
+	for rows.Next() {
+		var ev OutboxEvent
+		var data []byte
+		if err := rows.Scan(&ev.ID, &ev.EventID, &ev.Type, &data, &ev.CreatedAt, &ev.Attempts); err != nil {
+			return nil, err
+		}
+		ev.Data = data
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// OutboxHandled returns the names of the handlers that already processed
+// an event.
+func (s *MovieStore) OutboxHandled(eventID int64) (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT handler FROM outbox_handled WHERE outbox_id = $1`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		done[name] = true
+	}
+	return done, rows.Err()
+}
+
+// HandleOutboxEvent runs one handler in a transaction that also records it
+// as done. A handler that only writes through tx therefore takes effect
+// exactly once; one with outside effects may see an event again if the
+// commit fails.
+func (s *MovieStore) HandleOutboxEvent(ev OutboxEvent, name string, h OutboxHandler) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := h(tx, ev); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO outbox_handled (outbox_id, handler) VALUES ($1, $2)`, ev.ID, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishOutboxEvent marks an event dispatched, or, when handleErr is set,
+// schedules the next attempt at next.
+func (s *MovieStore) FinishOutboxEvent(id int64, handleErr error, next time.Time) error {
+	if handleErr == nil {
+		_, err := s.db.Exec(`UPDATE outbox SET dispatched_at = now(), attempts = attempts + 1, last_error = ''
+			WHERE id = $1`, id)
+		return err
+	}
+	_, err := s.db.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2
+		WHERE id = $3`, handleErr.Error(), next, id)
+	return err
+}
+
+// PruneOutbox deletes events dispatched more than keep ago.
+func (s *MovieStore) PruneOutbox(keep time.Duration) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM outbox WHERE dispatched_at < now() - make_interval(secs => $1)`, keep.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
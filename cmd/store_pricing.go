@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+)
+
+var ErrRuleNotFound = errors.New("pricing rule not found")
+
+const ruleColumns = `id, name, priority, group_name, adjust, value, tariffs, weekdays, formats, categories,
+	starts_after, starts_before, valid_from, valid_until, active`
+
+func scanRule(row rowScanner) (pricing.Rule, error) {
+	var r pricing.Rule
+	var from, until sql.NullTime
+	err := row.Scan(&r.ID, &r.Name, &r.Priority, &r.Group, &r.Adjust, &r.Value, pq.Array(&r.Tariffs),
+		pq.Array(&r.Weekdays), pq.Array(&r.Formats), pq.Array(&r.Categories), &r.StartsAfter, &r.StartsBefore,
+		&from, &until, &r.Active)
+	if from.Valid {
+		r.ValidFrom = &models.Date{Time: from.Time}
+	}
+	if until.Valid {
+		r.ValidUntil = &models.Date{Time: until.Time}
+	}
+	return r, err
+}
+
+// ruleArgs are the columns of ruleColumns after id, in order.
+func ruleArgs(r pricing.Rule) []any {
+	var from, until any
+	if r.ValidFrom != nil {
+		from = r.ValidFrom.Format(time.DateOnly)
+	}
+	if r.ValidUntil != nil {
+		until = r.ValidUntil.Format(time.DateOnly)
+	}
+	return []any{r.Name, r.Priority, r.Group, r.Adjust, r.Value, pq.Array(nonNil(r.Tariffs)),
+		pq.Array(nonNil(r.Weekdays)), pq.Array(nonNil(r.Formats)), pq.Array(nonNil(r.Categories)),
+		r.StartsAfter, r.StartsBefore, from, until, r.Active}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (s *MovieStore) ListPricingRules() ([]pricing.Rule, error) {
+	rows, err := s.db.Query(`SELECT ` + ruleColumns + ` FROM pricing_rules ORDER BY priority DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []pricing.Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *MovieStore) GetPricingRule(id int) (pricing.Rule, error) {
+	r, err := scanRule(s.db.QueryRow(`SELECT `+ruleColumns+` FROM pricing_rules WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return r, ErrRuleNotFound
+	}
+	return r, err
+}
+
+func (s *MovieStore) CreatePricingRule(r pricing.Rule) (pricing.Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	err := s.db.QueryRow(`INSERT INTO pricing_rules (name, priority, group_name, adjust, value, tariffs, weekdays,
+		formats, categories, starts_after, starts_before, valid_from, valid_until, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`, ruleArgs(r)...).Scan(&r.ID)
+	return r, err
+}
+
+func (s *MovieStore) UpdatePricingRule(r pricing.Rule) (pricing.Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	res, err := s.db.Exec(`UPDATE pricing_rules SET name = $1, priority = $2, group_name = $3, adjust = $4, value = $5,
+		tariffs = $6, weekdays = $7, formats = $8, categories = $9, starts_after = $10, starts_before = $11,
+		valid_from = $12, valid_until = $13, active = $14
+		WHERE id = $15`, append(ruleArgs(r), r.ID)...)
+	return r, affectedOne(res, err, ErrRuleNotFound)
+}
+
+func (s *MovieStore) DeletePricingRule(id int) error {
+	res, err := s.db.Exec(`DELETE FROM pricing_rules WHERE id = $1`, id)
+	return affectedOne(res, err, ErrRuleNotFound)
+}
+
+// SeatQuote is the price of one seat for one tariff with its derivation.
+type SeatQuote struct {
+	SessionID int    `json:"session_id"`
+	Seat      int    `json:"seat,omitempty"`
+	Category  string `json:"category"`
+	Tariff    string `json:"tariff"`
+	Format    string `json:"format"`
+	pricing.Quote
+}
+
+// quote prices a seat category: the session's base price, then the
+// category adjustment, then the pricing rules.
+func quote(si SessionInfo, category, tariff string, prices map[string]models.CategoryPrice, rules []pricing.Rule) SeatQuote {
+	base := int(si.Price)
+	q := pricing.Quote{Base: base, Price: categoryPrice(si.Price, prices[category])}
+	if p := prices[category]; p.Multiplier != 1 || p.Surcharge != 0 {
+		adj := fmt.Sprintf("x%g", p.Multiplier)
+		if p.Surcharge != 0 {
+			adj += fmt.Sprintf(" %+d", p.Surcharge)
+		}
+		q.Steps = []pricing.Step{{Name: category + " seat", Adjustment: adj, Before: base, After: q.Price}}
+	}
+	ctx := pricing.Context{StartsAt: si.Time.In(cinemaTZ), Tariff: tariff, Format: si.Format, Category: category}
+	return SeatQuote{SessionID: si.ID, Category: category, Tariff: tariff, Format: si.Format,
+		Quote: pricing.Apply(q, ctx, rules)}
+}
+
+// QuoteSeat prices one seat of a session for a tariff.
+func (s *MovieStore) QuoteSeat(si SessionInfo, seat int, tariff string) (SeatQuote, error) {
+	category := models.SeatStandard
+	err := s.db.QueryRow(`SELECT category FROM hall_seats WHERE hall_id = $1 AND seat = $2`, si.HallID, seat).Scan(&category)
+	if err != nil && err != sql.ErrNoRows {
+		return SeatQuote{}, err
+	}
+	prices, err := s.CategoryPrices(si.ID)
+	if err != nil {
+		return SeatQuote{}, err
+	}
+	rules, err := s.ListPricingRules()
+	if err != nil {
+		return SeatQuote{}, err
+	}
+	q := quote(si, category, tariff, prices, rules)
+	q.Seat = seat
+	return q, nil
+}
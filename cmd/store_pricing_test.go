@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+)
+
+func TestQuoteUsesCinemaTimeZone(t *testing.T) {
+	almaty, err := time.LoadLocation("Asia/Almaty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(loc *time.Location) { cinemaTZ = loc }(cinemaTZ)
+
+	// 23:30 on Friday in UTC is Saturday morning in Almaty.
+	si := SessionInfo{Session: models.Session{ID: 1, Time: time.Date(2026, 3, 13, 23, 30, 0, 0, time.UTC), Price: 2000, Format: "2D"}}
+	rules := []pricing.Rule{
+		{ID: 1, Name: "weekend", Adjust: pricing.AdjustPercent, Value: 20, Weekdays: []string{"sat", "sun"}, Active: true},
+		{ID: 2, Name: "morning", Adjust: pricing.AdjustPercent, Value: -50, StartsBefore: "12:00", Active: true},
+	}
+	prices := map[string]models.CategoryPrice{models.SeatStandard: {Multiplier: 1}}
+
+	tests := []struct {
+		zone  *time.Location
+		price int
+	}{
+		{time.UTC, 2000},
+		{almaty, 1200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone.String(), func(t *testing.T) {
+			cinemaTZ = tt.zone
+			q := quote(si, models.SeatStandard, "adult", prices, rules)
+			if q.Price != tt.price {
+				t.Errorf("price = %d, want %d (steps %+v, skipped %+v)", q.Price, tt.price, q.Steps, q.Skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"Final_1/internal/models"
+	"github.com/lib/pq"
+)
+
+// nowShowing selects the movies that can be recommended (alias m): those
+// with a session in the coming two weeks.
+const nowShowing = `EXISTS (SELECT 1 FROM sessions s
+	WHERE s.movie_id = m.id AND s.starts_at BETWEEN now() AND now() + interval '14 days')`
+
+// Weights of the three signals. They are applied to scores normalized to
+// 0..1, so they read as percentages.
+const (
+	weightCoAttendance = 0.5
+	weightGenre        = 0.3
+	weightPopularity   = 0.2
+)
+
+type Recommendation struct {
+	Movie  models.Movie `json:"movie"`
+	Score  float64      `json:"score"`
+	Reason string       `json:"reason"`
+}
+
+// userTaste is what we know about one user from the database.
+type userTaste struct {
+	seen   map[int]bool
+	genres map[string]float64 // affinity, may be negative for disliked genres
+}
+
+func (s *MovieStore) loadUserTaste(userID int) (userTaste, error) {
+	taste := userTaste{seen: map[int]bool{}, genres: map[string]float64{}}
+
+	// Every ticket counts once for each genre of its movie.
+	rows, err := s.db.Query(`
+		SELECT s.movie_id, g.name, COUNT(*)
+		FROM tickets t
+		JOIN sessions s ON s.id = t.session_id
+		LEFT JOIN movie_genres mg ON mg.movie_id = s.movie_id
+		LEFT JOIN genres g ON g.id = mg.genre_id
+		WHERE t.user_id = $1 AND upper(t.status) <> 'CANCELLED'
+		GROUP BY s.movie_id, g.name`, userID)
+	if err != nil {
+		return taste, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var movieID, n int
+		var genre *string
+		if err := rows.Scan(&movieID, &genre, &n); err != nil {
+			return taste, err
+		}
+		taste.seen[movieID] = true
+		if genre != nil {
+			taste.genres[*genre] += float64(n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return taste, err
+	}
+
+	// Ratings move the genre affinity: 5 stars adds 2, 1 star removes 2.
+	reviews, err := s.db.Query(`
+		SELECT r.movie_id, r.rating, g.name
+		FROM reviews r
+		JOIN movie_genres mg ON mg.movie_id = r.movie_id
+		JOIN genres g ON g.id = mg.genre_id
+		WHERE r.user_id = $1`, userID)
+	if err != nil {
+		return taste, err
+	}
+	defer reviews.Close()
+	for reviews.Next() {
+		var movieID, rating int
+		var genre string
+		if err := reviews.Scan(&movieID, &rating, &genre); err != nil {
+			return taste, err
+		}
+		taste.seen[movieID] = true
+		taste.genres[genre] += float64(rating - 3)
+	}
+	return taste, reviews.Err()
+}
+
+// coAttendance scores every movie by how often its viewers also watched the
+// user's movies (item-to-item cosine similarity over attendees).
+func (s *MovieStore) coAttendance(userID int) (map[int]float64, map[int]int, error) {
+	rows, err := s.db.Query(`
+		WITH attendance AS (
+			SELECT DISTINCT s.movie_id, t.user_id FROM tickets t
+			JOIN sessions s ON s.id = t.session_id
+			WHERE upper(t.status) <> 'CANCELLED'
+		),
+		viewers AS (
+			SELECT movie_id, COUNT(*) AS n FROM attendance GROUP BY movie_id
+		),
+		mine AS (
+			SELECT movie_id FROM attendance WHERE user_id = $1
+		),
+		pairs AS (
+			SELECT a2.movie_id AS candidate, a1.movie_id AS seen, COUNT(*) AS together
+			FROM attendance a1
+			JOIN attendance a2 ON a2.user_id = a1.user_id AND a2.movie_id <> a1.movie_id
+			WHERE a1.movie_id IN (SELECT movie_id FROM mine) AND a1.user_id <> $1
+			GROUP BY a2.movie_id, a1.movie_id
+		)
+		SELECT p.candidate, p.seen, p.together / sqrt(vs.n * vc.n)
+		FROM pairs p
+		JOIN viewers vs ON vs.movie_id = p.seen
+		JOIN viewers vc ON vc.movie_id = p.candidate`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	scores := map[int]float64{}
+	because := map[int]int{} // candidate -> the seen movie that contributed most
+	best := map[int]float64{}
+	for rows.Next() {
+		var candidate, seen int
+		var sim float64
+		if err := rows.Scan(&candidate, &seen, &sim); err != nil {
+			return nil, nil, err
+		}
+		scores[candidate] += sim
+		if sim > best[candidate] {
+			best[candidate] = sim
+			because[candidate] = seen
+		}
+	}
+	return scores, because, rows.Err()
+}
+
+// popularity blends recent ticket sales with the review average, shrunk
+// towards the global mean so that one 5-star review does not win.
+func (s *MovieStore) popularity() (map[int]float64, error) {
+	rows, err := s.db.Query(`
+		WITH recent AS (
+			SELECT s.movie_id, COUNT(*) AS sold FROM tickets t
+			JOIN sessions s ON s.id = t.session_id
+			WHERE t.created_at > now() - interval '30 days' AND upper(t.status) <> 'CANCELLED'
+			GROUP BY s.movie_id
+		),
+		global AS (
+			SELECT COALESCE(SUM(rating_sum)::float8 / NULLIF(SUM(rating_count), 0), 3) AS mean FROM movies
+		)
+		SELECT m.id, COALESCE(r.sold, 0),
+		       (m.rating_sum + 5 * g.mean) / (m.rating_count + 5)
+		FROM movies m CROSS JOIN global g LEFT JOIN recent r ON r.movie_id = m.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sold := map[int]float64{}
+	rating := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var n, avg float64
+		if err := rows.Scan(&id, &n, &avg); err != nil {
+			return nil, err
+		}
+		sold[id] = math.Log1p(n)
+		rating[id] = avg / 5
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	normalize(sold)
+	scores := map[int]float64{}
+	for id := range sold {
+		scores[id] = 0.7*sold[id] + 0.3*rating[id]
+	}
+	return scores, nil
+}
+
+// normalize scales the values in place to 0..1 by the maximum.
+func normalize(m map[int]float64) {
+	top := 0.0
+	for _, v := range m {
+		top = math.Max(top, v)
+	}
+	if top == 0 {
+		return
+	}
+	for k, v := range m {
+		m[k] = v / top
+	}
+}
+
+// Recommend ranks the movies now showing for userID. Everything is computed
+// from the database on request; users without history get the popular list.
+func (s *MovieStore) Recommend(userID, limit int) ([]Recommendation, error) {
+	taste, err := s.loadUserTaste(userID)
+	if err != nil {
+		return nil, err
+	}
+	co, because, err := s.coAttendance(userID)
+	if err != nil {
+		return nil, err
+	}
+	pop, err := s.popularity()
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := s.queryMovies(`SELECT ` + movieColumns + ` FROM movies m WHERE ` + nowShowing)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := map[int]string{}
+	if len(because) > 0 {
+		ids := make([]int64, 0, len(because))
+		for _, seen := range because {
+			ids = append(ids, int64(seen))
+		}
+		rows, err := s.db.Query(`SELECT id, title FROM movies WHERE id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var title string
+			if err := rows.Scan(&id, &title); err == nil {
+				titles[id] = title
+			}
+		}
+		rows.Close()
+	}
+
+	genreScore := map[int]float64{}
+	topGenre := map[int]string{}
+	for _, m := range candidates {
+		best := 0.0
+		for _, g := range m.Genres {
+			if a := taste.genres[g]; a > 0 {
+				genreScore[m.ID] += a
+				if a > best {
+					best, topGenre[m.ID] = a, g
+				}
+			}
+		}
+	}
+	normalize(co)
+	normalize(genreScore)
+
+	coldStart := len(taste.seen) == 0
+	var recs []Recommendation
+	for _, m := range candidates {
+		if taste.seen[m.ID] {
+			continue
+		}
+
+		rec := Recommendation{Movie: m}
+		if coldStart {
+			rec.Score = pop[m.ID]
+			rec.Reason = "Popular right now"
+		} else {
+			rec.Score = weightCoAttendance*co[m.ID] + weightGenre*genreScore[m.ID] + weightPopularity*pop[m.ID]
+			switch {
+			case co[m.ID] > 0 && titles[because[m.ID]] != "":
+				rec.Reason = fmt.Sprintf("Viewers of %s also watched this", titles[because[m.ID]])
+			case topGenre[m.ID] != "":
+				rec.Reason = fmt.Sprintf("Because you like %s", topGenre[m.ID])
+			default:
+				rec.Reason = "Popular right now"
+			}
+		}
+		rec.Score = math.Round(rec.Score*1000) / 1000
+		recs = append(recs, rec)
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Score != recs[j].Score {
+			return recs[i].Score > recs[j].Score
+		}
+		return recs[i].Movie.Title < recs[j].Movie.Title
+	})
+	if len(recs) > limit {
+		recs = recs[:limit]
+	}
+	if recs == nil {
+		recs = []Recommendation{}
+	}
+	return recs, nil
+}
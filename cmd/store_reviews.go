@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Final_1/internal/models"
+)
+
+const maxReviewLength = 4000
+
+var (
+	ErrReviewNotFound  = errors.New("review not found")
+	ErrReviewExists    = errors.New("you have already reviewed this movie")
+	ErrNotAttended     = errors.New("only viewers with a used ticket can review this movie")
+	ErrNotReviewAuthor = errors.New("you can only change your own reviews")
+)
+
+// reviewInputError is a problem with what the client sent, as opposed to a
+// database failure.
+type reviewInputError string
+
+func (e reviewInputError) Error() string { return string(e) }
+
+var reviewSortFields = map[string]string{
+	"created_at": "r.created_at",
+	"rating":     "r.rating",
+}
+
+const reviewColumns = `r.id, r.movie_id, r.user_id, u.name, r.rating, r.body, r.status, r.created_at, r.updated_at`
+
+func scanReview(row rowScanner, extra ...any) (models.Review, error) {
+	var rv models.Review
+	dest := []any{&rv.ID, &rv.MovieID, &rv.UserID, &rv.UserName, &rv.Rating, &rv.Body, &rv.Status, &rv.CreatedAt, &rv.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return rv, err
+}
+
+func validateReview(rating int, body string) (string, error) {
+	if rating < 1 || rating > 5 {
+		return "", reviewInputError("rating must be between 1 and 5")
+	}
+	body = strings.TrimSpace(body)
+	if len([]rune(body)) > maxReviewLength {
+		return "", reviewInputError(fmt.Sprintf("review cannot be longer than %d characters", maxReviewLength))
+	}
+	return body, nil
+}
+
+// adjustMovieRating applies one review's contribution to the aggregate on
+// movies. delta is +1 when a star rating is added and -1 when it is removed.
+func adjustMovieRating(tx *sql.Tx, movieID, stars, delta int) error {
+	_, err := tx.Exec(`
+		UPDATE movies SET
+			rating_count    = rating_count + $3,
+			rating_sum      = rating_sum + $2 * $3,
+			rating_hist[$2] = rating_hist[$2] + $3,
+			rating = CASE WHEN rating_count + $3 > 0
+			              THEN (rating_sum + $2 * $3)::float8 / (rating_count + $3)
+			              ELSE 0 END
+		WHERE id = $1`, movieID, stars, delta)
+	return err
+}
+
+// rerateReview moves a review's stars in the movie aggregate from its old
+// state to its new one. Only visible reviews count, so hiding or flagging
+// takes the stars out and approving puts them back. An empty status stands
+// for a review that does not exist (before create, after delete).
+func rerateReview(tx *sql.Tx, movieID, oldStars int, oldStatus string, newStars int, newStatus string) error {
+	if oldStatus == newStatus && oldStars == newStars {
+		return nil
+	}
+	if oldStatus == ReviewVisible {
+		if err := adjustMovieRating(tx, movieID, oldStars, -1); err != nil {
+			return err
+		}
+	}
+	if newStatus == ReviewVisible {
+		return adjustMovieRating(tx, movieID, newStars, +1)
+	}
+	return nil
+}
+
+// CreateReview stores a review. A non-empty flagReason (from the word filter)
+// puts it straight into the moderation queue instead of publishing it.
+func (s *MovieStore) CreateReview(userID, movieID, rating int, body, flagReason string) (models.Review, error) {
+	body, err := validateReview(rating, body)
+	if err != nil {
+		return models.Review{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Review{}, err
+	}
+	defer tx.Rollback()
+
+	var attended bool
+	err = tx.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM tickets t JOIN sessions s ON s.id = t.session_id
+		WHERE t.user_id = $1 AND s.movie_id = $2 AND upper(t.status) = 'USED')`,
+		userID, movieID).Scan(&attended)
+	if err != nil {
+		return models.Review{}, err
+	}
+	if !attended {
+		return models.Review{}, ErrNotAttended
+	}
+
+	status := ReviewVisible
+	if flagReason != "" {
+		status = ReviewFlagged
+	}
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO reviews (movie_id, user_id, rating, body, status) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (movie_id, user_id) DO NOTHING RETURNING id`, movieID, userID, rating, body, status).Scan(&id)
+	if err == sql.ErrNoRows {
+		return models.Review{}, ErrReviewExists
+	}
+	if err != nil {
+		return models.Review{}, err
+	}
+	if flagReason != "" {
+		if err := logModeration(tx, id, nil, "auto_flag", flagReason); err != nil {
+			return models.Review{}, err
+		}
+	}
+	if err := rerateReview(tx, movieID, 0, "", rating, status); err != nil {
+		return models.Review{}, err
+	}
+
+	rv, err := scanReview(tx.QueryRow(`SELECT `+reviewColumns+` FROM reviews r JOIN users u ON u.id = r.user_id
+		WHERE r.id = $1`, id))
+	if err != nil {
+		return models.Review{}, err
+	}
+	return rv, tx.Commit()
+}
+
+// lockReview loads a review for update and checks that userID may change it.
+func lockReview(tx *sql.Tx, reviewID, userID int, isAdmin bool) (models.Review, error) {
+	rv, err := scanReview(tx.QueryRow(`SELECT `+reviewColumns+` FROM reviews r JOIN users u ON u.id = r.user_id
+		WHERE r.id = $1 FOR UPDATE OF r`, reviewID))
+	if err == sql.ErrNoRows {
+		return rv, ErrReviewNotFound
+	}
+	if err != nil {
+		return rv, err
+	}
+	if rv.UserID != userID && !isAdmin {
+		return rv, ErrNotReviewAuthor
+	}
+	return rv, nil
+}
+
+type ReviewPatch struct {
+	Rating *int    `json:"rating"`
+	Body   *string `json:"body"`
+}
+
+func (s *MovieStore) UpdateReview(reviewID, userID int, p ReviewPatch, flagReason string) (models.Review, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Review{}, err
+	}
+	defer tx.Rollback()
+
+	rv, err := lockReview(tx, reviewID, userID, false)
+	if err != nil {
+		return models.Review{}, err
+	}
+
+	oldRating, oldStatus := rv.Rating, rv.Status
+	if p.Rating != nil {
+		rv.Rating = *p.Rating
+	}
+	if p.Body != nil {
+		rv.Body = *p.Body
+	}
+	if rv.Body, err = validateReview(rv.Rating, rv.Body); err != nil {
+		return models.Review{}, err
+	}
+
+	// An edit never un-hides a review; a filtered edit sends it back to the queue.
+	if flagReason != "" && rv.Status == ReviewVisible {
+		rv.Status = ReviewFlagged
+	}
+
+	err = tx.QueryRow(`UPDATE reviews SET rating = $1, body = $2, status = $3, updated_at = now() WHERE id = $4
+		RETURNING updated_at`, rv.Rating, rv.Body, rv.Status, rv.ID).Scan(&rv.UpdatedAt)
+	if err != nil {
+		return models.Review{}, err
+	}
+	if p.Body != nil {
+		if err := logModeration(tx, rv.ID, &userID, "edited", ""); err != nil {
+			return models.Review{}, err
+		}
+	}
+	if flagReason != "" {
+		if err := logModeration(tx, rv.ID, nil, "auto_flag", flagReason); err != nil {
+			return models.Review{}, err
+		}
+	}
+	if err := rerateReview(tx, rv.MovieID, oldRating, oldStatus, rv.Rating, rv.Status); err != nil {
+		return models.Review{}, err
+	}
+	return rv, tx.Commit()
+}
+
+func (s *MovieStore) DeleteReview(reviewID, userID int, isAdmin bool, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rv, err := lockReview(tx, reviewID, userID, isAdmin)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM reviews WHERE id = $1`, rv.ID); err != nil {
+		return err
+	}
+	if err := logModeration(tx, rv.ID, &userID, "deleted", reason); err != nil {
+		return err
+	}
+	if err := rerateReview(tx, rv.MovieID, rv.Rating, rv.Status, 0, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListReviews returns the published reviews of one movie (movieID > 0), or
+// all reviews of one user whatever their moderation status.
+func (s *MovieStore) ListReviews(movieID, userID int, p PageParams) ([]models.Review, int, error) {
+	var where whereBuilder
+	if movieID > 0 {
+		where.add("r.movie_id = $%d", movieID)
+		where.add("r.status = $%d", ReviewVisible)
+	}
+	if userID > 0 {
+		where.add("r.user_id = $%d", userID)
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM reviews r`+where.sql(), where.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM reviews r JOIN users u ON u.id = r.user_id%s ORDER BY %s LIMIT %d OFFSET %d`,
+		reviewColumns, where.sql(), p.OrderBy, p.Limit, p.Offset)
+	rows, err := s.db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var reviews []models.Review
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		reviews = append(reviews, rv)
+	}
+	return reviews, total, rows.Err()
+}
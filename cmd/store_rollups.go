@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxSeriesPoints bounds a time series, e.g. a year by the hour is too much.
+const maxSeriesPoints = 1000
+
+var seriesIntervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// rollupOverlap re-reads tickets written this long before the last refresh,
+// so rows from transactions that were still open then are not missed.
+// Recomputing a bucket twice is harmless.
+const rollupOverlap = 10 * time.Minute
+
+// RefreshRollups brings the rollup tables up to date in one transaction, so
+// readers see either the old or the new numbers. Only what changed since the
+// last refresh is recomputed: hourly sales buckets holding a ticket that was
+// sold or cancelled since then, and sessions that had such a ticket or whose
+// movie, hall, start or capacity changed. The first run builds everything.
+func (s *MovieStore) RefreshRollups() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var since sql.NullTime
+	err = tx.QueryRow(`SELECT refreshed_at FROM rollup_state WHERE name = 'sales' FOR UPDATE`).Scan(&since)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if since.Valid {
+		since.Time = since.Time.Add(-rollupOverlap)
+	}
+
+	stmts := []string{
+		`CREATE TEMP TABLE rollup_tickets ON COMMIT DROP AS
+			SELECT t.session_id, date_trunc('hour', t.created_at) AS bucket FROM tickets t
+			WHERE $1::timestamptz IS NULL OR t.created_at >= $1 OR t.cancelled_at >= $1`,
+		`CREATE TEMP TABLE rollup_sessions ON COMMIT DROP AS
+			SELECT session_id AS id FROM rollup_tickets
+			UNION
+			SELECT s.id FROM sessions s
+			JOIN halls h ON h.id = s.hall_id
+			LEFT JOIN session_rollup r ON r.session_id = s.id
+			WHERE (r.movie_id, r.hall_id, r.starts_at, r.capacity) IS DISTINCT FROM
+			      (s.movie_id, s.hall_id, s.starts_at, h.total_seats)`,
+		// Sessions that moved keep their old movie and hall in sales_rollup,
+		// so all their buckets are redone.
+		`CREATE TEMP TABLE rollup_buckets ON COMMIT DROP AS
+			SELECT session_id, bucket FROM rollup_tickets
+			UNION
+			SELECT r.session_id, r.bucket FROM sales_rollup r WHERE r.session_id IN (SELECT id FROM rollup_sessions)`,
+		`INSERT INTO sales_rollup (bucket, session_id, movie_id, hall_id, tickets, revenue)
+			SELECT b.bucket, s.id, s.movie_id, s.hall_id, COUNT(t.id), COALESCE(SUM(t.price), 0)
+			FROM rollup_buckets b
+			JOIN sessions s ON s.id = b.session_id
+			LEFT JOIN tickets t ON t.session_id = b.session_id AND date_trunc('hour', t.created_at) = b.bucket
+			                   AND upper(t.status) <> 'CANCELLED'
+			GROUP BY b.bucket, s.id
+			ON CONFLICT (session_id, bucket) DO UPDATE SET movie_id = EXCLUDED.movie_id, hall_id = EXCLUDED.hall_id,
+				tickets = EXCLUDED.tickets, revenue = EXCLUDED.revenue`,
+		`DELETE FROM sales_rollup r USING rollup_buckets b
+			WHERE r.session_id = b.session_id AND r.bucket = b.bucket AND r.tickets = 0`,
+		`INSERT INTO session_rollup (session_id, movie_id, hall_id, starts_at, capacity, tickets, revenue)
+			SELECT s.id, s.movie_id, s.hall_id, s.starts_at, h.total_seats, COUNT(t.id), COALESCE(SUM(t.price), 0)
+			FROM rollup_sessions c
+			JOIN sessions s ON s.id = c.id
+			JOIN halls h ON h.id = s.hall_id
+			LEFT JOIN tickets t ON t.session_id = s.id AND upper(t.status) <> 'CANCELLED'
+			GROUP BY s.id, h.total_seats
+			ON CONFLICT (session_id) DO UPDATE SET movie_id = EXCLUDED.movie_id, hall_id = EXCLUDED.hall_id,
+				starts_at = EXCLUDED.starts_at, capacity = EXCLUDED.capacity,
+				tickets = EXCLUDED.tickets, revenue = EXCLUDED.revenue`,
+		`INSERT INTO rollup_state (name, refreshed_at) VALUES ('sales', now())
+			ON CONFLICT (name) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at`,
+	}
+	for i, stmt := range stmts {
+		var args []any
+		if i == 0 {
+			args = []any{since}
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// runRollups refreshes the rollups now and then every interval. Each run also
+// records attendance forecasts for the coming week.
+func runRollups(store *MovieStore, interval time.Duration) {
+	for {
+		start := time.Now()
+		if err := store.RefreshRollups(); err != nil {
+			log.Printf("Rollup refresh failed: %v", err)
+		} else {
+			log.Printf("Rollups refreshed in %v", time.Since(start).Round(time.Millisecond))
+		}
+		// Forecasts read the rollups as they are, so a failed refresh only
+		// makes them a little stale.
+		if err := store.RecordForecasts(defaultForecastDays); err != nil {
+			log.Printf("Forecast failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// SeriesFilter selects what goes into a time series; zero values are not
+// applied.
+type SeriesFilter struct {
+	Interval  string // hour, day or week
+	From      time.Time
+	To        time.Time
+	MovieID   int
+	HallID    int
+	SessionID int
+	Genre     string
+}
+
+func (f SeriesFilter) validate() error {
+	step, ok := seriesIntervals[f.Interval]
+	if !ok {
+		return errors.New("interval must be hour, day or week")
+	}
+	if !f.From.Before(f.To) {
+		return errors.New("from must be before to")
+	}
+	if f.To.Sub(f.From)/step > maxSeriesPoints {
+		return fmt.Errorf("too many %s buckets, narrow the range", f.Interval)
+	}
+	return nil
+}
+
+type SalesPoint struct {
+	Bucket  time.Time `json:"bucket"`
+	Tickets int       `json:"tickets"`
+	Revenue int       `json:"revenue"`
+}
+
+type OccupancyPoint struct {
+	Bucket    time.Time `json:"bucket"`
+	Sessions  int       `json:"sessions"`
+	Tickets   int       `json:"tickets"`
+	Capacity  int       `json:"capacity"`
+	Occupancy float64   `json:"occupancy"` // percent of seats sold
+}
+
+// TimeSeries has one point per bucket, empty buckets included. Sales are
+// bucketed by when tickets were sold, occupancy by when sessions start.
+type TimeSeries struct {
+	Interval    string           `json:"interval"`
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	RefreshedAt *time.Time       `json:"refreshed_at"`
+	Sales       []SalesPoint     `json:"sales"`
+	Occupancy   []OccupancyPoint `json:"occupancy"`
+}
+
+// seriesQuery wraps an aggregate over a rollup table (alias r) in a
+// generate_series so that every bucket of the range shows up.
+func seriesQuery(f SeriesFilter, table, timeCol, aggregates, outer string) (string, []any) {
+	var where whereBuilder
+	where.add("r."+timeCol+" >= $%d", f.From)
+	where.add("r."+timeCol+" < $%d", f.To)
+	if f.MovieID != 0 {
+		where.add("r.movie_id = $%d", f.MovieID)
+	}
+	if f.HallID != 0 {
+		where.add("r.hall_id = $%d", f.HallID)
+	}
+	if f.SessionID != 0 {
+		where.add("r.session_id = $%d", f.SessionID)
+	}
+	if f.Genre != "" {
+		where.add(`EXISTS (SELECT 1 FROM movie_genres mg JOIN genres g ON g.id = mg.genre_id
+			WHERE mg.movie_id = r.movie_id AND lower(g.name) = lower($%d))`, f.Genre)
+	}
+	args := append(where.args, f.Interval)
+	unit := len(args)
+
+	return fmt.Sprintf(`
+		SELECT b.bucket, %[1]s
+		FROM generate_series(date_trunc($%[2]d, $1::timestamptz), $2::timestamptz - interval '1 microsecond',
+		                     ('1 ' || $%[2]d)::interval) AS b(bucket)
+		LEFT JOIN (
+			SELECT date_trunc($%[2]d, r.%[3]s) AS bucket, %[4]s
+			FROM %[5]s r%[6]s
+			GROUP BY 1
+		) x ON x.bucket = b.bucket
+		ORDER BY b.bucket`, outer, unit, timeCol, aggregates, table, where.sql()), args
+}
+
+func (s *MovieStore) TimeSeries(f SeriesFilter) (TimeSeries, error) {
+	ts := TimeSeries{Interval: f.Interval, From: f.From, To: f.To, Sales: []SalesPoint{}, Occupancy: []OccupancyPoint{}}
+	if err := f.validate(); err != nil {
+		return ts, err
+	}
+
+	var refreshed sql.NullTime
+	err := s.db.QueryRow(`SELECT refreshed_at FROM rollup_state WHERE name = 'sales'`).Scan(&refreshed)
+	if err != nil && err != sql.ErrNoRows {
+		return ts, err
+	}
+	if refreshed.Valid {
+		ts.RefreshedAt = &refreshed.Time
+	}
+
+	query, args := seriesQuery(f, "sales_rollup", "bucket",
+		"SUM(r.tickets) AS tickets, SUM(r.revenue) AS revenue",
+		"COALESCE(x.tickets, 0), COALESCE(x.revenue, 0)")
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return ts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p SalesPoint
+		if err := rows.Scan(&p.Bucket, &p.Tickets, &p.Revenue); err != nil {
+			return ts, err
+		}
+		ts.Sales = append(ts.Sales, p)
+	}
+	if err := rows.Err(); err != nil {
+		return ts, err
+	}
+
+	query, args = seriesQuery(f, "session_rollup", "starts_at",
+		"COUNT(*) AS sessions, SUM(r.tickets) AS tickets, SUM(r.capacity) AS capacity",
+		"COALESCE(x.sessions, 0), COALESCE(x.tickets, 0), COALESCE(x.capacity, 0)")
+	occ, err := s.db.Query(query, args...)
+	if err != nil {
+		return ts, err
+	}
+	defer occ.Close()
+	for occ.Next() {
+		var p OccupancyPoint
+		if err := occ.Scan(&p.Bucket, &p.Sessions, &p.Tickets, &p.Capacity); err != nil {
+			return ts, err
+		}
+		p.Occupancy = occupancy(p.Tickets, p.Capacity)
+		ts.Occupancy = append(ts.Occupancy, p)
+	}
+	return ts, occ.Err()
+}
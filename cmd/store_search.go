@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"Final_1/internal/models"
+)
+
+// movieSearchDoc is the text indexed for full-text search. Title ranks
+// highest (A), then genres and people (B), then the description (C). It is
+// stored in movies.search_doc by saveMovieRelations.
+const movieSearchDoc = `
+	setweight(to_tsvector('simple', coalesce(m.title, '')), 'A') ||
+	setweight(to_tsvector('simple', coalesce((
+		SELECT string_agg(g.name, ' ') FROM movie_genres mg JOIN genres g ON g.id = mg.genre_id
+		WHERE mg.movie_id = m.id), '')), 'B') ||
+	setweight(to_tsvector('simple', coalesce((
+		SELECT string_agg(c.name || ' ' || c.character_name, ' ') FROM movie_credits c
+		WHERE c.movie_id = m.id), '')), 'B') ||
+	setweight(to_tsvector('simple', coalesce(m.description, '')), 'C')`
+
+// minWordSimilarity decides how many typos a query may contain and still match
+// a title through trigrams ("intrstellar" still finds "Interstellar").
+const minWordSimilarity = 0.35
+
+// searchTx starts a read transaction with the trigram threshold set, so the
+// queries can use the <% operator, which movies_title_trgm_idx serves;
+// calling word_similarity() directly cannot use the index.
+func (s *MovieStore) searchTx() (*sql.Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		strconv.FormatFloat(minWordSimilarity, 'f', -1, 64))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+type MovieSearchResult struct {
+	models.Movie
+	Score float64 `json:"score"`
+}
+
+type MovieSuggestion struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// SearchMovies combines full-text rank with trigram similarity so that exact
+// words score highest and misspelled ones still match.
+func (s *MovieStore) SearchMovies(q string, limit int) ([]MovieSearchResult, error) {
+	q = strings.TrimSpace(q)
+	query := `
+		SELECT ` + movieColumns + `,
+		       ts_rank(m.search_doc, tsq) * 2 + word_similarity($1, m.title) AS score
+		FROM movies m, websearch_to_tsquery('simple', $1) tsq
+		WHERE m.search_doc @@ tsq
+		   OR $1 <% m.title
+		ORDER BY score DESC, m.title ASC
+		LIMIT $2`
+
+	tx, err := s.searchTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []models.Movie
+	var scores []float64
+	for rows.Next() {
+		var score float64
+		m, err := scanMovie(rows, &score)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, m)
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	tx.Rollback()
+	if err := s.loadMovieRelations(movies); err != nil {
+		return nil, err
+	}
+
+	results := make([]MovieSearchResult, len(movies))
+	for i := range movies {
+		results[i] = MovieSearchResult{Movie: movies[i], Score: scores[i]}
+	}
+	return results, nil
+}
+
+// SuggestTitles is the cheap query behind autocomplete: prefix matches first,
+// then close trigram matches.
+func (s *MovieStore) SuggestTitles(prefix string, limit int) ([]MovieSuggestion, error) {
+	prefix = strings.TrimSpace(prefix)
+	query := `
+		SELECT id, title FROM movies
+		WHERE title ILIKE $1 || '%' OR $2 <% title
+		ORDER BY (title ILIKE $1 || '%') DESC, word_similarity($2, title) DESC, title ASC
+		LIMIT $3`
+
+	tx, err := s.searchTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(query, escapeLike(prefix), prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []MovieSuggestion
+	for rows.Next() {
+		var m MovieSuggestion
+		if err := rows.Scan(&m.ID, &m.Title); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+)
+
+// Seat states as shown on the seat map. Events use "released" for a seat
+// that became free.
+const (
+	SeatFree     = "free"
+	SeatHeld     = "held"
+	SeatBooked   = "booked"
+	SeatReleased = "released"
+)
+
+// maxHoldsPerUser caps how many seats one customer can hold in a session.
+const maxHoldsPerUser = 10
+
+var (
+	ErrInvalidSeat  = errors.New("seat does not exist in this hall")
+	ErrSeatTaken    = errors.New("seat is already booked")
+	ErrSeatHeld     = errors.New("seat is held by another customer")
+	ErrTooManyHolds = errors.New("too many seats held in this session")
+)
+
+type SeatState struct {
+	Seat        int    `json:"seat"`
+	Row         int    `json:"row"`
+	Number      int    `json:"number"`
+	State       string `json:"state"`
+	Category    string `json:"category"`
+	Price       int    `json:"price"` // adult tariff
+	CompanionOf *int   `json:"companion_of,omitempty"`
+}
+
+type SeatMap struct {
+	SessionID   int                             `json:"session_id"`
+	TotalSeats  int                             `json:"total_seats"`
+	SeatsPerRow int                             `json:"seats_per_row"`
+	Prices      map[string]models.CategoryPrice `json:"prices"`
+	Seats       []SeatState                     `json:"seats"`
+}
+
+// SeatMap returns the state of every seat of a session. Expired holds count
+// as free even before the sweeper removes them.
+func (s *MovieStore) SeatMap(sessionID int) (SeatMap, error) {
+	si, err := s.GetSession(sessionID)
+	if err != nil {
+		return SeatMap{}, err
+	}
+	m := SeatMap{SessionID: si.ID, TotalSeats: si.TotalSeats, SeatsPerRow: si.SeatsPerRow}
+	states := make([]string, si.TotalSeats+1)
+
+	rows, err := s.db.Query(`
+		SELECT seat_id, 'booked' FROM tickets
+		WHERE session_id = $1 AND upper(COALESCE(status, 'BOOKED')) <> 'CANCELLED'
+		UNION ALL
+		SELECT seat, 'held' FROM seat_holds WHERE session_id = $1 AND expires_at > now()`, sessionID)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seat int
+		var state string
+		if err := rows.Scan(&seat, &state); err != nil {
+			return m, err
+		}
+		// A booking wins over a hold left behind for the same seat.
+		if seat >= 1 && seat <= si.TotalSeats && states[seat] != SeatBooked {
+			states[seat] = state
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return m, err
+	}
+
+	hallSeats, err := s.HallSeats(si.HallID)
+	if err != nil {
+		return m, err
+	}
+	if m.Prices, err = s.CategoryPrices(si.ID); err != nil {
+		return m, err
+	}
+	rules, err := s.ListPricingRules()
+	if err != nil {
+		return m, err
+	}
+	adultPrice := map[string]int{}
+	for _, c := range models.SeatCategories {
+		adultPrice[c] = quote(si, c, pricing.Tariffs[0], m.Prices, rules).Price
+	}
+	m.Seats = make([]SeatState, 0, si.TotalSeats)
+	for _, hs := range hallSeats {
+		st := SeatState{Seat: hs.ID, Row: hs.Row, Number: hs.Number, State: states[hs.ID],
+			Category: hs.Category, CompanionOf: hs.CompanionOf}
+		if st.State == "" {
+			st.State = SeatFree
+		}
+		st.Price = adultPrice[hs.Category]
+		m.Seats = append(m.Seats, st)
+	}
+	return m, nil
+}
+
+// lockSeat locks the session row, which serialises bookings and holds per
+// session, and checks that userID may take seat, companion rule included.
+func lockSeat(tx *sql.Tx, sessionID, seat, userID int) (startsAt time.Time, err error) {
+	var hallID, totalSeats int
+	var cancelledAt sql.NullTime
+	err = tx.QueryRow(`SELECT s.starts_at, s.cancelled_at, s.hall_id, h.total_seats
+		FROM sessions s JOIN halls h ON h.id = s.hall_id
+		WHERE s.id = $1 FOR UPDATE OF s`, sessionID).Scan(&startsAt, &cancelledAt, &hallID, &totalSeats)
+	if err == sql.ErrNoRows {
+		return startsAt, ErrSessionNotFound
+	}
+	if err != nil {
+		return startsAt, err
+	}
+	if cancelledAt.Valid {
+		return startsAt, ErrSessionCancelled
+	}
+	if seat < 1 || seat > totalSeats {
+		return startsAt, ErrInvalidSeat
+	}
+
+	var booked bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM tickets
+		WHERE session_id = $1 AND seat_id = $2 AND upper(COALESCE(status, 'BOOKED')) <> 'CANCELLED')`,
+		sessionID, seat).Scan(&booked)
+	if err != nil {
+		return startsAt, err
+	}
+	if booked {
+		return startsAt, ErrSeatTaken
+	}
+	var heldByOther bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM seat_holds
+		WHERE session_id = $1 AND seat = $2 AND user_id <> $3 AND expires_at > now())`,
+		sessionID, seat, userID).Scan(&heldByOther)
+	if err != nil {
+		return startsAt, err
+	}
+	if heldByOther {
+		return startsAt, ErrSeatHeld
+	}
+	return startsAt, checkCompanion(tx, hallID, sessionID, seat, userID, startsAt)
+}
+
+// HoldSeat reserves a seat for userID for ttl while they check out. Holding
+// a seat again extends the hold.
+func (s *MovieStore) HoldSeat(sessionID, seat, userID int, ttl time.Duration) (time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	startsAt, err := lockSeat(tx, sessionID, seat, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !startsAt.After(time.Now()) {
+		return time.Time{}, ErrSessionStarted
+	}
+	var held int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM seat_holds
+		WHERE session_id = $1 AND user_id = $2 AND seat <> $3 AND expires_at > now()`,
+		sessionID, userID, seat).Scan(&held)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if held >= maxHoldsPerUser {
+		return time.Time{}, ErrTooManyHolds
+	}
+
+	var expires time.Time
+	err = tx.QueryRow(`INSERT INTO seat_holds (session_id, seat, user_id, expires_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+		ON CONFLICT (session_id, seat) DO UPDATE SET user_id = EXCLUDED.user_id, expires_at = EXCLUDED.expires_at
+		RETURNING expires_at`, sessionID, seat, userID, ttl.Seconds()).Scan(&expires)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expires, tx.Commit()
+}
+
+// ReleaseSeat drops userID's hold on a seat and tells whether there was one.
+func (s *MovieStore) ReleaseSeat(sessionID, seat, userID int) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM seat_holds WHERE session_id = $1 AND seat = $2 AND user_id = $3`,
+		sessionID, seat, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ExpireHolds deletes holds that ran out and returns the freed seats.
+func (s *MovieStore) ExpireHolds() ([]SeatEvent, error) {
+	rows, err := s.db.Query(`DELETE FROM seat_holds WHERE expires_at <= now() RETURNING session_id, seat`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var freed []SeatEvent
+	for rows.Next() {
+		ev := SeatEvent{State: SeatReleased}
+		if err := rows.Scan(&ev.SessionID, &ev.Seat); err != nil {
+			return nil, err
+		}
+		freed = append(freed, ev)
+	}
+	return freed, rows.Err()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/pricing"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionInfo is a session joined with what the booking and reports need.
+type SessionInfo struct {
+	models.Session
+	MovieTitle   string     `json:"movie_title"`
+	Duration     int        `json:"duration"`
+	HallName     string     `json:"hall_name"`
+	TotalSeats   int        `json:"total_seats"`
+	SeatsPerRow  int        `json:"seats_per_row"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+}
+
+const sessionColumns = `s.id, s.movie_id, s.hall_id, s.starts_at, s.price, s.format, m.title, m.duration, h.name, h.total_seats,
+	h.seats_per_row, s.cancelled_at, s.cancel_reason`
+
+const sessionJoins = ` FROM sessions s JOIN movies m ON m.id = s.movie_id JOIN halls h ON h.id = s.hall_id`
+
+func scanSession(row rowScanner) (SessionInfo, error) {
+	var si SessionInfo
+	err := row.Scan(&si.ID, &si.MovieID, &si.HallID, &si.Time, &si.Price, &si.Format,
+		&si.MovieTitle, &si.Duration, &si.HallName, &si.TotalSeats, &si.SeatsPerRow,
+		&si.CancelledAt, &si.CancelReason)
+	return si, err
+}
+
+// seatPosition turns a seat number into its row and place in the row.
+func seatPosition(seat, perRow int) (row, number int) {
+	return (seat-1)/perRow + 1, (seat-1)%perRow + 1
+}
+
+func (s *MovieStore) CreateHall(h models.Hall) (models.Hall, error) {
+	h.Name = strings.TrimSpace(h.Name)
+	if h.Name == "" {
+		return h, errors.New("name is required")
+	}
+	if h.TotalSeats <= 0 {
+		return h, errors.New("total_seats must be > 0")
+	}
+	if h.SeatsPerRow == 0 {
+		h.SeatsPerRow = 10
+	}
+	if h.SeatsPerRow < 0 {
+		return h, errors.New("seats_per_row must be > 0")
+	}
+	err := s.db.QueryRow(`INSERT INTO halls (name, total_seats, seats_per_row) VALUES ($1, $2, $3) RETURNING id`,
+		h.Name, h.TotalSeats, h.SeatsPerRow).Scan(&h.ID)
+	return h, err
+}
+
+func (s *MovieStore) ListHalls() ([]models.Hall, error) {
+	rows, err := s.db.Query(`SELECT id, name, total_seats, seats_per_row FROM halls ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	halls := []models.Hall{}
+	for rows.Next() {
+		var h models.Hall
+		if err := rows.Scan(&h.ID, &h.Name, &h.TotalSeats, &h.SeatsPerRow); err != nil {
+			return nil, err
+		}
+		halls = append(halls, h)
+	}
+	return halls, rows.Err()
+}
+
+// CreateSession schedules a movie in a hall. A zero price takes the movie's
+// price. Overlapping sessions in the same hall are rejected.
+func (s *MovieStore) CreateSession(in models.Session) (SessionInfo, error) {
+	if in.Time.IsZero() {
+		return SessionInfo{}, errors.New("time is required")
+	}
+	if in.Price < 0 {
+		return SessionInfo{}, errors.New("price cannot be negative")
+	}
+	movie, ok := s.Get(in.MovieID)
+	if !ok {
+		return SessionInfo{}, errors.New("movie not found")
+	}
+	if in.Price == 0 {
+		in.Price = float64(movie.Price)
+	}
+	if in.Format == "" {
+		in.Format = pricing.Formats[0]
+	}
+	if !slices.Contains(pricing.Formats, in.Format) {
+		return SessionInfo{}, errors.New("format must be 2D, 3D or IMAX")
+	}
+
+	var overlap bool
+	err := s.db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM sessions s JOIN movies m ON m.id = s.movie_id
+		WHERE s.hall_id = $1 AND s.cancelled_at IS NULL
+		  AND s.starts_at < $2::timestamptz + make_interval(mins => $3)
+		  AND $2::timestamptz < s.starts_at + make_interval(mins => m.duration))`,
+		in.HallID, in.Time, movie.Duration).Scan(&overlap)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	if overlap {
+		return SessionInfo{}, errors.New("hall is busy at that time")
+	}
+
+	var id int
+	err = s.db.QueryRow(`INSERT INTO sessions (movie_id, hall_id, starts_at, price, format) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		in.MovieID, in.HallID, in.Time, int(in.Price), in.Format).Scan(&id)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	return s.GetSession(id)
+}
+
+func (s *MovieStore) GetSession(id int) (SessionInfo, error) {
+	si, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+sessionJoins+` WHERE s.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return si, ErrSessionNotFound
+	}
+	return si, err
+}
+
+// ListSessions returns sessions starting in [from, to), optionally for one movie.
+func (s *MovieStore) ListSessions(movieID int, from, to time.Time) ([]SessionInfo, error) {
+	var where whereBuilder
+	where.add("s.starts_at >= $%d", from)
+	where.add("s.starts_at < $%d", to)
+	if movieID > 0 {
+		where.add("s.movie_id = $%d", movieID)
+	}
+
+	rows, err := s.db.Query(`SELECT `+sessionColumns+sessionJoins+where.sql()+` ORDER BY s.starts_at, s.id`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		si, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, si)
+	}
+	return sessions, rows.Err()
+}
+
+var (
+	ErrSessionCancelled = errors.New("session is cancelled")
+	ErrSessionStarted   = errors.New("session has already started")
+)
+
+// CancelSession cancels a session that has not started and every ticket
+// booked for it, recording a ticket.cancelled event for each ticket and a
+// session.cancelled event. It returns the cancelled ticket ids.
+func (s *MovieStore) CancelSession(id int, reason string) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var startsAt time.Time
+	var cancelledAt sql.NullTime
+	err = tx.QueryRow(`SELECT starts_at, cancelled_at FROM sessions WHERE id = $1 FOR UPDATE`, id).Scan(&startsAt, &cancelledAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cancelledAt.Valid {
+		return nil, ErrSessionCancelled
+	}
+	if !startsAt.After(time.Now()) {
+		return nil, ErrSessionStarted
+	}
+
+	if _, err := tx.Exec(`UPDATE sessions SET cancelled_at = now(), cancel_reason = $1 WHERE id = $2`,
+		strings.TrimSpace(reason), id); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`UPDATE tickets SET status = 'CANCELLED', cancelled_at = now()
+		WHERE session_id = $1 AND upper(COALESCE(status, 'BOOKED')) = 'BOOKED'
+		RETURNING id, session_id, seat_id, user_id, COALESCE(order_id, 0), price`, id)
+	if err != nil {
+		return nil, err
+	}
+	var cancelled []models.Ticket
+	for rows.Next() {
+		t := models.Ticket{Status: "CANCELLED"}
+		if err := rows.Scan(&t.ID, &t.SessionID, &t.SeatID, &t.UserID, &t.OrderID, &t.Price); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cancelled = append(cancelled, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ids, orderIDs := []int{}, []int{}
+	for _, t := range cancelled {
+		if err := recordEvent(tx, EventTicketCancelled, t); err != nil {
+			return nil, err
+		}
+		ids, orderIDs = append(ids, t.ID), append(orderIDs, t.OrderID)
+	}
+	if err := refundEmptyOrders(tx, orderIDs...); err != nil {
+		return nil, err
+	}
+	event := SessionCancelledEvent{SessionID: id, Reason: strings.TrimSpace(reason), CancelledTickets: ids}
+	if err := recordEvent(tx, EventSessionCancelled, event); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
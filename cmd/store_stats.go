@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// soldTickets is every ticket that still counts as sold (alias t), joined
+// with its session (s) and movie (m).
+const soldTickets = ` FROM tickets t
+	JOIN sessions s ON s.id = t.session_id
+	JOIN movies m ON m.id = s.movie_id
+	WHERE upper(t.status) <> 'CANCELLED'`
+
+type PeriodStat struct {
+	Period  string `json:"period"` // "2026" or "2026-03"
+	Tickets int    `json:"tickets"`
+	Minutes int    `json:"minutes"`
+	Spend   int    `json:"spend"`
+}
+
+type UserStats struct {
+	TotalMinutes   int          `json:"total_minutes"`
+	TotalTickets   int          `json:"total_tickets"`
+	TotalSpend     int          `json:"total_spend"`
+	FavoriteGenres []GenreStat  `json:"favorite_genres"`
+	ByYear         []PeriodStat `json:"by_year"`
+	ByMonth        []PeriodStat `json:"by_month"`
+}
+
+func (s *MovieStore) periodStats(userID int, format string) ([]PeriodStat, error) {
+	rows, err := s.db.Query(`
+		SELECT to_char(s.starts_at, '`+format+`') AS period, COUNT(*), COALESCE(SUM(m.duration), 0), COALESCE(SUM(t.price), 0)`+
+		soldTickets+` AND t.user_id = $1
+		GROUP BY period ORDER BY period`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []PeriodStat{}
+	for rows.Next() {
+		var p PeriodStat
+		if err := rows.Scan(&p.Period, &p.Tickets, &p.Minutes, &p.Spend); err != nil {
+			return nil, err
+		}
+		stats = append(stats, p)
+	}
+	return stats, rows.Err()
+}
+
+// UserStats summarizes the tickets of one user.
+func (s *MovieStore) UserStats(userID int) (UserStats, error) {
+	stats := UserStats{FavoriteGenres: []GenreStat{}}
+
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(m.duration), 0), COUNT(t.id), COALESCE(SUM(t.price), 0)`+
+		soldTickets+` AND t.user_id = $1`, userID).
+		Scan(&stats.TotalMinutes, &stats.TotalTickets, &stats.TotalSpend)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT g.name, COUNT(t.id) AS sales
+		FROM tickets t
+		JOIN sessions s ON s.id = t.session_id
+		JOIN movie_genres mg ON mg.movie_id = s.movie_id
+		JOIN genres g ON g.id = mg.genre_id
+		WHERE upper(t.status) <> 'CANCELLED' AND t.user_id = $1
+		GROUP BY g.name ORDER BY sales DESC, g.name LIMIT 3`, userID)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gs GenreStat
+		if err := rows.Scan(&gs.Genre, &gs.Count); err != nil {
+			return stats, err
+		}
+		stats.FavoriteGenres = append(stats.FavoriteGenres, gs)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	if stats.ByYear, err = s.periodStats(userID, "YYYY"); err != nil {
+		return stats, err
+	}
+	if stats.ByMonth, err = s.periodStats(userID, "YYYY-MM"); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+type BoxOfficeRow struct {
+	Key       string  `json:"key"`
+	Revenue   int     `json:"revenue"`
+	Tickets   int     `json:"tickets"`
+	Sessions  int     `json:"sessions"`
+	Capacity  int     `json:"capacity"`
+	Occupancy float64 `json:"occupancy"` // percent of seats sold
+}
+
+type BoxOfficeReport struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Total   BoxOfficeRow   `json:"total"`
+	ByMovie []BoxOfficeRow `json:"by_movie"`
+	ByDay   []BoxOfficeRow `json:"by_day"`
+	ByHall  []BoxOfficeRow `json:"by_hall"`
+	ByGenre []BoxOfficeRow `json:"by_genre"`
+}
+
+// boxOfficeSessions is one row per session in [$1, $2) with its sales.
+const boxOfficeSessions = `
+	WITH per AS (
+		SELECT s.id, s.movie_id, s.hall_id, s.starts_at, h.total_seats,
+		       COUNT(t.id) AS tickets, COALESCE(SUM(t.price), 0) AS revenue
+		FROM sessions s
+		JOIN halls h ON h.id = s.hall_id
+		LEFT JOIN tickets t ON t.session_id = s.id AND upper(t.status) <> 'CANCELLED'
+		WHERE s.starts_at >= $1 AND s.starts_at < $2
+		GROUP BY s.id, h.total_seats
+	)`
+
+func (s *MovieStore) boxOfficeBreakdown(from, to time.Time, key, joins string) ([]BoxOfficeRow, error) {
+	query := fmt.Sprintf(`%s
+		SELECT %s AS key, SUM(per.revenue), SUM(per.tickets), COUNT(*), SUM(per.total_seats)
+		FROM per %s
+		GROUP BY 1 ORDER BY 2 DESC, 1`, boxOfficeSessions, key, joins)
+	rows, err := s.db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []BoxOfficeRow{}
+	for rows.Next() {
+		var r BoxOfficeRow
+		if err := rows.Scan(&r.Key, &r.Revenue, &r.Tickets, &r.Sessions, &r.Capacity); err != nil {
+			return nil, err
+		}
+		r.Occupancy = occupancy(r.Tickets, r.Capacity)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func occupancy(tickets, capacity int) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	return math.Round(float64(tickets)*10000/float64(capacity)) / 100
+}
+
+// BoxOffice reports sessions starting in [from, to). A movie with several
+// genres counts fully in each of them, so ByGenre does not add up to Total.
+func (s *MovieStore) BoxOffice(from, to time.Time) (BoxOfficeReport, error) {
+	rep := BoxOfficeReport{From: from, To: to, Total: BoxOfficeRow{Key: "total"}}
+
+	err := s.db.QueryRow(boxOfficeSessions+`
+		SELECT COALESCE(SUM(revenue), 0), COALESCE(SUM(tickets), 0), COUNT(*), COALESCE(SUM(total_seats), 0) FROM per`,
+		from, to).Scan(&rep.Total.Revenue, &rep.Total.Tickets, &rep.Total.Sessions, &rep.Total.Capacity)
+	if err != nil {
+		return rep, err
+	}
+	rep.Total.Occupancy = occupancy(rep.Total.Tickets, rep.Total.Capacity)
+
+	breakdowns := []struct {
+		dst   *[]BoxOfficeRow
+		key   string
+		joins string
+	}{
+		{&rep.ByMovie, "m.title", "JOIN movies m ON m.id = per.movie_id"},
+		{&rep.ByDay, "to_char(per.starts_at, 'YYYY-MM-DD')", ""},
+		{&rep.ByHall, "h.name", "JOIN halls h ON h.id = per.hall_id"},
+		{&rep.ByGenre, "g.name", "JOIN movie_genres mg ON mg.movie_id = per.movie_id JOIN genres g ON g.id = mg.genre_id"},
+	}
+	for _, b := range breakdowns {
+		if *b.dst, err = s.boxOfficeBreakdown(from, to, b.key, b.joins); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TicketItem is a row of the /tickets list.
+type TicketItem struct {
+	ID        int       `json:"id"`
+	SessionID int       `json:"session_id"`
+	SeatID    int       `json:"seat_id"`
+	UserID    int       `json:"user_id"`
+	Price     int       `json:"price"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TicketFilter narrows ListTickets; zero values are not applied. UserID is
+// forced to the caller for non-admins by the handler.
+type TicketFilter struct {
+	UserID    int
+	SessionID int
+	Status    string
+	MinPrice  *int
+	MaxPrice  *int
+	From      *time.Time
+	To        *time.Time
+}
+
+var ticketSortFields = map[string]string{
+	"id":         "id",
+	"price":      "price",
+	"status":     "status",
+	"session_id": "session_id",
+	"created_at": "created_at",
+}
+
+func ticketWhere(f TicketFilter) whereBuilder {
+	var where whereBuilder
+	if f.UserID != 0 {
+		where.add("user_id = $%d", f.UserID)
+	}
+	if f.SessionID != 0 {
+		where.add("session_id = $%d", f.SessionID)
+	}
+	if f.Status != "" {
+		where.add("upper(status) = upper($%d)", f.Status)
+	}
+	if f.MinPrice != nil {
+		where.add("price >= $%d", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		where.add("price <= $%d", *f.MaxPrice)
+	}
+	if f.From != nil {
+		where.add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		where.add("created_at < $%d", *f.To)
+	}
+	return where
+}
+
+const ticketColumns = `id, session_id, seat_id, user_id, price, COALESCE(status, 'BOOKED'), created_at`
+
+func scanTicketItem(row rowScanner) (TicketItem, error) {
+	var t TicketItem
+	err := row.Scan(&t.ID, &t.SessionID, &t.SeatID, &t.UserID, &t.Price, &t.Status, &t.CreatedAt)
+	return t, err
+}
+
+func (s *MovieStore) ListTickets(f TicketFilter, p PageParams) ([]TicketItem, int, error) {
+	where := ticketWhere(f)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM tickets`+where.sql(), where.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM tickets%s ORDER BY %s LIMIT %d OFFSET %d`,
+		ticketColumns, where.sql(), p.OrderBy, p.Limit, p.Offset)
+	rows, err := s.db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []TicketItem
+	for rows.Next() {
+		t, err := scanTicketItem(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, t)
+	}
+	return items, total, rows.Err()
+}
+
+// EachTicket calls fn for every ticket matching f without paging, so exports
+// can stream any number of rows. orderBy comes from parsePage.
+func (s *MovieStore) EachTicket(f TicketFilter, orderBy string, fn func(TicketItem) error) error {
+	where := ticketWhere(f)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT %s FROM tickets%s ORDER BY %s`, ticketColumns, where.sql(), orderBy),
+		where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTicketItem(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"Final_1/internal/models"
+)
+
+// ErrEmailNotVerified means a new identity would have to be matched to a user
+// by an email address the provider does not vouch for.
+var ErrEmailNotVerified = errors.New("email not verified by the identity provider")
+
+// ExternalIdentity is what a provider tells us about a signed-in user. Role
+// is empty when the provider sent no role or group claim.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	Name          string
+	Role          string
+	EmailVerified bool
+}
+
+// UpsertExternalUser maps an identity from an external provider onto a local
+// user. A known (issuer, subject) pair wins. A new identity is linked to the
+// user with the same email, or a new password-less user is created, but only
+// when the provider says the email is verified; anything else could take
+// over someone else's account. The provider's role replaces the local one
+// only when it sent a role claim, so roles set through PUT /users/{id}/role
+// stick otherwise.
+func (s *MovieStore) UpsertExternalUser(id ExternalIdentity) (*models.User, error) {
+	email := strings.ToLower(strings.TrimSpace(id.Email))
+	if id.Subject == "" {
+		return nil, errors.New("identity has no subject")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`,
+		id.Issuer, id.Subject).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		if email == "" {
+			return nil, errors.New("identity has no email")
+		}
+		if !id.EmailVerified {
+			return nil, ErrEmailNotVerified
+		}
+		err = tx.QueryRow(`SELECT id FROM users WHERE lower(email) = $1`, email).Scan(&userID)
+		if err == sql.ErrNoRows {
+			role := id.Role
+			if role == "" {
+				role = "user"
+			}
+			// An empty password hash never matches bcrypt, so SSO users
+			// cannot sign in through /login.
+			err = tx.QueryRow(`INSERT INTO users (name, email, password, role)
+				VALUES ($1, $2, '', $3) RETURNING id`, id.Name, email, role).Scan(&userID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)`,
+			id.Issuer, id.Subject, userID); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if id.Role != "" {
+		if _, err := tx.Exec(`UPDATE users SET role = $1 WHERE id = $2`, id.Role, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var u models.User
+	err = tx.QueryRow(`SELECT id, name, email, role FROM users WHERE id = $1`, userID).
+		Scan(&u.ID, &u.Name, &u.Email, &u.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &u, tx.Commit()
+}
+
+func (s *MovieStore) SetUserRole(id int, role string) (*models.User, error) {
+	var u models.User
+	err := s.db.QueryRow(`UPDATE users SET role = $1 WHERE id = $2 RETURNING id, name, email, role`, role, id).
+		Scan(&u.ID, &u.Name, &u.Email, &u.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
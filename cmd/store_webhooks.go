@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"Final_1/internal/webhook"
+)
+
+// webhookEvents are the outbox events subscriptions can ask for.
+var webhookEvents = []string{EventTicketBooked, EventTicketCancelled, EventOrderPaid, EventSessionCancelled}
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+type WebhookSubscription struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"` // only returned on creation
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookEvent is the JSON body of every delivery.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type WebhookDelivery struct {
+	ID             int              `json:"id"`
+	SubscriptionID int              `json:"subscription_id"`
+	EventID        string           `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"` // pending, delivered or failed
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+	Log            []WebhookAttempt `json:"log,omitempty"`
+
+	url, secret string
+}
+
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  *int      `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+	Manual      bool      `json:"manual,omitempty"`
+}
+
+func (s *MovieStore) CreateWebhook(sub WebhookSubscription) (WebhookSubscription, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return sub, errors.New("url must be an absolute http(s) URL")
+	}
+	if len(sub.Events) == 0 {
+		return sub, errors.New("events is required")
+	}
+	for _, ev := range sub.Events {
+		if ev != "*" && !slices.Contains(webhookEvents, ev) {
+			return sub, fmt.Errorf("unknown event %q, expected one of %s or *", ev, strings.Join(webhookEvents, ", "))
+		}
+	}
+	sub.Secret, sub.Active = webhook.NewSecret(), true
+	err = s.db.QueryRow(`INSERT INTO webhook_subscriptions (url, secret, events, description)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		sub.URL, sub.Secret, pq.Array(sub.Events), strings.TrimSpace(sub.Description)).Scan(&sub.ID, &sub.CreatedAt)
+	return sub, err
+}
+
+func (s *MovieStore) ListWebhooks() ([]WebhookSubscription, error) {
+	rows, err := s.db.Query(`SELECT id, url, events, description, active, created_at
+		FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subs := []WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.URL, pq.Array(&sub.Events), &sub.Description, &sub.Active, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// SetWebhookActive pauses or resumes a subscription. Paused ones get no new
+// deliveries; pending ones still go out.
+func (s *MovieStore) SetWebhookActive(id int, active bool) error {
+	res, err := s.db.Exec(`UPDATE webhook_subscriptions SET active = $1 WHERE id = $2`, active, id)
+	return affectedOne(res, err, ErrWebhookNotFound)
+}
+
+func (s *MovieStore) DeleteWebhook(id int) error {
+	res, err := s.db.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	return affectedOne(res, err, ErrWebhookNotFound)
+}
+
+func affectedOne(res sql.Result, err error, notFound error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// emitWebhook queues an outbox event for every active subscription to it.
+// The event keeps its id, so handling it twice queues nothing new.
+func emitWebhook(tx *sql.Tx, ev OutboxEvent) error {
+	payload, err := json.Marshal(WebhookEvent{ID: ev.EventID, Type: ev.Type, CreatedAt: ev.CreatedAt.UTC(), Data: ev.Data})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE active AND ($2 = ANY(events) OR '*' = ANY(events))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING`, ev.EventID, ev.Type, payload)
+	return err
+}
+
+const deliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.created_at, d.delivered_at, w.url, w.secret`
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt, &d.url, &d.secret)
+	d.Payload = payload
+	return d, err
+}
+
+// ClaimWebhookDeliveries takes up to limit due deliveries and hides them
+// from other workers for lease.
+func (s *MovieStore) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(`
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= now()
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED)
+			RETURNING *)
+		SELECT `+deliveryColumns+` FROM claimed d JOIN webhook_subscriptions w ON w.id = d.subscription_id
+		ORDER BY d.id`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RecordWebhookAttempt logs one attempt. status and next update the
+// delivery unless the attempt was manual and failed, which leaves its
+// schedule alone.
+func (s *MovieStore) RecordWebhookAttempt(d WebhookDelivery, a WebhookAttempt, status string, next time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms, manual)
+		VALUES ($1, $2, $3, $4, $5, $6)`, d.ID, a.AttemptedAt, a.StatusCode, a.Error, a.DurationMS, a.Manual)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == "delivered":
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, delivered_at = $1
+			WHERE id = $2`, a.AttemptedAt, d.ID)
+	case a.Manual:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET attempts = attempts + 1 WHERE id = $1`, d.ID)
+	default:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = $1, attempts = attempts + 1, next_attempt_at = $2
+			WHERE id = $3`, status, next, d.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWebhookDelivery returns a delivery with its attempt log.
+func (s *MovieStore) GetWebhookDelivery(id int) (WebhookDelivery, error) {
+	d, err := scanDelivery(s.db.QueryRow(`SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d JOIN webhook_subscriptions w ON w.id = d.subscription_id
+		WHERE d.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return d, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return d, err
+	}
+	rows, err := s.db.Query(`SELECT attempted_at, status_code, error, duration_ms, manual
+		FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	d.Log = []WebhookAttempt{}
+	for rows.Next() {
+		var a WebhookAttempt
+		if err := rows.Scan(&a.AttemptedAt, &a.StatusCode, &a.Error, &a.DurationMS, &a.Manual); err != nil {
+			return d, err
+		}
+		d.Log = append(d.Log, a)
+	}
+	return d, rows.Err()
+}
+
+// ListWebhookDeliveries pages through a subscription's deliveries, newest
+// first, optionally with one status.
+func (s *MovieStore) ListWebhookDeliveries(subscriptionID int, status string, p PageParams) ([]WebhookDelivery, int, error) {
+	var where whereBuilder
+	where.add("d.subscription_id = $%d", subscriptionID)
+	if status != "" {
+		where.add("d.status = $%d", status)
+	}
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries d`+where.sql(), where.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT %s
+		FROM webhook_deliveries d JOIN webhook_subscriptions w ON w.id = d.subscription_id%s
+		ORDER BY d.id DESC LIMIT %d OFFSET %d`, deliveryColumns, where.sql(), p.Limit, p.Offset), where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, d)
+	}
+	return out, total, rows.Err()
+}
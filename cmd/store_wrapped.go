@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"Final_1/internal/models"
+	"github.com/lib/pq"
+)
+
+// currentYearTTL is how long the wrapped of the running year is reused even
+// when its tickets did not change, so movie edits show up eventually.
+const currentYearTTL = time.Hour
+
+// YearlyStats is one user's year in review.
+type YearlyStats struct {
+	Year              int            `json:"year"`
+	TotalMinutes      int            `json:"total_minutes"`
+	TotalTickets      int            `json:"total_tickets"`
+	TotalMovies       int            `json:"total_movies"`
+	TopGenres         []GenreStat    `json:"top_genres"`
+	TopMovies         []models.Movie `json:"top_movies"`
+	BusiestMonth      *PeriodStat    `json:"busiest_month"`
+	FavoriteHall      string         `json:"favorite_hall,omitempty"`
+	FavoriteTimeOfDay string         `json:"favorite_time_of_day,omitempty"`
+	ComputedAt        time.Time      `json:"computed_at"`
+}
+
+// timeOfDay buckets a session start into morning, afternoon, evening or night.
+const timeOfDay = `CASE
+		WHEN extract(hour FROM s.starts_at) BETWEEN 5 AND 11 THEN 'morning'
+		WHEN extract(hour FROM s.starts_at) BETWEEN 12 AND 16 THEN 'afternoon'
+		WHEN extract(hour FROM s.starts_at) BETWEEN 17 AND 20 THEN 'evening'
+		ELSE 'night' END`
+
+func yearRange(year int) (time.Time, time.Time) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	return from, from.AddDate(1, 0, 0)
+}
+
+// wrappedVersion fingerprints the tickets a wrapped is computed from. Any
+// booking, cancellation, refund or check-in in that year changes it.
+func (s *MovieStore) wrappedVersion(userID, year int) (string, error) {
+	from, to := yearRange(year)
+	var version string
+	err := s.db.QueryRow(`
+		SELECT md5(COALESCE(string_agg(t.id || ':' || t.status || ':' || t.price || ':' || t.session_id, ',' ORDER BY t.id), ''))
+		FROM tickets t JOIN sessions s ON s.id = t.session_id
+		WHERE t.user_id = $1 AND s.starts_at >= $2 AND s.starts_at < $3`, userID, from, to).Scan(&version)
+	return version, err
+}
+
+// GetYearlyStats returns the wrapped of userID for year, from the cache when
+// its tickets have not changed since it was computed.
+func (s *MovieStore) GetYearlyStats(userID, year int) (YearlyStats, error) {
+	var stats YearlyStats
+	version, err := s.wrappedVersion(userID, year)
+	if err != nil {
+		return stats, err
+	}
+
+	var raw []byte
+	var computedAt time.Time
+	var cached string
+	err = s.db.QueryRow(`SELECT stats, computed_at, source_version FROM wrapped_cache WHERE user_id = $1 AND year = $2`,
+		userID, year).Scan(&raw, &computedAt, &cached)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return stats, err
+	case cached == version && (year < time.Now().Year() || time.Since(computedAt) < currentYearTTL):
+		if err := json.Unmarshal(raw, &stats); err == nil {
+			return stats, nil
+		}
+	}
+
+	stats, err = s.computeYearlyStats(userID, year)
+	if err != nil {
+		return stats, err
+	}
+	raw, err = json.Marshal(stats)
+	if err != nil {
+		return stats, err
+	}
+	_, err = s.db.Exec(`INSERT INTO wrapped_cache (user_id, year, stats, computed_at, source_version) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, year) DO UPDATE
+		SET stats = EXCLUDED.stats, computed_at = EXCLUDED.computed_at, source_version = EXCLUDED.source_version`,
+		userID, year, raw, stats.ComputedAt, version)
+	return stats, err
+}
+
+func (s *MovieStore) computeYearlyStats(userID, year int) (YearlyStats, error) {
+	stats := YearlyStats{Year: year, TopGenres: []GenreStat{}, TopMovies: []models.Movie{}, ComputedAt: time.Now().UTC()}
+	from, to := yearRange(year)
+	const inYear = ` AND t.user_id = $1 AND s.starts_at >= $2 AND s.starts_at < $3`
+
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(m.duration), 0), COUNT(t.id), COUNT(DISTINCT m.id)`+soldTickets+inYear,
+		userID, from, to).Scan(&stats.TotalMinutes, &stats.TotalTickets, &stats.TotalMovies)
+	if err != nil || stats.TotalTickets == 0 {
+		return stats, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT g.name, COUNT(t.id) AS sales
+		FROM tickets t
+		JOIN sessions s ON s.id = t.session_id
+		JOIN movie_genres mg ON mg.movie_id = s.movie_id
+		JOIN genres g ON g.id = mg.genre_id
+		WHERE upper(t.status) <> 'CANCELLED'`+inYear+`
+		GROUP BY g.name ORDER BY sales DESC, g.name LIMIT 3`, userID, from, to)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var gs GenreStat
+		if err := rows.Scan(&gs.Genre, &gs.Count); err != nil {
+			return stats, err
+		}
+		stats.TopGenres = append(stats.TopGenres, gs)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	var ids []int64
+	err = s.db.QueryRow(`
+		SELECT COALESCE(array_agg(id ORDER BY tickets DESC, last_seen DESC), '{}') FROM (
+			SELECT m.id, COUNT(t.id) AS tickets, MAX(s.starts_at) AS last_seen`+soldTickets+inYear+`
+			GROUP BY m.id ORDER BY tickets DESC, last_seen DESC LIMIT 5) top`,
+		userID, from, to).Scan(pq.Array(&ids))
+	if err != nil {
+		return stats, err
+	}
+	if stats.TopMovies, err = s.moviesInOrder(ids); err != nil {
+		return stats, err
+	}
+
+	var month PeriodStat
+	err = s.db.QueryRow(`
+		SELECT to_char(s.starts_at, 'YYYY-MM') AS period, COUNT(*), COALESCE(SUM(m.duration), 0), COALESCE(SUM(t.price), 0)`+
+		soldTickets+inYear+`
+		GROUP BY period ORDER BY 2 DESC, 3 DESC, period LIMIT 1`, userID, from, to).
+		Scan(&month.Period, &month.Tickets, &month.Minutes, &month.Spend)
+	if err != nil {
+		return stats, err
+	}
+	stats.BusiestMonth = &month
+
+	err = s.db.QueryRow(`SELECT h.name FROM halls h WHERE h.id = (SELECT s.hall_id`+soldTickets+inYear+`
+		GROUP BY s.hall_id ORDER BY COUNT(*) DESC, s.hall_id LIMIT 1)`, userID, from, to).Scan(&stats.FavoriteHall)
+	if err != nil {
+		return stats, err
+	}
+	err = s.db.QueryRow(`SELECT `+timeOfDay+` AS part`+soldTickets+inYear+`
+		GROUP BY part ORDER BY COUNT(*) DESC, part LIMIT 1`, userID, from, to).Scan(&stats.FavoriteTimeOfDay)
+	return stats, err
+}
+
+// moviesInOrder loads movies by id keeping the order of ids. Movies deleted
+// since are left out.
+func (s *MovieStore) moviesInOrder(ids []int64) ([]models.Movie, error) {
+	if len(ids) == 0 {
+		return []models.Movie{}, nil
+	}
+	movies, err := s.queryMovies(`SELECT `+movieColumns+` FROM movies m WHERE m.id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]models.Movie, len(movies))
+	for _, m := range movies {
+		byID[m.ID] = m
+	}
+	ordered := make([]models.Movie, 0, len(movies))
+	for _, id := range ids {
+		if m, ok := byID[int(id)]; ok {
+			ordered = append(ordered, m)
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"Final_1/internal/ticketcheck"
+)
+
+// ticketGrace keeps a ticket valid for a while after its session ends.
+const ticketGrace = 30 * time.Minute
+
+// TicketSigner signs ticket payloads with Ed25519, so a scanner only needs
+// the public key to tell a real ticket from a made-up one.
+type TicketSigner struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// LoadTicketSigner reads an Ed25519 PKCS#8 PEM key. Without a path it makes
+// a throwaway key, so QR codes stop verifying after a restart.
+func LoadTicketSigner(path string) (*TicketSigner, error) {
+	if path == "" {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("TICKET_SIGNING_KEY not set, ticket QR codes are signed with a temporary key")
+		return &TicketSigner{private: private, public: public}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey("ticket", data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	private, ok := key.private.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: ticket signing key must be Ed25519", path)
+	}
+	return &TicketSigner{private: private, public: private.Public().(ed25519.PublicKey)}, nil
+}
+
+func (ts *TicketSigner) PublicKey() ed25519.PublicKey {
+	return ts.public
+}
+
+// Sign returns the text encoded in the ticket's QR code.
+func (ts *TicketSigner) Sign(c ticketcheck.Claims) string {
+	return ticketcheck.Sign(ts.private, c)
+}
+
+func (ts *TicketSigner) Verify(payload string) (ticketcheck.Claims, error) {
+	return ticketcheck.Verify(ts.public, payload)
+}
+
+func (ts *TicketSigner) SignManifest(m ticketcheck.Manifest) (ticketcheck.SignedManifest, error) {
+	return ticketcheck.SignManifest(ts.private, m)
+}
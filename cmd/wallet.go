@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"Final_1/internal/models"
+	"Final_1/internal/wallet"
+)
+
+const walletUsage = `usage:
+  cinema wallet test-cert [-dir path] [-pass-type id] [-team id]
+  cinema wallet verify    [-root cert.pem] file.pkpass
+
+test-cert writes pass-cert.pem and pass-key.pem, a self-signed stand-in
+for an Apple Pass Type ID certificate; point APPLE_PASS_CERT and
+APPLE_PASS_KEY at them to sign passes locally. verify checks the manifest
+and signature of a bundle.
+`
+
+// loadWallets reads the wallet credentials from the environment. Either
+// result is nil when its wallet is not configured.
+func loadWallets() (*wallet.PassSigner, *wallet.GoogleIssuer, error) {
+	var apple *wallet.PassSigner
+	if cert := os.Getenv("APPLE_PASS_CERT"); cert != "" {
+		var err error
+		apple, err = wallet.LoadPassSigner(cert, os.Getenv("APPLE_PASS_KEY"), os.Getenv("APPLE_WWDR_CERT"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("apple wallet: %w", err)
+		}
+		log.Printf("Apple Wallet passes signed as %s (team %s)", apple.PassTypeID, apple.TeamID)
+	}
+
+	var google *wallet.GoogleIssuer
+	if issuer := os.Getenv("GOOGLE_WALLET_ISSUER_ID"); issuer != "" {
+		var err error
+		google, err = wallet.LoadGoogleIssuer(issuer, os.Getenv("GOOGLE_WALLET_KEY"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("google wallet: %w", err)
+		}
+		if origins := os.Getenv("GOOGLE_WALLET_ORIGINS"); origins != "" {
+			google.Origins = strings.Split(origins, ",")
+		}
+	}
+	return apple, google, nil
+}
+
+// walletTicket combines a ticket and its session into what the passes show.
+func walletTicket(t models.Ticket, si SessionInfo, holder, payload string, expires time.Time) wallet.Ticket {
+	row, seat := seatPosition(t.SeatID, si.SeatsPerRow)
+	return wallet.Ticket{
+		ID:           t.ID,
+		OrderID:      t.OrderID,
+		SessionID:    si.ID,
+		Holder:       holder,
+		Movie:        si.MovieTitle,
+		Hall:         si.HallName,
+		Row:          row,
+		Seat:         seat,
+		Price:        t.Price,
+		Currency:     currency,
+		OpensAt:      si.Time.Add(-checkInOpens),
+		StartsAt:     si.Time,
+		EndsAt:       si.Time.Add(time.Duration(si.Duration) * time.Minute),
+		Expires:      expires,
+		Barcode:      payload,
+		Voided:       strings.EqualFold(t.Status, "USED"),
+		Organization: envOr("CINEMA_NAME", "Cinema"),
+	}
+}
+
+// runWalletCLI handles `cinema wallet ...`, which needs no database.
+func runWalletCLI(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, walletUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("wallet "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "test-cert":
+		dir := fs.String("dir", ".", "output directory")
+		passType := fs.String("pass-type", "pass.test.cinema", "pass type identifier")
+		team := fs.String("team", "TEST000000", "team identifier")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		certPEM, keyPEM, err := wallet.NewTestCertificate(*passType, *team)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(*dir, "pass-cert.pem"), certPEM, 0o644)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(*dir, "pass-key.pem"), keyPEM, 0o600)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("wrote pass-cert.pem and pass-key.pem to", *dir)
+		return 0
+
+	case "verify":
+		root := fs.String("root", "", "PEM certificate the signer must chain to")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			fmt.Fprint(os.Stderr, walletUsage)
+			return 2
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var roots *x509.CertPool
+		if *root != "" {
+			if roots, err = loadCertPool(*root); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		p, err := wallet.VerifyPKPass(data, roots)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid pass:", err)
+			return 1
+		}
+		fmt.Printf("ok: %s %s, relevant %s\n", p.PassTypeIdentifier, p.SerialNumber, p.RelevantDate)
+		return 0
+	}
+	fmt.Fprint(os.Stderr, walletUsage)
+	return 2
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(path + ": no certificate found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Final_1/internal/webhook"
+)
+
+const (
+	maxWebhookAttempts = 10
+	webhookTimeout     = 10 * time.Second
+	webhookLease       = 2 * time.Minute
+	webhookBatch       = 20
+)
+
+// webhookBackoff doubles from 30 seconds up to 12 hours, with up to 20%
+// jitter so a receiver coming back up is not hit by every retry at once.
+func webhookBackoff(attempts int) time.Duration {
+	d := min(30*time.Second<<min(attempts, 11), 12*time.Hour)
+	return d + rand.N(d/5+1)
+}
+
+// sendWebhook POSTs a delivery's payload, signed with the subscription's
+// secret, and reports how it went. Only a 2xx response counts as delivered.
+func sendWebhook(client *http.Client, d WebhookDelivery, manual bool) WebhookAttempt {
+	start := time.Now()
+	a := WebhookAttempt{AttemptedAt: start, Manual: manual}
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Cinema-Webhooks/1.0")
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(d.secret, start, d.Payload))
+		req.Header.Set(webhook.EventHeader, d.EventType)
+		req.Header.Set(webhook.DeliveryHeader, strconv.Itoa(d.ID))
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		a.StatusCode = &resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("receiver answered %s", resp.Status)
+		}
+		return nil
+	}()
+	a.DurationMS = int(time.Since(start).Milliseconds())
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+// runWebhooks works through due webhook deliveries every interval. Failed
+// ones are retried with backoff until maxWebhookAttempts.
+func runWebhooks(store *MovieStore, client *http.Client, interval time.Duration) {
+	for {
+		for {
+			batch, err := store.ClaimWebhookDeliveries(webhookBatch, webhookLease)
+			if err != nil {
+				log.Printf("[WEBHOOK]: reading queue: %v", err)
+				break
+			}
+			for _, d := range batch {
+				deliverWebhook(store, client, d)
+			}
+			if len(batch) < webhookBatch {
+				break
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func deliverWebhook(store *MovieStore, client *http.Client, d WebhookDelivery) {
+	a := sendWebhook(client, d, false)
+	status, next := "delivered", a.AttemptedAt
+	switch {
+	case a.Error == "":
+	case d.Attempts+1 >= maxWebhookAttempts:
+		status = "failed"
+		log.Printf("[WEBHOOK]: giving up on %s delivery %d to %s: %s", d.EventType, d.ID, d.url, a.Error)
+	default:
+		status, next = "pending", a.AttemptedAt.Add(webhookBackoff(d.Attempts))
+		log.Printf("[WEBHOOK]: %s delivery %d to %s failed, retrying at %s: %s",
+			d.EventType, d.ID, d.url, next.Format(time.TimeOnly), a.Error)
+	}
+	if err := store.RecordWebhookAttempt(d, a, status, next); err != nil {
+		log.Printf("[WEBHOOK]: recording attempt of delivery %d: %v", d.ID, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// WordFilter flags review texts containing blocked words before they are
+// published. Matching is by whole word and ignores case and the usual digit
+// substitutions ("id10t" matches "idiot").
+type WordFilter struct {
+	words map[string]bool
+}
+
+var defaultBlockedWords = []string{"idiot", "scam", "spam", "casino", "viagra"}
+
+// LoadWordFilter reads one blocked word per line from path; empty lines and
+// lines starting with # are skipped. Without a path the defaults are used.
+func LoadWordFilter(path string) (*WordFilter, error) {
+	if path == "" {
+		return NewWordFilter(defaultBlockedWords), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return NewWordFilter(words), sc.Err()
+}
+
+func NewWordFilter(words []string) *WordFilter {
+	wf := &WordFilter{words: map[string]bool{}}
+	for _, w := range words {
+		wf.words[normalizeWord(w)] = true
+	}
+	return wf
+}
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+func normalizeWord(w string) string {
+	return leetReplacer.Replace(strings.ToLower(strings.TrimSpace(w)))
+}
+
+// Check returns a reason for flagging text, or "" when it is clean.
+func (wf *WordFilter) Check(text string) string {
+	if wf == nil || len(wf.words) == 0 {
+		return ""
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '@' && r != '$'
+	})
+	for _, f := range fields {
+		if w := normalizeWord(f); wf.words[w] {
+			return "blocked word: " + w
+		}
+	}
+	return ""
+}
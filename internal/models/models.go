@@ -3,24 +3,80 @@ package models
 import "time"
 
 type Movie struct {
-	ID       int    `json:"id"`
-	Title    string `json:"title"`
-	Genre    string `json:"genre"`
-	Duration int    `json:"duration"` // minutes
-	Price    int    `json:"price"`
+	ID              int          `json:"id"`
+	Title           string       `json:"title"`
+	Genres          []string     `json:"genres"`
+	Duration        int          `json:"duration"` // minutes
+	Price           int          `json:"price"`
+	Rating          float64      `json:"rating"` // average of user reviews
+	RatingCount     int          `json:"rating_count"`
+	RatingHistogram []int        `json:"rating_histogram"` // [i] = number of (i+1)-star reviews
+	Description     string       `json:"description"`
+	ReleaseDate     *Date        `json:"release_date"`
+	Language        string       `json:"language"`  // original language, ISO 639-1
+	Subtitles       []string     `json:"subtitles"` // ISO 639-1 codes
+	AgeRating       string       `json:"age_rating"`
+	Directors       []string     `json:"directors"`
+	Cast            []CastMember `json:"cast"`
+	PosterURL       string       `json:"poster_url"`
+	TrailerURL      string       `json:"trailer_url"`
+}
+
+type CastMember struct {
+	Name      string `json:"name"`
+	Character string `json:"character,omitempty"`
+}
+
+// Date is a calendar day without time zone, encoded as "2006-01-02".
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.Format(time.DateOnly) + `"`), nil
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	t, err := time.Parse(`"`+time.DateOnly+`"`, string(b))
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
 }
 
 type Hall struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	TotalSeats int    `json:"total_seats"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	TotalSeats  int    `json:"total_seats"`
+	SeatsPerRow int    `json:"seats_per_row"` // seats are numbered row by row from the screen
 }
 
+// Seat categories. Seats a hall does not list are standard.
+const (
+	SeatStandard   = "standard"
+	SeatVIP        = "vip"
+	SeatCouple     = "couple"
+	SeatAccessible = "accessible"
+)
+
+var SeatCategories = []string{SeatStandard, SeatVIP, SeatCouple, SeatAccessible}
+
 type Seat struct {
-	ID     int `json:"id"`
-	HallID int `json:"hall_id"`
-	Row    int `json:"row"`
-	Number int `json:"number"`
+	ID          int    `json:"id"` // number in the hall, as in Ticket.SeatID
+	HallID      int    `json:"hall_id"`
+	Row         int    `json:"row"`
+	Number      int    `json:"number"`
+	Category    string `json:"category"`
+	CompanionOf *int   `json:"companion_of,omitempty"` // accessible seat this one is kept for
+}
+
+// CategoryPrice adjusts a session's base price for one seat category:
+// round(base * Multiplier) + Surcharge.
+type CategoryPrice struct {
+	Category   string  `json:"category"`
+	Multiplier float64 `json:"multiplier"`
+	Surcharge  int     `json:"surcharge"`
 }
 
 type Session struct {
@@ -28,7 +84,8 @@ type Session struct {
 	MovieID int       `json:"movie_id"`
 	HallID  int       `json:"hall_id"`
 	Time    time.Time `json:"time"`
-	Price   float64   `json:"price"`
+	Price   float64   `json:"price"`  // base price before seat category and pricing rules
+	Format  string    `json:"format"` // 2D, 3D or IMAX
 }
 
 type User struct {
@@ -43,6 +100,48 @@ type Ticket struct {
 	SessionID int    `json:"session_id"`
 	SeatID    int    `json:"seat_id"`
 	UserID    int    `json:"user_id"`
-	Status    string `json:"status"` // booked/paid/cancelled
+	OrderID   int    `json:"order_id,omitempty"`
+	Status    string `json:"status"` // BOOKED, USED (checked in) or CANCELLED
 	Price     int    `json:"price"`
+	Tariff    string `json:"tariff,omitempty"` // adult, child, student or senior
+}
+
+type Order struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	Status        string    `json:"status"`
+	PaymentMethod string    `json:"payment_method"` // card, cash; unknown for old bookings
+	Total         int       `json:"total"`          // VAT included
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Review struct {
+	ID        int       `json:"id"`
+	MovieID   int       `json:"movie_id"`
+	UserID    int       `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int       `json:"rating"` // 1..5 stars
+	Body      string    `json:"body"`
+	Status    string    `json:"status"` // visible/flagged/hidden
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ReviewReport struct {
+	ID         int       `json:"id"`
+	ReviewID   int       `json:"review_id"`
+	ReporterID int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ModerationEvent is one entry of a review's moderation history. ActorID is
+// nil for automatic actions such as the word filter.
+type ModerationEvent struct {
+	ID        int       `json:"id"`
+	ReviewID  int       `json:"review_id"`
+	ActorID   *int      `json:"actor_id"`
+	Action    string    `json:"action"` // auto_flag/reported/edited/approved/hidden/deleted
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,152 @@
+// Package notify delivers email. Senders implement Notifier; the SMTP one
+// talks to a real relay and the file one writes .eml files for development.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string // optional alternative to Text
+}
+
+type Notifier interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Permanent wraps errors that retrying will not fix, such as a malformed
+// address.
+type Permanent struct{ Err error }
+
+func (p Permanent) Error() string { return p.Err.Error() }
+func (p Permanent) Unwrap() error { return p.Err }
+
+func IsPermanent(err error) bool {
+	var p Permanent
+	return errors.As(err, &p)
+}
+
+// encode renders m as a MIME message from from.
+func encode(from string, m Message, now time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return nil, Permanent{fmt.Errorf("recipient %q: %w", m.To, err)}
+	}
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", randomID(), domainOf(from)))
+	header("MIME-Version", "1.0")
+
+	part := func(contentType, body string) {
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", contentType)
+		qp := quotedprintable.NewWriter(&buf)
+		qp.Write([]byte(body))
+		qp.Close()
+		buf.WriteString("\r\n")
+	}
+	if m.HTML == "" {
+		part("text/plain", m.Text)
+		return buf.Bytes(), nil
+	}
+	boundary := "alt-" + randomID()
+	header("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", boundary))
+	buf.WriteString("\r\n")
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	part("text/plain", m.Text)
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	part("text/html", m.HTML)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domainOf(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+// SMTP sends through a relay, with STARTTLS when the server offers it and
+// PLAIN auth when a username is set.
+type SMTP struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	msg, err := encode(s.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return Permanent{fmt.Errorf("sender %q: %w", s.From, err)}
+	}
+	to, _ := mail.ParseAddress(m.To)
+
+	// net/smtp has no context support; run it aside so a cancelled ctx
+	// at least stops the wait.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, from.Address, []string{to.Address}, msg) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileSink writes every message to Dir as a .eml file instead of sending
+// it.
+type FileSink struct {
+	Dir  string
+	From string
+}
+
+func (f *FileSink) Send(ctx context.Context, m Message) error {
+	now := time.Now()
+	msg, err := encode(f.From, m, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), randomID()[:8])
+	return os.WriteFile(filepath.Join(f.Dir, name), msg, 0o644)
+}
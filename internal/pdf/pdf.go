@@ -0,0 +1,261 @@
+// Package pdf writes simple one-column documents: text, lines and filled
+// rectangles. Coordinates are in points from the top-left corner of an A4
+// page. Text is set in DejaVu Sans, embedded as a subset with a ToUnicode map
+// so that any script the font covers prints and can be copied out; a
+// character the font lacks makes WriteTo fail instead of printing a
+// placeholder.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+var (
+	//go:embed fonts/DejaVuSans.ttf
+	regularTTF []byte
+	//go:embed fonts/DejaVuSans-Bold.ttf
+	boldTTF []byte
+)
+
+// faces parses the embedded fonts on first use. They are part of the
+// binary, so a parse error is a build problem.
+var faces = sync.OnceValue(func() [2]*face {
+	var fs [2]*face
+	for i, f := range []struct {
+		name string
+		data []byte
+	}{Regular: {"DejaVuSans", regularTTF}, Bold: {"DejaVuSans-Bold", boldTTF}} {
+		var err error
+		if fs[i], err = parseFace(f.name, f.data); err != nil {
+			panic(err)
+		}
+	}
+	return fs
+})
+
+type Document struct {
+	title   string
+	pages   []*Page
+	used    [2]map[uint16]rune // glyphs drawn per font, for the subset and ToUnicode
+	missing map[rune]bool
+}
+
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+func New(title string) *Document {
+	return &Document{
+		title:   title,
+		used:    [2]map[uint16]rune{{}, {}},
+		missing: map[rune]bool{},
+	}
+}
+
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline at (x, y).
+func (p *Page) Text(x, y, size float64, font Font, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td <%s> Tj ET\n", font+1, size, x, PageHeight-y, p.doc.glyphs(font, s))
+}
+
+// glyphs encodes s as two-byte glyph ids and remembers which were used.
+func (d *Document) glyphs(font Font, s string) string {
+	f := faces()[font]
+	var b strings.Builder
+	for _, r := range s {
+		g, ok := f.cmap[r]
+		if !ok {
+			d.missing[r] = true
+			continue
+		}
+		if _, seen := d.used[font][g]; !seen {
+			d.used[font][g] = r
+		}
+		fmt.Fprintf(&b, "%04X", g)
+	}
+	return b.String()
+}
+
+// TextRight draws s so that it ends at x.
+func (p *Page) TextRight(x, y, size float64, font Font, s string) {
+	p.Text(x-TextWidth(s, size, font), y, size, font, s)
+}
+
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Rect fills a black rectangle whose top-left corner is (x, y).
+func (p *Page) Rect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re f\n", x, PageHeight-y-h, w, h)
+}
+
+// TextWidth is the advance width of s; characters the font lacks count as
+// nothing.
+func TextWidth(s string, size float64, font Font) float64 {
+	f := faces()[font]
+	w := 0.0
+	for _, r := range s {
+		if g, ok := f.cmap[r]; ok {
+			w += f.width(g)
+		}
+	}
+	return w * size / 1000
+}
+
+func utf16Hex(s string) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	return b.String()
+}
+
+// textString encodes s as a UTF-16 PDF text string for metadata.
+func textString(s string) string {
+	return "<FEFF" + utf16Hex(s) + ">"
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// fontObjects returns the objects of one embedded font, the Type0 font
+// first, numbered from first.
+func (d *Document) fontObjects(font Font, first int) []string {
+	f := faces()[font]
+	used := d.used[font]
+	gids := make([]int, 0, len(used))
+	for g := range used {
+		gids = append(gids, int(g))
+	}
+	sort.Ints(gids)
+
+	// Subset fonts are named with a tag derived from their glyphs.
+	sum := sha256.Sum256([]byte(fmt.Sprint(gids)))
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + sum[i]%26
+	}
+	name := string(tag) + "+" + f.name
+
+	var widths, cmap strings.Builder
+	for _, g := range gids {
+		fmt.Fprintf(&widths, "%d [%.0f] ", g, f.width(uint16(g)))
+	}
+	fmt.Fprintf(&cmap, "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n"+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n"+
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n"+
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for i := 0; i < len(gids); i += 100 {
+		chunk := gids[i:min(i+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+		for _, g := range chunk {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", g, utf16Hex(string(used[uint16(g)])))
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+
+	scale := func(v int) int { return v * 1000 / f.unitsPerEm }
+	file := f.subset(used)
+	packed := deflate(file)
+	return []string{
+		fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H "+
+			"/DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, first+1, first+4),
+		fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+			"/FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>", name, first+2, widths.String()),
+		fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 "+
+			"/Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>", name,
+			scale(f.bbox[0]), scale(f.bbox[1]), scale(f.bbox[2]), scale(f.bbox[3]),
+			scale(f.ascent), scale(f.descent), scale(f.capHeight), first+3),
+		fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(packed), len(file), packed),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", cmap.Len(), cmap.String()),
+	}
+}
+
+// WriteTo writes the finished document. It fails without writing anything
+// when some text used characters the embedded font does not have.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.missing) > 0 {
+		runes := make([]rune, 0, len(d.missing))
+		for r := range d.missing {
+			runes = append(runes, r)
+		}
+		sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+		return 0, fmt.Errorf("pdf: no glyph for %q", string(runes))
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1-3: catalog, page tree, info; then five objects for each
+	// font and a page and its content stream for every page.
+	const fontObjs = 5
+	firstPage := 4 + fontObjs*len(faces())
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj(fmt.Sprintf("<< /Title %s /Producer (cinema) >>", textString(d.title)))
+	var fonts []string
+	for font := range faces() {
+		first := 4 + fontObjs*font
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", font+1, first))
+		for _, body := range d.fontObjects(Font(font), first) {
+			obj(body)
+		}
+	}
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << %s >> >> /Contents %d 0 R >>", PageWidth, PageHeight, strings.Join(fonts, " "), firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.WriteTo(w)
+}
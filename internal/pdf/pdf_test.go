@@ -0,0 +1,138 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, lines ...string) []byte {
+	t.Helper()
+	doc := New("Билет №1")
+	p := doc.AddPage()
+	for i, s := range lines {
+		p.Text(60, 80+20*float64(i), 12, Font(i%2), s)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXrefPointsAtObjects(t *testing.T) {
+	out := render(t, "Hello", "Қазақ тілі")
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := strings.Split(string(out[xref:]), "\n")[3:]
+	for i, e := range entries {
+		if !strings.HasSuffix(e, " n ") {
+			break
+		}
+		off, _ := strconv.Atoi(e[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Fatalf("xref entry %d points at %q", i+1, out[off:off+12])
+		}
+	}
+}
+
+func TestToUnicodeMapsDrawnGlyphs(t *testing.T) {
+	out := render(t, "Ж", "Ж")
+	f := faces()[Regular]
+	g := f.cmap['Ж']
+	if !bytes.Contains(out, []byte(fmt.Sprintf("<%04X> <0416>", g))) {
+		t.Fatalf("no ToUnicode entry for glyph %d", g)
+	}
+	if !bytes.Contains(out, []byte(fmt.Sprintf("<%04X> Tj", g))) {
+		t.Fatal("text is not drawn with the glyph id")
+	}
+	if !bytes.Contains(out, []byte("/Title <FEFF0411")) {
+		t.Fatal("title is not a UTF-16 text string")
+	}
+}
+
+func TestSubsetKeepsUsedGlyphs(t *testing.T) {
+	f := faces()[Bold]
+	keep := f.cmap['Й'] // a composite: И with a breve
+	file := f.subset(map[uint16]rune{keep: 'Й'})
+
+	tables := map[string][]byte{}
+	for i := 0; i < u16(file, 4); i++ {
+		rec := 12 + 16*i
+		off, length := u32(file, rec+8), u32(file, rec+12)
+		data := file[off : off+length]
+		if got, want := checksum(data), uint32(u32(file, rec+4)); got != want && string(file[rec:rec+4]) != "head" {
+			t.Errorf("table %s: checksum %08x, directory says %08x", file[rec:rec+4], got, want)
+		}
+		tables[string(file[rec:rec+4])] = data
+	}
+	if checksum(file) != 0xB1B0AFBA {
+		t.Error("font checksum is not adjusted")
+	}
+
+	loca, glyf := tables["loca"], tables["glyf"]
+	glyph := func(g int) []byte { return glyf[u32(loca, 4*g):u32(loca, 4*g+4)] }
+	if !bytes.Equal(glyph(int(keep)), f.glyph(int(keep))) {
+		t.Error("used glyph changed")
+	}
+	for _, c := range components(f.glyph(int(keep))) {
+		if len(glyph(c)) == 0 {
+			t.Errorf("component %d of the composite was dropped", c)
+		}
+	}
+	if other := f.cmap['Q']; len(glyph(int(other))) != 0 {
+		t.Error("unused glyph kept")
+	}
+	if len(file) > len(boldTTF)/4 {
+		t.Errorf("subset is %d bytes", len(file))
+	}
+}
+
+func TestFontFileInflates(t *testing.T) {
+	out := render(t, "Seat 12")
+	i := bytes.Index(out, []byte("/Filter /FlateDecode >>\nstream\n"))
+	if i < 0 {
+		t.Fatal("no embedded font")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(out[i+len("/Filter /FlateDecode >>\nstream\n"):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u32(file, 0) != 0x00010000 {
+		t.Fatalf("embedded font starts with %x", file[:4])
+	}
+}
+
+func TestMissingGlyphFails(t *testing.T) {
+	doc := New("x")
+	doc.AddPage().Text(0, 0, 10, Regular, "映画 ok")
+	_, err := doc.WriteTo(io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "映画") {
+		t.Fatalf("err = %v, want the missing characters named", err)
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	if w := TextWidth("", 10, Regular); w != 0 {
+		t.Errorf("empty width %v", w)
+	}
+	narrow, wide := TextWidth("iiii", 10, Regular), TextWidth("MMMM", 10, Regular)
+	if narrow <= 0 || wide <= narrow {
+		t.Errorf("iiii = %v, MMMM = %v", narrow, wide)
+	}
+	if TextWidth("MMMM", 10, Bold) <= wide {
+		t.Error("bold is not wider")
+	}
+}
@@ -0,0 +1,275 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// face is a parsed TrueType font: enough to measure text, map runes to
+// glyphs and cut a subset for embedding.
+type face struct {
+	name       string
+	tables     map[string][]byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	capHeight  int
+	bbox       [4]int
+	advances   []int // per glyph, in font units
+	cmap       map[rune]uint16
+	loca       []int // glyph i is glyf[loca[i]:loca[i+1]]
+}
+
+func u16(b []byte, off int) int { return int(binary.BigEndian.Uint16(b[off:])) }
+func i16(b []byte, off int) int { return int(int16(binary.BigEndian.Uint16(b[off:]))) }
+func u32(b []byte, off int) int { return int(binary.BigEndian.Uint32(b[off:])) }
+
+func parseFace(name string, data []byte) (*face, error) {
+	if len(data) < 12 {
+		return nil, errors.New("pdf: font too short")
+	}
+	f := &face{name: name, tables: map[string][]byte{}}
+	n := u16(data, 4)
+	for i := 0; i < n; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errors.New("pdf: truncated table directory")
+		}
+		off, length := u32(data, rec+8), u32(data, rec+12)
+		if off+length > len(data) {
+			return nil, fmt.Errorf("pdf: table %q out of range", data[rec:rec+4])
+		}
+		f.tables[string(data[rec:rec+4])] = data[off : off+length]
+	}
+	for _, t := range []string{"head", "hhea", "maxp", "hmtx", "cmap", "loca", "glyf"} {
+		if f.tables[t] == nil {
+			return nil, fmt.Errorf("pdf: font has no %s table", t)
+		}
+	}
+
+	head, hhea := f.tables["head"], f.tables["hhea"]
+	f.unitsPerEm = u16(head, 18)
+	f.bbox = [4]int{i16(head, 36), i16(head, 38), i16(head, 40), i16(head, 42)}
+	f.ascent, f.descent = i16(hhea, 4), i16(hhea, 6)
+	f.capHeight = f.ascent
+	if os2 := f.tables["OS/2"]; len(os2) >= 90 && u16(os2, 0) >= 2 {
+		f.capHeight = i16(os2, 88)
+	}
+
+	numGlyphs := u16(f.tables["maxp"], 4)
+	hmtx, numMetrics := f.tables["hmtx"], u16(hhea, 34)
+	f.advances = make([]int, numGlyphs)
+	for g := range f.advances {
+		m := min(g, numMetrics-1)
+		f.advances[g] = u16(hmtx, 4*m)
+	}
+
+	loca := f.tables["loca"]
+	f.loca = make([]int, numGlyphs+1)
+	for g := range f.loca {
+		if i16(head, 50) == 0 {
+			f.loca[g] = 2 * u16(loca, 2*g)
+		} else {
+			f.loca[g] = u32(loca, 4*g)
+		}
+	}
+
+	var err error
+	if f.cmap, err = parseCmap(f.tables["cmap"]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseCmap reads the Unicode subtable, preferring the full-range format 12
+// over the BMP-only format 4.
+func parseCmap(b []byte) (map[rune]uint16, error) {
+	var fmt4, fmt12 []byte
+	for i := 0; i < u16(b, 2); i++ {
+		rec := 4 + 8*i
+		platform, encoding, off := u16(b, rec), u16(b, rec+2), u32(b, rec+4)
+		switch {
+		case platform == 3 && encoding == 10 && u16(b, off) == 12:
+			fmt12 = b[off:]
+		case platform == 3 && encoding == 1 && u16(b, off) == 4:
+			fmt4 = b[off:]
+		}
+	}
+
+	m := map[rune]uint16{}
+	switch {
+	case fmt12 != nil:
+		for i := 0; i < u32(fmt12, 12); i++ {
+			g := 16 + 12*i
+			start, end, gid := u32(fmt12, g), u32(fmt12, g+4), u32(fmt12, g+8)
+			for c := start; c <= end; c++ {
+				m[rune(c)] = uint16(gid + c - start)
+			}
+		}
+	case fmt4 != nil:
+		segs := u16(fmt4, 6) / 2
+		ends, starts, deltas, offsets := 14, 16+2*segs, 16+4*segs, 16+6*segs
+		for s := 0; s < segs; s++ {
+			end, start := u16(fmt4, ends+2*s), u16(fmt4, starts+2*s)
+			delta, rangeOff := u16(fmt4, deltas+2*s), u16(fmt4, offsets+2*s)
+			for c := start; c <= end && c != 0xffff; c++ {
+				var gid int
+				if rangeOff == 0 {
+					gid = (c + delta) & 0xffff
+				} else if gid = u16(fmt4, offsets+2*s+rangeOff+2*(c-start)); gid != 0 {
+					gid = (gid + delta) & 0xffff
+				}
+				if gid != 0 {
+					m[rune(c)] = uint16(gid)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("pdf: font has no Unicode cmap")
+	}
+	return m, nil
+}
+
+// width is the advance of glyph g in thousandths of an em.
+func (f *face) width(g uint16) float64 {
+	return float64(f.advances[g]) * 1000 / float64(f.unitsPerEm)
+}
+
+func (f *face) glyph(g int) []byte {
+	return f.tables["glyf"][f.loca[g]:f.loca[g+1]]
+}
+
+// Flags of a composite glyph component.
+const (
+	argsAreWords   = 0x0001
+	haveScale      = 0x0008
+	moreComponents = 0x0020
+	haveXYScale    = 0x0040
+	haveTwoByTwo   = 0x0080
+)
+
+// components lists the glyphs a composite glyph is built from.
+func components(glyph []byte) []int {
+	if len(glyph) < 10 || i16(glyph, 0) >= 0 {
+		return nil
+	}
+	var ids []int
+	for off := 10; off+4 <= len(glyph); {
+		flags := u16(glyph, off)
+		ids = append(ids, u16(glyph, off+2))
+		off += 4
+		if flags&argsAreWords != 0 {
+			off += 4
+		} else {
+			off += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			off += 2
+		case flags&haveXYScale != 0:
+			off += 4
+		case flags&haveTwoByTwo != 0:
+			off += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return ids
+}
+
+// subset returns a font file that keeps glyph ids but only the outlines of
+// used glyphs and what they are composed of. A PDF addresses the glyphs by
+// id, so the cmap and the layout tables can go.
+func (f *face) subset(used map[uint16]rune) []byte {
+	keep := map[int]bool{}
+	var walk func(g int)
+	walk = func(g int) {
+		if keep[g] || g >= len(f.advances) {
+			return
+		}
+		keep[g] = true
+		for _, c := range components(f.glyph(g)) {
+			walk(c)
+		}
+	}
+	walk(0)
+	for g := range used {
+		walk(int(g))
+	}
+
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(len(f.advances)+1))
+	for g := range f.advances {
+		binary.BigEndian.PutUint32(loca[4*g:], uint32(glyf.Len()))
+		if keep[g] {
+			glyf.Write(f.glyph(g))
+			for glyf.Len()%4 != 0 {
+				glyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*len(f.advances):], uint32(glyf.Len()))
+
+	head := bytes.Clone(f.tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment, set below
+	binary.BigEndian.PutUint16(head[50:], 1) // long loca offsets
+
+	tables := map[string][]byte{
+		"head": head, "hhea": f.tables["hhea"], "maxp": f.tables["maxp"], "hmtx": f.tables["hmtx"],
+		"loca": loca, "glyf": glyf.Bytes(),
+	}
+	for _, t := range []string{"cvt ", "fpgm", "prep"} {
+		if f.tables[t] != nil {
+			tables[t] = f.tables[t]
+		}
+	}
+	tags := make([]string, 0, len(tables))
+	for t := range tables {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+
+	var out bytes.Buffer
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+	binary.Write(&out, binary.BigEndian, []uint32{0x00010000})
+	binary.Write(&out, binary.BigEndian, []uint16{uint16(n), uint16(searchRange), uint16(entrySelector), uint16(16*n - searchRange)})
+	off := 12 + 16*n
+	var headOff int
+	for _, t := range tags {
+		data := tables[t]
+		out.WriteString(t)
+		binary.Write(&out, binary.BigEndian, []uint32{checksum(data), uint32(off), uint32(len(data))})
+		if t == "head" {
+			headOff = off
+		}
+		off += (len(data) + 3) &^ 3
+	}
+	for _, t := range tags {
+		out.Write(tables[t])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	font := out.Bytes()
+	binary.BigEndian.PutUint32(font[headOff+8:], 0xB1B0AFBA-checksum(font))
+	return font
+}
+
+func checksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+		copy(word[:], b[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,223 @@
+// Package pricing derives a ticket price from a base price and a list of
+// rules, and records how it got there.
+//
+// Rules are tried from the highest priority down (ties by id). A rule
+// applies when it is active, the session date is within its validity
+// period and every condition it sets matches; conditions left empty match
+// anything. Among rules sharing a group only the first that applies counts,
+// so for example a matinee and a Tuesday discount in group "discount" do
+// not stack, while a 3D surcharge without a group always adds up.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"Final_1/internal/models"
+)
+
+// How a rule changes the price.
+const (
+	AdjustPercent = "percent" // Value is a percentage: -50 halves the price
+	AdjustAmount  = "amount"  // Value is added to the price
+	AdjustFixed   = "fixed"   // Value replaces the price
+)
+
+// Tariffs are the kinds of customer a ticket can be sold to; adult is the
+// default.
+var Tariffs = []string{"adult", "child", "student", "senior"}
+
+// Formats a session can be shown in; 2D is the default.
+var Formats = []string{"2D", "3D", "IMAX"}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type Rule struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Priority int     `json:"priority"`
+	Group    string  `json:"group,omitempty"`
+	Adjust   string  `json:"adjust"`
+	Value    float64 `json:"value"`
+
+	// Conditions.
+	Tariffs      []string     `json:"tariffs,omitempty"`
+	Weekdays     []string     `json:"weekdays,omitempty"` // sun, mon, ... sat
+	Formats      []string     `json:"formats,omitempty"`
+	Categories   []string     `json:"categories,omitempty"`    // seat categories
+	StartsAfter  string       `json:"starts_after,omitempty"`  // "15:04", inclusive
+	StartsBefore string       `json:"starts_before,omitempty"` // "15:04", exclusive
+	ValidFrom    *models.Date `json:"valid_from,omitempty"`
+	ValidUntil   *models.Date `json:"valid_until,omitempty"` // inclusive
+
+	Active bool `json:"active"`
+}
+
+func (r *Rule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	switch r.Adjust {
+	case AdjustPercent:
+		if r.Value < -100 {
+			return errors.New("a percent adjustment cannot take off more than 100")
+		}
+	case AdjustAmount:
+	case AdjustFixed:
+		if r.Value < 0 {
+			return errors.New("a fixed price cannot be negative")
+		}
+	default:
+		return errors.New("adjust must be percent, amount or fixed")
+	}
+	for _, set := range []struct {
+		name    string
+		values  []string
+		allowed []string
+	}{
+		{"tariffs", r.Tariffs, Tariffs},
+		{"weekdays", r.Weekdays, weekdays},
+		{"formats", r.Formats, Formats},
+		{"categories", r.Categories, models.SeatCategories},
+	} {
+		for _, v := range set.values {
+			if !slices.Contains(set.allowed, v) {
+				return fmt.Errorf("%s must be among %s, not %q", set.name, strings.Join(set.allowed, ", "), v)
+			}
+		}
+	}
+	for _, t := range []string{r.StartsAfter, r.StartsBefore} {
+		if _, err := clock(t); err != nil {
+			return err
+		}
+	}
+	if r.ValidFrom != nil && r.ValidUntil != nil && r.ValidUntil.Before(r.ValidFrom.Time) {
+		return errors.New("valid_until is before valid_from")
+	}
+	return nil
+}
+
+// clock parses "15:04" into minutes after midnight; "" is -1.
+func clock(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("times must look like 12:00, not %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Context is what rules are matched against. StartsAt should be in the
+// cinema's time zone.
+type Context struct {
+	StartsAt time.Time
+	Tariff   string
+	Format   string
+	Category string
+}
+
+// Step is one change made to the price.
+type Step struct {
+	RuleID     int    `json:"rule_id,omitempty"`
+	Name       string `json:"name"`
+	Adjustment string `json:"adjustment"` // e.g. "-50%", "+300", "=1500"
+	Before     int    `json:"before"`
+	After      int    `json:"after"`
+}
+
+// Skipped is a rule that did not apply, and why.
+type Skipped struct {
+	RuleID int    `json:"rule_id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type Quote struct {
+	Base    int       `json:"base"`
+	Price   int       `json:"price"`
+	Steps   []Step    `json:"steps"`
+	Skipped []Skipped `json:"skipped"`
+}
+
+// Apply runs rules over q, starting from q.Price.
+func Apply(q Quote, ctx Context, rules []Rule) Quote {
+	rules = slices.Clone(rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	if q.Steps == nil {
+		q.Steps = []Step{}
+	}
+	q.Skipped = []Skipped{}
+
+	groups := map[string]string{}
+	for _, r := range rules {
+		reason := r.mismatch(ctx)
+		if reason == "" && r.Group != "" && groups[r.Group] != "" {
+			reason = fmt.Sprintf("%s already applied in group %s", groups[r.Group], r.Group)
+		}
+		if reason != "" {
+			q.Skipped = append(q.Skipped, Skipped{RuleID: r.ID, Name: r.Name, Reason: reason})
+			continue
+		}
+		if r.Group != "" {
+			groups[r.Group] = r.Name
+		}
+		step := Step{RuleID: r.ID, Name: r.Name, Before: q.Price}
+		switch r.Adjust {
+		case AdjustPercent:
+			step.After = int(math.Round(float64(q.Price) * (1 + r.Value/100)))
+			step.Adjustment = fmt.Sprintf("%+g%%", r.Value)
+		case AdjustAmount:
+			step.After = q.Price + int(math.Round(r.Value))
+			step.Adjustment = fmt.Sprintf("%+d", int(math.Round(r.Value)))
+		case AdjustFixed:
+			step.After = int(math.Round(r.Value))
+			step.Adjustment = fmt.Sprintf("=%d", step.After)
+		}
+		step.After = max(step.After, 0)
+		q.Price = step.After
+		q.Steps = append(q.Steps, step)
+	}
+	return q
+}
+
+// mismatch returns why r does not apply in ctx, or "" if it does.
+func (r Rule) mismatch(ctx Context) string {
+	day := ctx.StartsAt.Format(time.DateOnly)
+	switch {
+	case !r.Active:
+		return "inactive"
+	case r.ValidFrom != nil && day < r.ValidFrom.Format(time.DateOnly):
+		return "valid from " + r.ValidFrom.Format(time.DateOnly)
+	case r.ValidUntil != nil && day > r.ValidUntil.Format(time.DateOnly):
+		return "expired on " + r.ValidUntil.Format(time.DateOnly)
+	case len(r.Tariffs) > 0 && !slices.Contains(r.Tariffs, ctx.Tariff):
+		return "only for tariffs " + strings.Join(r.Tariffs, ", ")
+	case len(r.Weekdays) > 0 && !slices.Contains(r.Weekdays, weekdays[ctx.StartsAt.Weekday()]):
+		return "only on " + strings.Join(r.Weekdays, ", ")
+	case len(r.Formats) > 0 && !slices.Contains(r.Formats, ctx.Format):
+		return "only for " + strings.Join(r.Formats, ", ")
+	case len(r.Categories) > 0 && !slices.Contains(r.Categories, ctx.Category):
+		return "only for " + strings.Join(r.Categories, ", ") + " seats"
+	}
+	minute := ctx.StartsAt.Hour()*60 + ctx.StartsAt.Minute()
+	if after, _ := clock(r.StartsAfter); after >= 0 && minute < after {
+		return "only for sessions from " + r.StartsAfter
+	}
+	if before, _ := clock(r.StartsBefore); before >= 0 && minute >= before {
+		return "only for sessions before " + r.StartsBefore
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+package pricing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"Final_1/internal/models"
+)
+
+// friday is a Friday evening 3D session in the cinema's zone.
+var friday = Context{
+	StartsAt: time.Date(2026, 3, 13, 19, 30, 0, 0, time.FixedZone("ALMT", 5*3600)),
+	Tariff:   "adult",
+	Format:   "3D",
+	Category: models.SeatStandard,
+}
+
+func date(s string) *models.Date {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return &models.Date{Time: t}
+}
+
+func rule(id int, name string, priority int, group, adjust string, value float64) Rule {
+	return Rule{ID: id, Name: name, Priority: priority, Group: group, Adjust: adjust, Value: value, Active: true}
+}
+
+func applied(q Quote) []string {
+	names := []string{}
+	for _, s := range q.Steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		price int
+		steps []string
+	}{
+		{"no rules", nil, 2000, []string{}},
+		{"higher priority first", []Rule{
+			rule(1, "plus 500", 1, "", AdjustAmount, 500),
+			rule(2, "half", 2, "", AdjustPercent, -50),
+		}, 1500, []string{"half", "plus 500"}},
+		{"ties go to the lower id", []Rule{
+			rule(2, "plus 500", 1, "", AdjustAmount, 500),
+			rule(1, "half", 1, "", AdjustPercent, -50),
+		}, 1500, []string{"half", "plus 500"}},
+		{"one rule per group", []Rule{
+			rule(1, "matinee", 5, "discount", AdjustPercent, -30),
+			rule(2, "friday", 10, "discount", AdjustPercent, -20),
+		}, 1600, []string{"friday"}},
+		{"a rule that does not apply leaves its group open", []Rule{
+			{ID: 1, Name: "child", Priority: 10, Group: "discount", Adjust: AdjustPercent, Value: -50, Tariffs: []string{"child"}, Active: true},
+			rule(2, "friday", 5, "discount", AdjustPercent, -20),
+		}, 1600, []string{"friday"}},
+		{"ungrouped rules stack", []Rule{
+			rule(1, "3D glasses", 1, "", AdjustAmount, 300),
+			rule(2, "friday", 5, "discount", AdjustPercent, -10),
+			rule(3, "booking fee", 0, "", AdjustAmount, 100),
+		}, 2200, []string{"friday", "3D glasses", "booking fee"}},
+		{"fixed price replaces what came before", []Rule{
+			rule(1, "surcharge", 10, "", AdjustAmount, 700),
+			rule(2, "flat", 5, "", AdjustFixed, 1000),
+			rule(3, "fee", 1, "", AdjustAmount, 50),
+		}, 1050, []string{"surcharge", "flat", "fee"}},
+		{"never below zero", []Rule{
+			rule(1, "voucher", 1, "", AdjustAmount, -5000),
+		}, 0, []string{"voucher"}},
+		{"percent rounds to the nearest tenge", []Rule{
+			rule(1, "third off", 1, "", AdjustPercent, -33.33),
+		}, 1333, []string{"third off"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Apply(Quote{Base: 2000, Price: 2000}, friday, tt.rules)
+			if q.Price != tt.price {
+				t.Errorf("price = %d, want %d", q.Price, tt.price)
+			}
+			if got := applied(q); !reflect.DeepEqual(got, tt.steps) {
+				t.Errorf("applied %v, want %v", got, tt.steps)
+			}
+			if len(q.Steps)+len(q.Skipped) != len(tt.rules) {
+				t.Errorf("%d steps and %d skipped for %d rules", len(q.Steps), len(q.Skipped), len(tt.rules))
+			}
+			for _, s := range q.Steps {
+				if s.After < 0 {
+					t.Errorf("step %+v went negative", s)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRecordsSteps(t *testing.T) {
+	rules := []Rule{
+		rule(1, "low", 1, "", AdjustAmount, 250),
+		rule(2, "high", 2, "", AdjustPercent, -50),
+		rule(3, "also high", 2, "", AdjustFixed, 900),
+	}
+	q := Apply(Quote{Base: 2000, Price: 2400, Steps: []Step{{Name: "vip seat", Before: 2000, After: 2400}}}, friday, rules)
+	want := []Step{
+		{Name: "vip seat", Before: 2000, After: 2400},
+		{RuleID: 2, Name: "high", Adjustment: "-50%", Before: 2400, After: 1200},
+		{RuleID: 3, Name: "also high", Adjustment: "=900", Before: 1200, After: 900},
+		{RuleID: 1, Name: "low", Adjustment: "+250", Before: 900, After: 1150},
+	}
+	if !reflect.DeepEqual(q.Steps, want) {
+		t.Errorf("steps = %+v\nwant    %+v", q.Steps, want)
+	}
+	if rules[0].ID != 1 || rules[1].ID != 2 {
+		t.Error("Apply reordered the caller's rules")
+	}
+}
+
+func TestApplyConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		ctx    func(*Context)
+		reason string // "" when the rule applies
+	}{
+		{"no conditions", Rule{}, nil, ""},
+		{"inactive", Rule{}, nil, "inactive"},
+
+		// Tariffs.
+		{"child tariff", Rule{Tariffs: []string{"child"}}, func(c *Context) { c.Tariff = "child" }, ""},
+		{"adult is not child", Rule{Tariffs: []string{"child"}}, nil, "only for tariffs child"},
+		{"one of several tariffs", Rule{Tariffs: []string{"student", "senior"}}, func(c *Context) { c.Tariff = "senior" }, ""},
+		{"not among several tariffs", Rule{Tariffs: []string{"student", "senior"}}, nil, "only for tariffs student, senior"},
+
+		// Weekdays are those of the session's own zone.
+		{"on friday", Rule{Weekdays: []string{"fri"}}, nil, ""},
+		{"not on friday", Rule{Weekdays: []string{"sat", "sun"}}, nil, "only on sat, sun"},
+		{"friday in UTC is saturday at home", Rule{Weekdays: []string{"sat"}}, func(c *Context) {
+			c.StartsAt = time.Date(2026, 3, 13, 20, 0, 0, 0, time.UTC).In(time.FixedZone("ALMT", 5*3600))
+		}, ""},
+
+		// Start times: after is inclusive, before exclusive.
+		{"starts exactly at after", Rule{StartsAfter: "19:30"}, nil, ""},
+		{"starts before after", Rule{StartsAfter: "19:31"}, nil, "only for sessions from 19:31"},
+		{"starts just before before", Rule{StartsBefore: "19:31"}, nil, ""},
+		{"starts exactly at before", Rule{StartsBefore: "19:30"}, nil, "only for sessions before 19:30"},
+		{"inside a window", Rule{StartsAfter: "18:00", StartsBefore: "22:00"}, nil, ""},
+
+		// Validity is inclusive at both ends and uses the local date.
+		{"first valid day", Rule{ValidFrom: date("2026-03-13")}, nil, ""},
+		{"not valid yet", Rule{ValidFrom: date("2026-03-14")}, nil, "valid from 2026-03-14"},
+		{"last valid day", Rule{ValidUntil: date("2026-03-13")}, nil, ""},
+		{"expired", Rule{ValidUntil: date("2026-03-12")}, nil, "expired on 2026-03-12"},
+
+		// Formats and seat categories.
+		{"3D only", Rule{Formats: []string{"3D", "IMAX"}}, nil, ""},
+		{"2D only", Rule{Formats: []string{"2D"}}, nil, "only for 2D"},
+		{"vip seats", Rule{Categories: []string{models.SeatVIP}}, func(c *Context) { c.Category = models.SeatVIP }, ""},
+		{"not a vip seat", Rule{Categories: []string{models.SeatVIP}}, nil, "only for " + models.SeatVIP + " seats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.rule
+			r.ID, r.Name, r.Adjust, r.Value = 1, "rule", AdjustAmount, -100
+			r.Active = tt.reason != "inactive"
+			ctx := friday
+			if tt.ctx != nil {
+				tt.ctx(&ctx)
+			}
+			q := Apply(Quote{Base: 2000, Price: 2000}, ctx, []Rule{r})
+			if tt.reason == "" {
+				if len(q.Steps) != 1 || q.Price != 1900 {
+					t.Errorf("rule did not apply: skipped %+v", q.Skipped)
+				}
+				return
+			}
+			if len(q.Skipped) != 1 || q.Skipped[0].Reason != tt.reason {
+				t.Errorf("skipped %+v, want reason %q", q.Skipped, tt.reason)
+			}
+			if q.Price != 2000 {
+				t.Errorf("price = %d", q.Price)
+			}
+		})
+	}
+}
+
+func TestApplyTariffs(t *testing.T) {
+	// A typical price list: children and students save, with a lower
+	// priority weekday discount in the same group for everybody else.
+	rules := []Rule{
+		{ID: 1, Name: "child", Priority: 20, Group: "discount", Adjust: AdjustPercent, Value: -50, Tariffs: []string{"child"}, Active: true},
+		{ID: 2, Name: "student", Priority: 20, Group: "discount", Adjust: AdjustAmount, Value: -400, Tariffs: []string{"student"}, Active: true},
+		{ID: 3, Name: "senior", Priority: 20, Group: "discount", Adjust: AdjustFixed, Value: 1000, Tariffs: []string{"senior"}, Active: true},
+		{ID: 4, Name: "friday", Priority: 10, Group: "discount", Adjust: AdjustPercent, Value: -10, Weekdays: []string{"fri"}, Active: true},
+		{ID: 5, Name: "3D", Priority: 0, Adjust: AdjustAmount, Value: 300, Formats: []string{"3D"}, Active: true},
+	}
+	tests := []struct {
+		tariff string
+		price  int
+		steps  []string
+	}{
+		{"adult", 2100, []string{"friday", "3D"}},
+		{"child", 1300, []string{"child", "3D"}},
+		{"student", 1900, []string{"student", "3D"}},
+		{"senior", 1300, []string{"senior", "3D"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tariff, func(t *testing.T) {
+			ctx := friday
+			ctx.Tariff = tt.tariff
+			q := Apply(Quote{Base: 2000, Price: 2000}, ctx, rules)
+			if q.Price != tt.price {
+				t.Errorf("price = %d, want %d", q.Price, tt.price)
+			}
+			if got := applied(q); !reflect.DeepEqual(got, tt.steps) {
+				t.Errorf("applied %v, want %v", got, tt.steps)
+			}
+			for _, s := range q.Skipped {
+				if s.Name == "friday" && tt.tariff != "adult" && !strings.Contains(s.Reason, "already applied in group discount") {
+					t.Errorf("friday skipped for %q", s.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{"percent", Rule{Name: "half", Adjust: AdjustPercent, Value: -50}, ""},
+		{"free", Rule{Name: "free", Adjust: AdjustPercent, Value: -100}, ""},
+		{"name is trimmed", Rule{Name: "  ", Adjust: AdjustAmount}, "name is required"},
+		{"more than all off", Rule{Name: "x", Adjust: AdjustPercent, Value: -101}, "cannot take off more than 100"},
+		{"negative fixed", Rule{Name: "x", Adjust: AdjustFixed, Value: -1}, "cannot be negative"},
+		{"unknown adjust", Rule{Name: "x", Adjust: "double"}, "adjust must be"},
+		{"unknown tariff", Rule{Name: "x", Adjust: AdjustAmount, Tariffs: []string{"pensioner"}}, `tariffs must be among adult, child, student, senior, not "pensioner"`},
+		{"unknown weekday", Rule{Name: "x", Adjust: AdjustAmount, Weekdays: []string{"friday"}}, "weekdays must be among"},
+		{"unknown format", Rule{Name: "x", Adjust: AdjustAmount, Formats: []string{"4DX"}}, "formats must be among"},
+		{"unknown category", Rule{Name: "x", Adjust: AdjustAmount, Categories: []string{"balcony"}}, "categories must be among"},
+		{"bad time", Rule{Name: "x", Adjust: AdjustAmount, StartsAfter: "7pm"}, "times must look like 12:00"},
+		{"period backwards", Rule{Name: "x", Adjust: AdjustAmount, ValidFrom: date("2026-03-02"), ValidUntil: date("2026-03-01")}, "valid_until is before valid_from"},
+		{"one-day period", Rule{Name: "x", Adjust: AdjustAmount, ValidFrom: date("2026-03-01"), ValidUntil: date("2026-03-01")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
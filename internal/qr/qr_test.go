@@ -0,0 +1,223 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The reference symbols were produced by rsc.io/qr/coding for the same
+// version, level M and the mask Encode picked; "#" is a dark module.
+var helloSymbol = []string{
+	"#######.##.#..#######",
+	"#.....#..##.#.#.....#",
+	"#.###.#..####.#.###.#",
+	"#.###.#.#..#..#.###.#",
+	"#.###.#.#...#.#.###.#",
+	"#.....#.#.##..#.....#",
+	"#######.#.#.#.#######",
+	"........#####........",
+	"#...#.######.#####..#",
+	"...###..#.###..#.####",
+	"#.##..#.#.##..###..#.",
+	"###..#...#...##.#....",
+	"..#.###..#..###...##.",
+	"........###.###..#.##",
+	"#######.##..##...#.#.",
+	"#.....#....##..#...#.",
+	"#.###.#.#..#..###.#.#",
+	"#.###.#....##....#.##",
+	"#.###.#..###..####...",
+	"#.....#..#...##......",
+	"#######.#...#####.#.#",
+}
+
+var digits110Symbol = []string{
+	"#######....###.#.#.....#...#..####..#.#######",
+	"#.....#...#.#####....#..####.#...#.#..#.....#",
+	"#.###.#.###.#.#..##.##.#....######.#..#.###.#",
+	"#.###.#.#..#.###..###.#.##.#..#..#.##.#.###.#",
+	"#.###.#.#..#.#.#.#..#####.....##..###.#.###.#",
+	"#.....#.##..##.###..#...##.###.#......#.....#",
+	"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+	"........####.##....##...#.#.#######.#........",
+	"#.#####.....#.#.##.#######.#.##..#.#..#####..",
+	"#.#.....##.##.#...##...##..#..#..#..#..#..###",
+	".#.##.#...#....##..#....######.#..#####..##..",
+	"..#.##..#.#...###.#..#.##..#.######..#..#.#..",
+	"##.#..#....###.#..##..#.#...#......#.#.#.#...",
+	"#..#.#.#.#.##.##.#....##...##.####..#..##.###",
+	"###...#...#######..####.###.##....#.###......",
+	"..#.#..##.#..###.##...#.#...######...#..#.#.#",
+	"#..#######..#.#...#..#.###.#..#..#.#..##.#...",
+	"##..##..##..##.#.#..#..#......#.##..#.....###",
+	".#.######.#.#..#..####..######....##.##..#...",
+	".#####.#..#.##..#.#..#.#.##.######.###..#.##.",
+	"#.#######....####.#######.##.##....#######...",
+	"###.#...#.#.##..##.##...####..#..#.##...#.###",
+	"###.#.#.####.###..###.#.#..###.#..#.#.#.###..",
+	"##..#...#.#.###.###.#...#...#######.#...#.#..",
+	".##.######.....#..#######..#.......#######...",
+	"#...##.#..###.#.####..#.#...#.####..####..###",
+	"#..##.#..#..#.#..#.....#.##..#....##...#.....",
+	"#.##.....#..#.#.#####...#..#.#####.####...#.#",
+	"#.#.####.#.#......#..#...#.#.#...#..##..##.##",
+	"##..##..#.##.###..#.#.#.#.....#.##..##.#..###",
+	".#.####.##..##.....#....######....##....##...",
+	".#..##.##..##..#.#.#####....######.#####..##.",
+	"##.#.##.#....#.##..###....##.##......#..##...",
+	".##.##.##.###..#.#.#..##...#..##.#.#..##.####",
+	"....#.#.####.###....##.#.#####....#.......#..",
+	".####....#######.####...#...######.####...#..",
+	"#..##.#..##.....##..######.#.....##.######...",
+	"........#...#.##.####...#...#.####..#...#.###",
+	"#######...##......###.#.##...#....###.#.#....",
+	"#.....#.##..####....#...#...######.##...#.##.",
+	"#.###.#.##....#.#...######.#.#...#..######..#",
+	"#.###.#.##..###...#.##.#...##.#.##.#.#..#.#.#",
+	"#.###.#.#####.##.###....###..#....#.####.#.#.",
+	"#.....#..#.....##..##..#....#..###..#..#..#..",
+	"#######.#.##.#.#........#.##..#......##..#.#.",
+}
+
+// digits is the first n bytes of "0123456789" repeated.
+func digits(n int) []byte {
+	return []byte(strings.Repeat("0123456789", 22)[:n])
+}
+
+func rows(c *Code) []string {
+	out := make([]string, c.Size)
+	for y := range out {
+		var b strings.Builder
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		out[y] = b.String()
+	}
+	return out
+}
+
+func TestEncodeMatchesReference(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{name: "version 1", data: []byte("HELLO"), want: helloSymbol},
+		{name: "version 7", data: digits(110), want: digits110Symbol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Encode(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := rows(c)
+			if len(got) != len(tt.want) {
+				t.Fatalf("size = %d, want %d", len(got), len(tt.want))
+			}
+			for y := range got {
+				if got[y] != tt.want[y] {
+					t.Errorf("row %d:\n got %s\nwant %s", y, got[y], tt.want[y])
+				}
+			}
+		})
+	}
+}
+
+func TestCodewords(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte // data codewords before error correction
+		want []byte
+	}{
+		// Byte mode "HELLO": 0100, a count of 5, the bytes, the terminator
+		// and EC 11 padding.
+		{
+			name: "HELLO",
+			data: dataCodewords(8, 16, []byte("HELLO")),
+			want: []byte{
+				0x40, 0x54, 0x84, 0x54, 0xC4, 0xC4, 0xF0, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC,
+				0x23, 0x73, 0x23, 0x99, 0xEC, 0x08, 0xC9, 0xF7, 0x37, 0xDF,
+			},
+		},
+		// The numeric "01234567" example of ISO/IEC 18004 annex I; only the
+		// error correction is computed here.
+		{
+			name: "ISO 18004 example",
+			data: []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11},
+			want: []byte{
+				0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11,
+				0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interleave(1, tt.data); !bytes.Equal(got, tt.want) {
+				t.Errorf("codewords = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	c, err := Encode(digits(110))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 45 {
+		t.Fatalf("size = %d, want 45 (version 7)", c.Size)
+	}
+	// Both copies of the 18 version bits, least significant first; 0x07C94
+	// is version 7 with its BCH code from the standard's table.
+	var below, right int
+	for i := 0; i < 18; i++ {
+		if c.Dark(i/3, c.Size-11+i%3) {
+			below |= 1 << i
+		}
+		if c.Dark(c.Size-11+i%3, i/3) {
+			right |= 1 << i
+		}
+	}
+	if below != 0x07C94 || right != 0x07C94 {
+		t.Errorf("version info = %#05x and %#05x, want 0x07c94", below, right)
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		n       int
+		version int
+	}{
+		{0, 1},
+		{14, 1},
+		{15, 2},
+		{106, 6},
+		{107, 7},
+		{180, 9},
+		{181, 10}, // the count takes 16 bits from version 10
+		{213, 10},
+	}
+	for _, tt := range tests {
+		c, err := Encode(digits(tt.n))
+		if err != nil {
+			t.Errorf("%d bytes: %v", tt.n, err)
+			continue
+		}
+		if got := (c.Size - 17) / 4; got != tt.version {
+			t.Errorf("%d bytes: version %d, want %d", tt.n, got, tt.version)
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(digits(214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("214 bytes: err = %v, want ErrTooLong", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package seating picks the best block of adjacent free seats in a hall.
+//
+// Halls are numbered row by row from the screen: seat 1 is the first seat
+// of row 1, and every row but possibly the last has PerRow seats. Each
+// candidate block gets a cost from three weighted terms, all between 0 and
+// 1 per unit of weight; the cheapest blocks win.
+//
+//   - Center: how far the block's middle is from the middle of its row.
+//   - Row: how far its row is from the ideal distance to the screen.
+//   - Gap: how many single free seats the block would strand next to it,
+//     which are hard to sell later.
+package seating
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Scoring weighs the terms of a block's cost. IdealRow is the preferred
+// distance from the screen as a fraction of the hall: 0 is the front row
+// and 1 the back row.
+type Scoring struct {
+	Center   float64 `json:"center"`
+	Row      float64 `json:"row"`
+	Gap      float64 `json:"gap"`
+	IdealRow float64 `json:"ideal_row"`
+}
+
+// DefaultScoring favours central seats a little behind the middle of the
+// hall and avoids stranding single seats.
+var DefaultScoring = Scoring{Center: 1, Row: 1, Gap: 0.5, IdealRow: 0.6}
+
+func (s Scoring) Validate() error {
+	if s.Center < 0 || s.Row < 0 || s.Gap < 0 {
+		return errors.New("weights cannot be negative")
+	}
+	if s.IdealRow < 0 || s.IdealRow > 1 {
+		return errors.New("ideal_row must be between 0 and 1")
+	}
+	return nil
+}
+
+// Hall is a seat map: Taken[i] tells whether seat i+1 can not be sold.
+type Hall struct {
+	PerRow int
+	Taken  []bool
+}
+
+func (h Hall) rows() int { return (len(h.Taken) + h.PerRow - 1) / h.PerRow }
+
+// rowSeats returns the first seat and the length of a row (1-based).
+func (h Hall) rowSeats(row int) (first, n int) {
+	first = (row-1)*h.PerRow + 1
+	return first, min(h.PerRow, len(h.Taken)-first+1)
+}
+
+func (h Hall) free(seat int) bool { return seat >= 1 && seat <= len(h.Taken) && !h.Taken[seat-1] }
+
+// Block is a run of adjacent seats in one row.
+type Block struct {
+	Row   int     `json:"row"`
+	Seats []int   `json:"seats"`
+	Cost  float64 `json:"cost"`
+}
+
+// Best returns up to limit blocks of party adjacent free seats, cheapest
+// first. Blocks never overlap, so each one is a real alternative.
+func Best(h Hall, party, limit int, sc Scoring) []Block {
+	if h.PerRow <= 0 || party <= 0 || limit <= 0 {
+		return nil
+	}
+	rows := h.rows()
+	var candidates []Block
+	for row := 1; row <= rows; row++ {
+		first, n := h.rowSeats(row)
+		for start := 0; start+party <= n; start++ {
+			ok := true
+			for i := start; i < start+party; i++ {
+				if !h.free(first + i) {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+			b := Block{Row: row, Seats: make([]int, party)}
+			for i := range party {
+				b.Seats[i] = first + start + i
+			}
+			b.Cost = sc.cost(h, row, rows, first, n, start, party)
+			candidates = append(candidates, b)
+		}
+	}
+	// Ties go to the front-most, then left-most block so results are stable.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Cost != candidates[j].Cost {
+			return candidates[i].Cost < candidates[j].Cost
+		}
+		return candidates[i].Seats[0] < candidates[j].Seats[0]
+	})
+
+	used := map[int]bool{}
+	var best []Block
+	for _, b := range candidates {
+		if len(best) == limit {
+			break
+		}
+		if used[b.Seats[0]] || used[b.Seats[len(b.Seats)-1]] {
+			continue
+		}
+		for _, s := range b.Seats {
+			used[s] = true
+		}
+		best = append(best, b)
+	}
+	return best
+}
+
+func (sc Scoring) cost(h Hall, row, rows, first, n, start, party int) float64 {
+	var center float64
+	if n > party {
+		mid := float64(n-1) / 2
+		blockMid := float64(start) + float64(party-1)/2
+		center = math.Abs(blockMid-mid) / mid
+	}
+
+	var rowDist float64
+	if rows > 1 {
+		rowDist = math.Abs(float64(row-1)/float64(rows-1) - sc.IdealRow)
+	}
+
+	// A side strands a seat when the one next to the block is free but
+	// the one after it is not (or is the wall).
+	var gaps float64
+	left, right := first+start-1, first+start+party
+	if start >= 1 && h.free(left) && (start == 1 || !h.free(left-1)) {
+		gaps++
+	}
+	if start+party < n && h.free(right) && (start+party == n-1 || !h.free(right+1)) {
+		gaps++
+	}
+
+	return round(sc.Center*center + sc.Row*rowDist + sc.Gap*gaps/2)
+}
+
+func round(f float64) float64 { return math.Round(f*1000) / 1000 }
@@ -0,0 +1,142 @@
+package seating
+
+import (
+	"reflect"
+	"testing"
+)
+
+// hall builds a seat map of n seats with the given seats taken.
+func hall(perRow, n int, taken ...int) Hall {
+	h := Hall{PerRow: perRow, Taken: make([]bool, n)}
+	for _, s := range taken {
+		h.Taken[s-1] = true
+	}
+	return h
+}
+
+func TestCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		h          Hall
+		sc         Scoring
+		row, start int
+		party      int
+		want       float64
+	}{
+		// Center: distance of the block's middle from the row's middle,
+		// 1 at the wall.
+		{name: "center of row", h: hall(5, 15), sc: Scoring{Center: 1}, row: 2, start: 2, party: 1, want: 0},
+		{name: "aisle seat", h: hall(5, 15), sc: Scoring{Center: 1}, row: 2, start: 0, party: 1, want: 1},
+		{name: "pair off center", h: hall(5, 15), sc: Scoring{Center: 1}, row: 2, start: 0, party: 2, want: 0.75},
+		{name: "whole row is central", h: hall(5, 15), sc: Scoring{Center: 1}, row: 2, start: 0, party: 5, want: 0},
+		// Row: distance from IdealRow as a fraction of the hall depth.
+		{name: "ideal row", h: hall(5, 15), sc: Scoring{Row: 1, IdealRow: 0.5}, row: 2, start: 0, party: 1, want: 0},
+		{name: "front row", h: hall(5, 15), sc: Scoring{Row: 1, IdealRow: 0.5}, row: 1, start: 0, party: 1, want: 0.5},
+		{name: "back row", h: hall(5, 15), sc: Scoring{Row: 1, IdealRow: 0.5}, row: 3, start: 0, party: 1, want: 0.5},
+		{name: "single-row hall", h: hall(5, 5), sc: Scoring{Row: 1, IdealRow: 0}, row: 1, start: 0, party: 1, want: 0},
+		// Gap: half a unit for every single seat stranded beside the block.
+		{name: "no stranded seat", h: hall(5, 5), sc: Scoring{Gap: 1}, row: 1, start: 0, party: 2, want: 0},
+		{name: "strands the aisle seat", h: hall(5, 5), sc: Scoring{Gap: 1}, row: 1, start: 1, party: 2, want: 0.5},
+		{name: "strands both ends", h: hall(5, 5), sc: Scoring{Gap: 1}, row: 1, start: 1, party: 3, want: 1},
+		{name: "strands a seat next to a taken one", h: hall(5, 5, 1), sc: Scoring{Gap: 1}, row: 1, start: 2, party: 3, want: 0.5},
+		{name: "taken neighbour is no gap", h: hall(5, 5, 5), sc: Scoring{Gap: 1}, row: 1, start: 2, party: 2, want: 0},
+		// The terms add up with their weights.
+		{name: "weighted sum", h: hall(5, 15), sc: Scoring{Center: 2, Row: 0.5, Gap: 1, IdealRow: 0.5}, row: 1, start: 1, party: 2, want: 2*0.25 + 0.5*0.5 + 1*0.5},
+		{name: "rounded to thousandths", h: hall(6, 24), sc: Scoring{Row: 1, IdealRow: 0.6}, row: 2, start: 0, party: 1, want: 0.267},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, n := tt.h.rowSeats(tt.row)
+			got := tt.sc.cost(tt.h, tt.row, tt.h.rows(), first, n, tt.start, tt.party)
+			if got != tt.want {
+				t.Errorf("cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func seats(blocks []Block) [][]int {
+	out := [][]int{}
+	for _, b := range blocks {
+		out = append(out, b.Seats)
+	}
+	return out
+}
+
+func TestBest(t *testing.T) {
+	tests := []struct {
+		name         string
+		h            Hall
+		party, limit int
+		sc           Scoring
+		want         [][]int
+	}{
+		{"middle out, left before right on ties", hall(5, 5), 1, 5, Scoring{Center: 1},
+			[][]int{{3}, {2}, {4}, {1}, {5}}},
+		{"alternatives do not overlap", hall(6, 6), 2, 3, Scoring{Center: 1},
+			[][]int{{3, 4}, {1, 2}, {5, 6}}},
+		{"avoids stranding a seat", hall(5, 5, 5), 2, 3, Scoring{Gap: 1},
+			[][]int{{1, 2}, {3, 4}}},
+		{"closest to the ideal row first", hall(2, 6), 2, 3, Scoring{Row: 1, IdealRow: 1},
+			[][]int{{5, 6}, {3, 4}, {1, 2}}},
+		{"skips taken seats", hall(4, 8, 2, 7), 2, 5, Scoring{Center: 1},
+			[][]int{{3, 4}, {5, 6}}},
+		{"short last row", hall(4, 6), 3, 5, Scoring{Center: 1},
+			[][]int{{1, 2, 3}}},
+		{"no room for the party", hall(3, 6, 2, 5), 2, 5, DefaultScoring, [][]int{}},
+		{"party larger than a row", hall(4, 16), 5, 5, DefaultScoring, [][]int{}},
+		{"sold out", hall(2, 2, 1, 2), 1, 5, DefaultScoring, [][]int{}},
+		{"zero limit", hall(4, 16), 1, 0, DefaultScoring, [][]int{}},
+		{"zero party", hall(4, 16), 0, 5, DefaultScoring, [][]int{}},
+		{"no row length", Hall{Taken: make([]bool, 4)}, 1, 5, DefaultScoring, [][]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := seats(Best(tt.h, tt.party, tt.limit, tt.sc))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Best = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestDefaultScoring(t *testing.T) {
+	// Four rows of six: the third row is nearest to 0.6 of the depth.
+	best := Best(hall(6, 24), 2, 3, DefaultScoring)
+	if len(best) != 3 {
+		t.Fatalf("got %d blocks", len(best))
+	}
+	want := Block{Row: 3, Seats: []int{15, 16}, Cost: 0.067}
+	if !reflect.DeepEqual(best[0], want) {
+		t.Errorf("best = %+v, want %+v", best[0], want)
+	}
+	for i := 1; i < len(best); i++ {
+		if best[i].Cost < best[i-1].Cost {
+			t.Errorf("blocks not cheapest first: %+v", best)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sc      Scoring
+		wantErr bool
+	}{
+		{"default", DefaultScoring, false},
+		{"all zero", Scoring{}, false},
+		{"ideal back row", Scoring{IdealRow: 1}, false},
+		{"negative center", Scoring{Center: -1}, true},
+		{"negative row", Scoring{Row: -0.1}, true},
+		{"negative gap", Scoring{Gap: -2}, true},
+		{"ideal row beyond the back", Scoring{IdealRow: 1.1}, true},
+		{"ideal row before the front", Scoring{IdealRow: -0.1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sc.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Package ticketcheck holds everything a ticket scanner needs without the
+// server: the signed payload printed in ticket QR codes, the signed
+// per-session manifest and an offline validator that keeps a scan log for
+// later upload.
+package ticketcheck
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PayloadPrefix versions the QR payload format:
+// T1.<ticket>.<session>.<seat>.<expires unix>.<ed25519 signature>
+const PayloadPrefix = "T1"
+
+// Reasons a scan is rejected.
+const (
+	ReasonForged       = "forged"
+	ReasonNotFound     = "not_found"
+	ReasonWrongSession = "wrong_session"
+	ReasonCancelled    = "cancelled"
+	ReasonAlreadyUsed  = "already_used"
+	ReasonTooEarly     = "too_early"
+	ReasonExpired      = "expired"
+)
+
+var ErrForged = errors.New("ticket payload is forged or damaged")
+
+type Claims struct {
+	TicketID  int       `json:"ticket_id"`
+	SessionID int       `json:"session_id"`
+	Seat      int       `json:"seat"`
+	Expires   time.Time `json:"expires"`
+}
+
+// SignedPart is the text the signature covers.
+func (c Claims) SignedPart() string {
+	return fmt.Sprintf("%s.%d.%d.%d.%d", PayloadPrefix, c.TicketID, c.SessionID, c.Seat, c.Expires.Unix())
+}
+
+// Sign returns the text encoded in the ticket's QR code.
+func Sign(private ed25519.PrivateKey, c Claims) string {
+	msg := c.SignedPart()
+	return msg + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(private, []byte(msg)))
+}
+
+// Verify checks the signature and returns the claims. Expiry is left to the
+// caller, which knows the time that matters.
+func Verify(public ed25519.PublicKey, payload string) (Claims, error) {
+	var c Claims
+	parts := strings.Split(strings.TrimSpace(payload), ".")
+	if len(parts) != 6 || parts[0] != PayloadPrefix {
+		return c, ErrForged
+	}
+	nums := make([]int64, 4)
+	for i := range nums {
+		n, err := strconv.ParseInt(parts[i+1], 10, 64)
+		if err != nil {
+			return c, ErrForged
+		}
+		nums[i] = n
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[5])
+	if err != nil {
+		return c, ErrForged
+	}
+	c = Claims{TicketID: int(nums[0]), SessionID: int(nums[1]), Seat: int(nums[2]), Expires: time.Unix(nums[3], 0)}
+	if c.SignedPart() != strings.Join(parts[:5], ".") || !ed25519.Verify(public, []byte(c.SignedPart()), sig) {
+		return Claims{}, ErrForged
+	}
+	return c, nil
+}
+
+// Ticket states in a manifest.
+const (
+	StatusValid     = "valid"
+	StatusUsed      = "used"
+	StatusCancelled = "cancelled"
+)
+
+type ManifestTicket struct {
+	TicketID int    `json:"ticket_id"`
+	Seat     int    `json:"seat"`
+	Status   string `json:"status"`
+}
+
+// Manifest lists every ticket of one session as of IssuedAt.
+type Manifest struct {
+	SessionID int              `json:"session_id"`
+	StartsAt  time.Time        `json:"starts_at"`
+	EndsAt    time.Time        `json:"ends_at"`
+	OpensAt   time.Time        `json:"opens_at"` // doors open, scans before are too early
+	IssuedAt  time.Time        `json:"issued_at"`
+	Tickets   []ManifestTicket `json:"tickets"`
+}
+
+// SignedManifest is what scanners download. Signature covers the exact bytes
+// of Manifest, so it is kept raw.
+type SignedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature string          `json:"signature"`
+}
+
+func SignManifest(private ed25519.PrivateKey, m Manifest) (SignedManifest, error) {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return SignedManifest{}, err
+	}
+	return SignedManifest{Manifest: raw, Signature: base64.RawURLEncoding.EncodeToString(ed25519.Sign(private, raw))}, nil
+}
+
+func VerifyManifest(public ed25519.PublicKey, sm SignedManifest) (Manifest, error) {
+	var m Manifest
+	sig, err := base64.RawURLEncoding.DecodeString(sm.Signature)
+	if err != nil || !ed25519.Verify(public, sm.Manifest, sig) {
+		return m, errors.New("manifest signature does not verify")
+	}
+	err = json.Unmarshal(sm.Manifest, &m)
+	return m, err
+}
+
+// Scan is one entry of a scanner's log. ScanID is unique per device so a log
+// can be uploaded more than once.
+type Scan struct {
+	ScanID    string    `json:"scan_id"`
+	Payload   string    `json:"payload"`
+	SessionID int       `json:"session_id"`
+	ScannedAt time.Time `json:"scanned_at"`
+	Accepted  bool      `json:"accepted"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Validator checks scans against a manifest with no network. It remembers
+// what it admitted, but cannot know about other devices; the server sorts
+// that out when logs are uploaded.
+type Validator struct {
+	public   ed25519.PublicKey
+	manifest Manifest
+	tickets  map[int]ManifestTicket
+	used     map[int]time.Time
+	log      []Scan
+}
+
+func NewValidator(public ed25519.PublicKey, sm SignedManifest) (*Validator, error) {
+	m, err := VerifyManifest(public, sm)
+	if err != nil {
+		return nil, err
+	}
+	v := &Validator{public: public, manifest: m, tickets: map[int]ManifestTicket{}, used: map[int]time.Time{}}
+	for _, t := range m.Tickets {
+		v.tickets[t.TicketID] = t
+	}
+	return v, nil
+}
+
+// Check validates a scanned payload at now and logs the outcome.
+func (v *Validator) Check(payload string, now time.Time) Scan {
+	scan := Scan{
+		ScanID:    fmt.Sprintf("%d-%d", now.UnixNano(), len(v.log)+1),
+		Payload:   payload,
+		SessionID: v.manifest.SessionID,
+		ScannedAt: now,
+	}
+	scan.Reason = v.reject(payload, now)
+	scan.Accepted = scan.Reason == ""
+	if scan.Accepted {
+		c, _ := Verify(v.public, payload)
+		v.used[c.TicketID] = now
+	}
+	v.log = append(v.log, scan)
+	return scan
+}
+
+func (v *Validator) reject(payload string, now time.Time) string {
+	c, err := Verify(v.public, payload)
+	if err != nil {
+		return ReasonForged
+	}
+	if c.SessionID != v.manifest.SessionID {
+		return ReasonWrongSession
+	}
+	t, ok := v.tickets[c.TicketID]
+	switch {
+	case !ok:
+		return ReasonNotFound
+	case t.Seat != c.Seat:
+		return ReasonForged
+	case t.Status == StatusCancelled:
+		return ReasonCancelled
+	case t.Status == StatusUsed:
+		return ReasonAlreadyUsed
+	case now.Before(v.manifest.OpensAt):
+		return ReasonTooEarly
+	case now.After(v.manifest.EndsAt) || now.After(c.Expires):
+		return ReasonExpired
+	}
+	if _, done := v.used[c.TicketID]; done {
+		return ReasonAlreadyUsed
+	}
+	return ""
+}
+
+// Log returns the scans so far, oldest first, ready for upload.
+func (v *Validator) Log() []Scan {
+	return append([]Scan(nil), v.log...)
+}
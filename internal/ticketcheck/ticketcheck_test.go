@@ -0,0 +1,212 @@
+package ticketcheck
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	opens  = time.Date(2026, 3, 14, 18, 30, 0, 0, time.UTC)
+	starts = opens.Add(time.Hour)
+	ends   = starts.Add(2 * time.Hour)
+)
+
+func testKeys(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func testManifest() Manifest {
+	return Manifest{
+		SessionID: 7,
+		StartsAt:  starts,
+		EndsAt:    ends,
+		OpensAt:   opens,
+		IssuedAt:  opens.Add(-time.Hour),
+		Tickets: []ManifestTicket{
+			{TicketID: 1, Seat: 10, Status: StatusValid},
+			{TicketID: 2, Seat: 11, Status: StatusCancelled},
+			{TicketID: 3, Seat: 12, Status: StatusUsed},
+			{TicketID: 4, Seat: 13, Status: StatusValid},
+		},
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	pub, priv := testKeys(t)
+	otherPub, otherPriv := testKeys(t)
+	claims := Claims{TicketID: 1, SessionID: 7, Seat: 10, Expires: ends}
+	good := Sign(priv, claims)
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		payload string
+		wantErr bool
+	}{
+		{"valid", pub, good, false},
+		{"surrounding whitespace", pub, " " + good + "\n", false},
+		{"seat changed", pub, strings.Replace(good, ".7.10.", ".7.11.", 1), true},
+		{"session changed", pub, strings.Replace(good, ".7.10.", ".8.10.", 1), true},
+		{"expiry pushed back", pub, strings.Replace(good, claims.SignedPart(), Claims{TicketID: 1, SessionID: 7, Seat: 10, Expires: ends.Add(time.Hour)}.SignedPart(), 1), true},
+		{"leading zero", pub, strings.Replace(good, "T1.1.", "T1.01.", 1), true},
+		{"signed by another key", pub, Sign(otherPriv, claims), true},
+		{"checked with another key", otherPub, good, true},
+		{"signature cut", pub, good[:len(good)-4], true},
+		{"wrong version", pub, "T2" + good[2:], true},
+		{"not a payload", pub, "hello", true},
+		{"empty", pub, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Verify(tt.key, tt.payload)
+			if tt.wantErr {
+				if err != ErrForged {
+					t.Fatalf("err = %v, want ErrForged", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.TicketID != 1 || c.SessionID != 7 || c.Seat != 10 || !c.Expires.Equal(ends) {
+				t.Errorf("claims = %+v", c)
+			}
+		})
+	}
+}
+
+func TestValidatorCheck(t *testing.T) {
+	pub, priv := testKeys(t)
+	_, otherPriv := testKeys(t)
+	sm, err := SignManifest(priv, testManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := func(ticket, session, seat int, expires time.Time) string {
+		return Sign(priv, Claims{TicketID: ticket, SessionID: session, Seat: seat, Expires: expires})
+	}
+	during := starts.Add(10 * time.Minute)
+
+	tests := []struct {
+		name    string
+		payload string
+		at      time.Time
+		reason  string
+	}{
+		{"valid", payload(1, 7, 10, ends), during, ""},
+		{"valid when doors open", payload(1, 7, 10, ends), opens, ""},
+		{"tampered", strings.Replace(payload(1, 7, 10, ends), ".10.", ".11.", 1), during, ReasonForged},
+		{"foreign key", Sign(otherPriv, Claims{TicketID: 1, SessionID: 7, Seat: 10, Expires: ends}), during, ReasonForged},
+		{"seat not as in manifest", payload(1, 7, 14, ends), during, ReasonForged},
+		{"wrong session", payload(1, 8, 10, ends), during, ReasonWrongSession},
+		{"not in manifest", payload(99, 7, 10, ends), during, ReasonNotFound},
+		{"revoked in manifest", payload(2, 7, 11, ends), during, ReasonCancelled},
+		{"used before the manifest", payload(3, 7, 12, ends), during, ReasonAlreadyUsed},
+		{"too early", payload(1, 7, 10, ends), opens.Add(-time.Second), ReasonTooEarly},
+		{"session over", payload(1, 7, 10, ends.Add(time.Hour)), ends.Add(time.Second), ReasonExpired},
+		{"payload expired", payload(1, 7, 10, starts), starts.Add(time.Second), ReasonExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewValidator(pub, sm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scan := v.Check(tt.payload, tt.at)
+			if scan.Reason != tt.reason || scan.Accepted != (tt.reason == "") {
+				t.Errorf("accepted %v, reason %q; want reason %q", scan.Accepted, scan.Reason, tt.reason)
+			}
+			if scan.SessionID != 7 || !scan.ScannedAt.Equal(tt.at) || scan.Payload != tt.payload {
+				t.Errorf("scan = %+v", scan)
+			}
+		})
+	}
+}
+
+func TestValidatorAdmitsOnce(t *testing.T) {
+	pub, priv := testKeys(t)
+	sm, err := SignManifest(priv, testManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(pub, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Sign(priv, Claims{TicketID: 4, SessionID: 7, Seat: 13, Expires: ends})
+	first := v.Check(p, starts)
+	second := v.Check(p, starts.Add(time.Minute))
+	if !first.Accepted || second.Accepted || second.Reason != ReasonAlreadyUsed {
+		t.Fatalf("first %+v, second %+v", first, second)
+	}
+	log := v.Log()
+	if len(log) != 2 || log[0].ScanID == log[1].ScanID {
+		t.Fatalf("log = %+v", log)
+	}
+	log[0].Accepted = false
+	if !v.Log()[0].Accepted {
+		t.Error("Log returned the validator's own slice")
+	}
+}
+
+func TestManifestSignature(t *testing.T) {
+	pub, priv := testKeys(t)
+	otherPub, otherPriv := testKeys(t)
+	sm, err := SignManifest(priv, testManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reinstating the cancelled ticket in the manifest must break it.
+	tampered := sm
+	tampered.Manifest = bytes.Replace(sm.Manifest, []byte(`"status":"cancelled"`), []byte(`"status":"valid"`), 1)
+	if bytes.Equal(tampered.Manifest, sm.Manifest) {
+		t.Fatal("test manifest has no cancelled ticket")
+	}
+	foreign, err := SignManifest(otherPriv, testManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		sm      SignedManifest
+		wantErr bool
+	}{
+		{"valid", pub, sm, false},
+		{"tampered", pub, tampered, true},
+		{"signed by another key", pub, foreign, true},
+		{"checked with another key", otherPub, sm, true},
+		{"signature not base64", pub, SignedManifest{Manifest: sm.Manifest, Signature: "!!"}, true},
+		{"unsigned", pub, SignedManifest{Manifest: sm.Manifest}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := VerifyManifest(tt.key, tt.sm)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("manifest verified")
+				}
+				if _, err := NewValidator(tt.key, tt.sm); err == nil {
+					t.Error("validator accepted the manifest")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.SessionID != 7 || len(m.Tickets) != 4 || !m.OpensAt.Equal(opens) {
+				t.Errorf("manifest = %+v", m)
+			}
+		})
+	}
+}
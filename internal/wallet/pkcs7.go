@@ -0,0 +1,236 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Apple wants manifest.json signed as a detached PKCS#7 (CMS) SignedData
+// with the signer and WWDR certificates attached. Only what that needs is
+// implemented: SHA-256, RSA or ECDSA keys, one signer.
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// der wraps the concatenated parts in a tag-length-value.
+func der(tag byte, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	n := len(body)
+	out := []byte{tag}
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	case n < 0x10000:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
+
+const (
+	tagSequence = 0x30
+	tagSet      = 0x31
+	tagContext0 = 0xa0
+)
+
+func mustMarshal(v any) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func algorithm(oid asn1.ObjectIdentifier, withNull bool) []byte {
+	if withNull {
+		return der(tagSequence, mustMarshal(oid), asn1.NullBytes)
+	}
+	return der(tagSequence, mustMarshal(oid))
+}
+
+// setOf joins the elements of a SET OF in the sorted order DER requires.
+func setOf(elems ...[]byte) []byte {
+	sorted := append([][]byte(nil), elems...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return bytes.Join(sorted, nil)
+}
+
+func attribute(oid asn1.ObjectIdentifier, value []byte) []byte {
+	return der(tagSequence, mustMarshal(oid), der(tagSet, value))
+}
+
+// signDetached returns a DER SignedData over content without the content
+// itself. chain holds extra certificates to embed, such as Apple's WWDR.
+func signDetached(content []byte, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate, now time.Time) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	attrs := setOf(
+		attribute(oidContentType, mustMarshal(oidData)),
+		attribute(oidSigningTime, mustMarshal(now.UTC())),
+		attribute(oidMessageDigest, mustMarshal(digest[:])),
+	)
+	// The signature covers the attributes tagged as a SET; SignerInfo stores
+	// the same bytes under an implicit [0].
+	attrsDigest := sha256.Sum256(der(tagSet, attrs))
+
+	var sigAlg []byte
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = algorithm(oidRSA, true)
+	case *ecdsa.PublicKey:
+		sigAlg = algorithm(oidECDSASHA256, false)
+	default:
+		return nil, errors.New("wallet: signing key must be RSA or ECDSA")
+	}
+	sig, err := key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfo := der(tagSequence,
+		mustMarshal(1),
+		der(tagSequence, cert.RawIssuer, mustMarshal(cert.SerialNumber)),
+		algorithm(oidSHA256, true),
+		der(tagContext0, attrs),
+		sigAlg,
+		mustMarshal(sig),
+	)
+	certs := [][]byte{cert.Raw}
+	for _, c := range chain {
+		certs = append(certs, c.Raw)
+	}
+	signedData := der(tagSequence,
+		mustMarshal(1),
+		der(tagSet, algorithm(oidSHA256, true)),
+		der(tagSequence, mustMarshal(oidData)),
+		der(tagContext0, certs...),
+		der(tagSet, signerInfo),
+	)
+	return der(tagSequence, mustMarshal(oidSignedData), der(tagContext0, signedData)), nil
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	IssuerAndSerial    issuerAndSerial
+	DigestAlgorithm    asn1.RawValue
+	SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+	SignatureAlgorithm asn1.RawValue
+	Signature          []byte
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signedAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// VerifyDetached checks a signature made by signDetached (or by Apple's
+// signpass tool) over content and returns the signer's certificate. When
+// roots is not nil the certificate must also chain up to one of them.
+func VerifyDetached(content, signature []byte, roots *x509.CertPool) (*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(signature, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("wallet: not a SignedData")
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.New("wallet: expected exactly one signer")
+	}
+	si := sd.SignerInfos[0]
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var signer *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			signer = c
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("wallet: signer certificate missing")
+	}
+
+	signedAttrs := der(tagSet, si.SignedAttrs.Bytes)
+	var attrs []signedAttribute
+	if _, err := asn1.UnmarshalWithParams(signedAttrs, &attrs, "set"); err != nil {
+		return nil, err
+	}
+	var digest []byte
+	for _, a := range attrs {
+		if a.Type.Equal(oidMessageDigest) {
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &digest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	want := sha256.Sum256(content)
+	if !bytes.Equal(digest, want[:]) {
+		return nil, errors.New("wallet: content does not match the signed digest")
+	}
+
+	alg := x509.SHA256WithRSA
+	if _, ok := signer.PublicKey.(*ecdsa.PublicKey); ok {
+		alg = x509.ECDSAWithSHA256
+	}
+	if err := signer.CheckSignature(alg, signedAttrs, si.Signature); err != nil {
+		return nil, err
+	}
+	if roots != nil {
+		_, err := signer.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
@@ -0,0 +1,356 @@
+// Package wallet turns tickets into phone wallet passes: signed Apple Wallet
+// .pkpass bundles and Google Wallet "save" links.
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Ticket is what both wallets show; the caller fills it from its own models.
+type Ticket struct {
+	ID           int
+	OrderID      int
+	SessionID    int
+	Holder       string
+	Movie        string
+	Hall         string
+	Row          int
+	Seat         int
+	Price        int
+	Currency     string
+	OpensAt      time.Time // doors open
+	StartsAt     time.Time
+	EndsAt       time.Time
+	Expires      time.Time
+	Barcode      string // the signed payload scanned at the doors
+	Voided       bool   // cancelled or already used
+	Organization string
+}
+
+type Barcode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+	AltText         string `json:"altText,omitempty"`
+}
+
+type Field struct {
+	Key           string `json:"key"`
+	Label         string `json:"label,omitempty"`
+	Value         any    `json:"value"`
+	DateStyle     string `json:"dateStyle,omitempty"`
+	TimeStyle     string `json:"timeStyle,omitempty"`
+	ChangeMessage string `json:"changeMessage,omitempty"` // shown when an update changes the value
+}
+
+type PassStructure struct {
+	HeaderFields    []Field `json:"headerFields,omitempty"`
+	PrimaryFields   []Field `json:"primaryFields,omitempty"`
+	SecondaryFields []Field `json:"secondaryFields,omitempty"`
+	AuxiliaryFields []Field `json:"auxiliaryFields,omitempty"`
+	BackFields      []Field `json:"backFields,omitempty"`
+}
+
+// Pass is pass.json, limited to what an event ticket uses.
+type Pass struct {
+	FormatVersion      int            `json:"formatVersion"`
+	PassTypeIdentifier string         `json:"passTypeIdentifier"`
+	TeamIdentifier     string         `json:"teamIdentifier"`
+	SerialNumber       string         `json:"serialNumber"`
+	OrganizationName   string         `json:"organizationName"`
+	Description        string         `json:"description"`
+	LogoText           string         `json:"logoText,omitempty"`
+	ForegroundColor    string         `json:"foregroundColor,omitempty"`
+	BackgroundColor    string         `json:"backgroundColor,omitempty"`
+	LabelColor         string         `json:"labelColor,omitempty"`
+	RelevantDate       *time.Time     `json:"relevantDate,omitempty"`
+	ExpirationDate     *time.Time     `json:"expirationDate,omitempty"`
+	Voided             bool           `json:"voided,omitempty"`
+	Barcodes           []Barcode      `json:"barcodes"`
+	EventTicket        *PassStructure `json:"eventTicket"`
+}
+
+// EventPass describes t as an Apple event ticket. The pass is rebuilt on
+// every download, so a moved session gets a fresh relevant date, and the
+// barcode always carries the current signed payload.
+func EventPass(t Ticket) Pass {
+	// Wallet wants plain W3C dates, without fractional seconds.
+	startsAt, expires := t.StartsAt.Truncate(time.Second), t.Expires.Truncate(time.Second)
+	p := Pass{
+		FormatVersion:    1,
+		SerialNumber:     fmt.Sprintf("ticket-%d", t.ID),
+		OrganizationName: t.Organization,
+		Description:      "Cinema ticket: " + t.Movie,
+		LogoText:         t.Organization,
+		ForegroundColor:  "rgb(255, 255, 255)",
+		BackgroundColor:  "rgb(20, 20, 30)",
+		LabelColor:       "rgb(200, 200, 210)",
+		RelevantDate:     &startsAt,
+		ExpirationDate:   &expires,
+		Voided:           t.Voided,
+		Barcodes: []Barcode{{
+			Format:          "PKBarcodeFormatQR",
+			Message:         t.Barcode,
+			MessageEncoding: "iso-8859-1",
+			AltText:         fmt.Sprintf("Ticket #%d", t.ID),
+		}},
+		EventTicket: &PassStructure{
+			HeaderFields:  []Field{{Key: "hall", Label: "HALL", Value: t.Hall}},
+			PrimaryFields: []Field{{Key: "movie", Label: "MOVIE", Value: t.Movie}},
+			SecondaryFields: []Field{{
+				Key: "starts", Label: "SESSION", Value: t.StartsAt.Format(time.RFC3339),
+				DateStyle: "PKDateStyleMedium", TimeStyle: "PKDateStyleShort",
+				ChangeMessage: "The session now starts %@",
+			}},
+			AuxiliaryFields: []Field{
+				{Key: "row", Label: "ROW", Value: t.Row},
+				{Key: "seat", Label: "SEAT", Value: t.Seat},
+				{Key: "price", Label: "PRICE", Value: fmt.Sprintf("%d %s", t.Price, t.Currency)},
+			},
+			BackFields: []Field{
+				{Key: "ticket", Label: "Ticket", Value: fmt.Sprintf("#%d", t.ID)},
+				{Key: "holder", Label: "Holder", Value: t.Holder},
+				{Key: "doors", Label: "Entry", Value: "Doors open one hour before the session. Valid for one entry."},
+			},
+		},
+	}
+	if t.OrderID != 0 {
+		p.EventTicket.BackFields = append(p.EventTicket.BackFields, Field{Key: "order", Label: "Order", Value: fmt.Sprintf("#%d", t.OrderID)})
+	}
+	return p
+}
+
+// PassSigner signs .pkpass bundles with a Pass Type ID certificate.
+type PassSigner struct {
+	cert       *x509.Certificate
+	key        crypto.Signer
+	chain      []*x509.Certificate // Apple WWDR intermediate
+	PassTypeID string
+	TeamID     string
+}
+
+var oidUID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+// NewPassSigner takes the pass certificate and key and any intermediates.
+// The pass type and team come from the certificate's UID and OU, as in the
+// certificates Apple issues.
+func NewPassSigner(cert *x509.Certificate, key crypto.Signer, chain ...*x509.Certificate) (*PassSigner, error) {
+	s := &PassSigner{cert: cert, key: key, chain: chain}
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oidUID) {
+			s.PassTypeID, _ = name.Value.(string)
+		}
+	}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		s.TeamID = cert.Subject.OrganizationalUnit[0]
+	}
+	if s.PassTypeID == "" || s.TeamID == "" {
+		return nil, errors.New("wallet: certificate has no pass type ID (UID) or team ID (OU)")
+	}
+	return s, nil
+}
+
+// LoadPassSigner reads PEM files: the certificate, its private key and,
+// optionally, the WWDR intermediate.
+func LoadPassSigner(certFile, keyFile, wwdrFile string) (*PassSigner, error) {
+	certs, err := readCertificates(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	chain := certs[1:]
+	if wwdrFile != "" {
+		wwdr, err := readCertificates(wwdrFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, wwdr...)
+	}
+	return NewPassSigner(certs[0], key, chain...)
+}
+
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+	return certs, nil
+}
+
+// ParsePrivateKey reads a PEM RSA or EC key in PKCS#1, SEC 1 or PKCS#8 form.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return signer, nil
+}
+
+// WritePKPass writes the signed bundle: pass.json, the icons, manifest.json
+// with the SHA-1 of every file, and the detached signature of the manifest.
+func (s *PassSigner) WritePKPass(w io.Writer, p Pass) error {
+	p.PassTypeIdentifier, p.TeamIdentifier = s.PassTypeID, s.TeamID
+	passJSON, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{"pass.json": passJSON}
+	for name, size := range map[string]int{"icon.png": 29, "icon@2x.png": 58, "icon@3x.png": 87} {
+		files[name] = icon(size)
+	}
+
+	manifest := map[string]string{}
+	for name, data := range files {
+		sum := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	signature, err := signDetached(manifestJSON, s.cert, s.key, s.chain, time.Now())
+	if err != nil {
+		return err
+	}
+	files["manifest.json"] = manifestJSON
+	files["signature"] = signature
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// VerifyPKPass checks a bundle's manifest hashes and signature, returning
+// the parsed pass. roots works as in VerifyDetached.
+func VerifyPKPass(data []byte, roots *x509.CertPool) (Pass, error) {
+	var p Pass
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return p, err
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return p, err
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return p, err
+		}
+	}
+	if _, err := VerifyDetached(files["manifest.json"], files["signature"], roots); err != nil {
+		return p, err
+	}
+	var manifest map[string]string
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return p, err
+	}
+	for name, data := range files {
+		if name == "manifest.json" || name == "signature" {
+			continue
+		}
+		sum := sha1.Sum(data)
+		if manifest[name] != hex.EncodeToString(sum[:]) {
+			return p, fmt.Errorf("wallet: %s does not match the manifest", name)
+		}
+	}
+	err = json.Unmarshal(files["pass.json"], &p)
+	return p, err
+}
+
+// icon draws the required pass icon: a film frame on a dark square.
+func icon(size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	dark := color.RGBA{20, 20, 30, 255}
+	light := color.RGBA{230, 190, 60, 255}
+	border := size / 8
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := dark
+			inFrame := x >= border && x < size-border && y >= border && y < size-border
+			perforation := (x < border || x >= size-border) && (y/border)%2 == 1
+			if inFrame || perforation {
+				c = light
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
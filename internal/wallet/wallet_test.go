@@ -0,0 +1,176 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"testing"
+	"time"
+)
+
+func testSigner(t *testing.T) (*PassSigner, *x509.CertPool) {
+	t.Helper()
+	certPEM, keyPEM, err := NewTestCertificate("pass.kz.cinema.ticket", "TEAM123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewPassSigner(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return s, roots
+}
+
+func testTicket() Ticket {
+	starts := time.Date(2026, 3, 14, 19, 30, 0, 0, time.UTC)
+	return Ticket{
+		ID: 42, OrderID: 7, Organization: "Cinema", Movie: "Дюна", Hall: "Hall 1",
+		StartsAt: starts, Expires: starts.Add(3 * time.Hour),
+		Row: 5, Seat: 12, Price: 2500, Currency: "KZT", Holder: "A. Viewer",
+		Barcode: "v1.signed-payload",
+	}
+}
+
+func unzip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
+
+func rezip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, _ := zw.Create(name)
+		f.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPKPassManifestAndSignature(t *testing.T) {
+	s, roots := testSigner(t)
+	var buf bytes.Buffer
+	if err := s.WritePKPass(&buf, EventPass(testTicket())); err != nil {
+		t.Fatal(err)
+	}
+	files := unzip(t, buf.Bytes())
+
+	var manifest map[string]string
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pass.json", "icon.png", "icon@2x.png", "icon@3x.png"} {
+		sum := sha1.Sum(files[name])
+		if got := manifest[name]; got != hex.EncodeToString(sum[:]) {
+			t.Errorf("manifest hash of %s = %q, want %x", name, got, sum)
+		}
+	}
+	if len(manifest) != len(files)-2 {
+		t.Errorf("manifest lists %d files, bundle has %d besides manifest and signature", len(manifest), len(files)-2)
+	}
+
+	signer, err := VerifyDetached(files["manifest.json"], files["signature"], roots)
+	if err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if signer.Subject.CommonName != "Pass Type ID: pass.kz.cinema.ticket" {
+		t.Errorf("signed by %q", signer.Subject.CommonName)
+	}
+
+	p, err := VerifyPKPass(buf.Bytes(), roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PassTypeIdentifier != "pass.kz.cinema.ticket" || p.TeamIdentifier != "TEAM123456" {
+		t.Errorf("pass type %q, team %q", p.PassTypeIdentifier, p.TeamIdentifier)
+	}
+	if p.SerialNumber != "ticket-42" || p.Barcodes[0].Message != "v1.signed-payload" {
+		t.Errorf("serial %q, barcode %q", p.SerialNumber, p.Barcodes[0].Message)
+	}
+}
+
+func TestPKPassRejectsTampering(t *testing.T) {
+	s, roots := testSigner(t)
+	var buf bytes.Buffer
+	if err := s.WritePKPass(&buf, EventPass(testTicket())); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := testSigner(t)
+	var foreign bytes.Buffer
+	if err := other.WritePKPass(&foreign, EventPass(testTicket())); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(files map[string][]byte)
+	}{
+		{"pass.json changed", func(f map[string][]byte) {
+			f["pass.json"] = bytes.Replace(f["pass.json"], []byte(`"value": 12`), []byte(`"value": 13`), 1)
+		}},
+		{"manifest changed", func(f map[string][]byte) {
+			f["manifest.json"] = bytes.Replace(f["manifest.json"], []byte(`"icon.png":"`), []byte(`"icon.png":"0`), 1)
+		}},
+		{"file added", func(f map[string][]byte) { f["extra.png"] = []byte("x") }},
+		{"signature from another certificate", func(f map[string][]byte) {
+			f["signature"] = unzip(t, foreign.Bytes())["signature"]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := unzip(t, buf.Bytes())
+			tt.tamper(files)
+			if _, err := VerifyPKPass(rezip(t, files), roots); err == nil {
+				t.Error("tampered pass verified")
+			}
+		})
+	}
+}
+
+func TestVerifyDetachedChecksRoots(t *testing.T) {
+	s, _ := testSigner(t)
+	_, otherRoots := testSigner(t)
+	content := []byte(`{"pass.json":"00"}`)
+	sig, err := signDetached(content, s.cert, s.key, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyDetached(content, sig, nil); err != nil {
+		t.Fatalf("without roots: %v", err)
+	}
+	if _, err := VerifyDetached(content, sig, otherRoots); err == nil {
+		t.Error("verified against an unrelated root")
+	}
+}
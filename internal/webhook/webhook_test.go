@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	sent := time.Unix(1760000000, 0)
+	body := []byte(`{"event":"ticket.booked","ticket_id":42}`)
+	valid := Sign(secret, sent, body)
+	otherSig := mac("whsec_old", sent.Unix(), body)
+
+	errTolerance := errors.New("outside tolerance") // any error but ErrBadSignature
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		body      []byte
+		tolerance time.Duration
+		now       time.Time
+		want      error
+	}{
+		{name: "round trip", secret: secret, header: valid, body: body, tolerance: 5 * time.Minute, now: sent.Add(time.Minute)},
+		{name: "changed body", secret: secret, header: valid, body: []byte(`{"event":"ticket.booked","ticket_id":43}`), tolerance: 5 * time.Minute, now: sent, want: ErrBadSignature},
+		{name: "wrong secret", secret: "whsec_other", header: valid, body: body, tolerance: 5 * time.Minute, now: sent, want: ErrBadSignature},
+		{name: "too old", secret: secret, header: valid, body: body, tolerance: 5 * time.Minute, now: sent.Add(6 * time.Minute), want: errTolerance},
+		{name: "from the future", secret: secret, header: valid, body: body, tolerance: 5 * time.Minute, now: sent.Add(-6 * time.Minute), want: errTolerance},
+		{name: "zero tolerance skips the age check", secret: secret, header: valid, body: body, now: sent.Add(24 * time.Hour)},
+		// A sender rotating its secret signs with both; either may match.
+		{name: "several signatures, one valid", secret: secret, header: fmt.Sprintf("%s,v1=%s", valid, otherSig), body: body, tolerance: 5 * time.Minute, now: sent},
+		{name: "valid signature listed second", secret: secret, header: fmt.Sprintf("t=%d,v1=%s,v1=%s", sent.Unix(), otherSig, mac(secret, sent.Unix(), body)), body: body, tolerance: 5 * time.Minute, now: sent},
+		{name: "several signatures, none valid", secret: secret, header: fmt.Sprintf("t=%d,v1=%s,v1=%s", sent.Unix(), otherSig, "00"), body: body, tolerance: 5 * time.Minute, now: sent, want: ErrBadSignature},
+		{name: "spaces after commas", secret: secret, header: fmt.Sprintf("t=%d, v1=%s", sent.Unix(), mac(secret, sent.Unix(), body)), body: body, tolerance: 5 * time.Minute, now: sent},
+		{name: "missing t", secret: secret, header: "v1=" + mac(secret, sent.Unix(), body), body: body, now: sent, want: ErrBadSignature},
+		{name: "unparsable t", secret: secret, header: "t=soon,v1=" + mac(secret, sent.Unix(), body), body: body, now: sent, want: ErrBadSignature},
+		{name: "missing v1", secret: secret, header: fmt.Sprintf("t=%d", sent.Unix()), body: body, now: sent, want: ErrBadSignature},
+		{name: "other scheme only", secret: secret, header: fmt.Sprintf("t=%d,v0=%s", sent.Unix(), mac(secret, sent.Unix(), body)), body: body, now: sent, want: ErrBadSignature},
+		{name: "empty header", secret: secret, header: "", body: body, now: sent, want: ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tt.tolerance, tt.now)
+			switch {
+			case tt.want == nil:
+				if err != nil {
+					t.Errorf("Verify = %v, want nil", err)
+				}
+			case tt.want == errTolerance:
+				if err == nil || errors.Is(err, ErrBadSignature) {
+					t.Errorf("Verify = %v, want a tolerance error", err)
+				}
+			case !errors.Is(err, tt.want):
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", time.Unix(1760000000, 0), []byte("{}"))
+	// HMAC-SHA256 of "1760000000.{}" keyed with "secret".
+	want := "t=1760000000,v1=53dc054739ad94d3532227ba8d397f66ed2166a6b66940c2006692fa6db6829f"
+	if got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+}
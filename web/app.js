@@ -69,30 +69,45 @@ function openModal(title, contentHtml) {
 }
 function closeModal() { $("#modal")?.classList.remove("open"); }
 
-// ===== Page: Movies =====
-async function loadMovies() {
-    const tbody = $("#moviesBody");
-    if (!tbody) return;
+// "Sci-Fi, Drama" -> ["Sci-Fi", "Drama"]
+function splitList(str) {
+    return String(str).split(",").map(s => s.trim()).filter(Boolean);
+}
 
-    tbody.innerHTML = `<tr><td colspan="6">Loading...</td></tr>`;
+function debounce(fn, ms) {
+    let t;
+    return (...args) => {
+        clearTimeout(t);
+        t = setTimeout(() => fn(...args), ms);
+    };
+}
+
+// Fills the <datalist> behind the search box with title suggestions
+async function suggestTitles() {
+    const input = $("#search");
+    const list = $("#searchSuggest");
+    if (!input || !list) return;
+
+    const q = input.value.trim();
+    if (q.length < 2) { list.innerHTML = ""; return; }
     try {
-        const items = await api("/movies");
-        if (!items || items.length === 0) {
-            tbody.innerHTML = `<tr><td colspan="6">No movies yet. Create one 🙂</td></tr>`;
-            return;
-        }
+        const items = await api(`/movies/suggest?q=${encodeURIComponent(q)}`);
+        list.innerHTML = (items || []).map(s => `<option value="${escapeHtml(s.title)}"></option>`).join("");
+    } catch {
+        list.innerHTML = "";
+    }
+}
 
-        const q = ($("#search")?.value || "").toLowerCase().trim();
-        const filtered = items.filter(m => {
-            const text = `${m.id} ${m.title} ${m.genre} ${m.duration} ${m.price}`.toLowerCase();
-            return !q || text.includes(q);
-        });
+// ===== Page: Movies =====
+// Link to the next page of /movies, or null when everything is shown
+let moviesNext = null;
 
-        tbody.innerHTML = filtered.map(m => `
+function movieRow(m) {
+    return `
       <tr>
         <td>${m.id}</td>
         <td>${escapeHtml(m.title ?? "")}</td>
-        <td><span class="badge warn">${escapeHtml(m.genre ?? "-")}</span></td>
+        <td><span class="badge warn">${escapeHtml((m.genres || []).join(", ") || "-")}</span></td>
         <td>${m.duration ?? "-"}</td>
         <td>${m.price ?? 0}</td>
         <td>
@@ -102,18 +117,63 @@ async function loadMovies() {
           </div>
         </td>
       </tr>
-    `).join("");
+    `;
+}
+
+function moreRow() {
+    return moviesNext
+        ? `<tr id="moviesMore"><td colspan="6"><button class="btn" data-action="more">Load more</button></td></tr>`
+        : "";
+}
+
+async function loadMovies() {
+    const tbody = $("#moviesBody");
+    if (!tbody) return;
+
+    tbody.innerHTML = `<tr><td colspan="6">Loading...</td></tr>`;
+    moviesNext = null;
+    try {
+        const q = ($("#search")?.value || "").trim();
+        let filtered;
+        if (q.length >= 2) {
+            // Server-side ranked, typo-tolerant search
+            filtered = await api(`/movies/search?q=${encodeURIComponent(q)}`);
+        } else {
+            const page = await api("/movies?limit=50");
+            filtered = page?.items || [];
+            moviesNext = page?.next || null;
+            if (filtered.length === 0) {
+                tbody.innerHTML = `<tr><td colspan="6">No movies yet. Create one 🙂</td></tr>`;
+                return;
+            }
+        }
+
+        tbody.innerHTML = filtered.map(movieRow).join("") + moreRow();
     } catch (e) {
         tbody.innerHTML = `<tr><td colspan="6">Error: ${escapeHtml(e.message)}</td></tr>`;
         toast("Movies", e.message);
     }
 }
 
+// Appends the next page the server linked to
+async function loadMoreMovies() {
+    if (!moviesNext) return;
+    try {
+        const page = await api(moviesNext);
+        moviesNext = page?.next || null;
+        $("#moviesMore")?.remove();
+        $("#moviesBody").insertAdjacentHTML("beforeend", (page?.items || []).map(movieRow).join("") + moreRow());
+    } catch (e) {
+        toast("Movies", e.message);
+    }
+}
+
 function moviesWire() {
     if (!$("#moviesBody")) return;
 
     $("#refreshMovies")?.addEventListener("click", loadMovies);
-    $("#search")?.addEventListener("input", loadMovies);
+    $("#search")?.addEventListener("input", debounce(loadMovies, 250));
+    $("#search")?.addEventListener("input", debounce(suggestTitles, 150));
 
     $("#createMovie")?.addEventListener("click", () => {
         openModal("Create Movie", `
@@ -123,8 +183,8 @@ function moviesWire() {
           <input class="input" id="mTitle" placeholder="e.g. Interstellar" />
         </div>
         <div class="col-6 field">
-          <label>Genre</label>
-          <input class="input" id="mGenre" placeholder="Sci-Fi" />
+          <label>Genres</label>
+          <input class="input" id="mGenre" placeholder="Sci-Fi, Drama" />
         </div>
         <div class="col-3 field">
           <label>Duration (min)</label>
@@ -148,7 +208,7 @@ function moviesWire() {
             try {
                 const body = {
                     title: $("#mTitle").value,
-                    genre: $("#mGenre").value,
+                    genres: splitList($("#mGenre").value),
                     duration: Number($("#mDuration").value),
                     price: Number($("#mPrice").value),
                 };
@@ -168,6 +228,12 @@ function moviesWire() {
         const action = btn.dataset.action;
         const id = Number(btn.dataset.id);
 
+        if (action === "more") {
+            btn.disabled = true;
+            await loadMoreMovies();
+            return;
+        }
+
         if (action === "del") {
             openModal("Delete Movie", `
         <p class="small">Are you sure you want to delete movie <kbd>#${id}</kbd>?</p>
@@ -201,8 +267,8 @@ function moviesWire() {
               <input class="input" id="eTitle" value="${escapeHtml(m.title ?? "")}" />
             </div>
             <div class="col-6 field">
-              <label>Genre</label>
-              <input class="input" id="eGenre" value="${escapeHtml(m.genre ?? "")}" />
+              <label>Genres</label>
+              <input class="input" id="eGenre" value="${escapeHtml((m.genres || []).join(", "))}" />
             </div>
             <div class="col-3 field">
               <label>Duration (min)</label>
@@ -225,7 +291,7 @@ function moviesWire() {
                     try {
                         const patch = {
                             title: $("#eTitle").value,
-                            genre: $("#eGenre").value,
+                            genres: splitList($("#eGenre").value),
                             duration: Number($("#eDuration").value),
                             price: Number($("#ePrice").value),
                         };
@@ -263,8 +329,20 @@ function bookWire() {
     });
 
     // random buttons
-    $("#seatRandom")?.addEventListener("click", () => {
-        $("#seatId").value = String(Math.floor(Math.random() * 80) + 1); // 1..80
+    $("#seatBest")?.addEventListener("click", async () => {
+        try {
+            const res = await api(`/sessions/${$("#sessionId").value}/seats/best?party=1&limit=1`);
+            if (!res.blocks.length) {
+                toast("Best seat", "No free seats left in this session");
+                return;
+            }
+            const seat = res.blocks[0].seats[0];
+            $("#seatId").value = String(seat.seat);
+            $("#seatId").dispatchEvent(new Event("input"));
+            toast("Best seat", `Row ${seat.row}, seat ${seat.number}`);
+        } catch(e) {
+            toast("Best seat", e.message);
+        }
     });
 
     $("#userRandom")?.addEventListener("click", () => {
@@ -280,7 +358,68 @@ function bookWire() {
     
 }
 
+// Live seat map: a snapshot first, then one "seat" event per change.
+function seatStreamWire() {
+    const box = $("#seatMap");
+    if (!box) return;
+
+    let source = null;
+    let seats = new Map();
+
+    const render = () => {
+        const selected = Number($("#seatId").value || 0);
+        const rows = new Map();
+        seats.forEach(s => {
+            if (!rows.has(s.row)) rows.set(s.row, []);
+            rows.get(s.row).push(s);
+        });
+        box.innerHTML = [...rows.entries()].map(([row, list]) => `
+          <div class="seatRow"><span class="label">${row}</span>${list.map(s => `
+            <button type="button" class="seat ${s.state === "free" ? "" : s.state} cat-${s.category || "standard"} ${s.seat === selected ? "selected" : ""}"
+                    data-seat="${s.seat}"
+                    title="Row ${s.row}, seat ${s.number} · ${s.category || "standard"}${s.price != null ? ` · ${s.price} KZT` : ""}${s.companion_of ? ` · companion of seat ${s.companion_of}` : ""}">${s.number}</button>`).join("")}
+          </div>`).join("");
+    };
+
+    const connect = () => {
+        source?.close();
+        $("#seatLive").textContent = "Connecting...";
+        source = new EventSource(`${API_BASE}/sessions/${$("#sessionId").value}/seats/stream`);
+        source.addEventListener("snapshot", (e) => {
+            const m = JSON.parse(e.data);
+            seats = new Map(m.seats.map(s => [s.seat, s]));
+            $("#seatLive").textContent = `Live · ${m.total_seats} seats`;
+            render();
+        });
+        source.addEventListener("seat", (e) => {
+            const ev = JSON.parse(e.data);
+            const state = ev.state === "released" ? "free" : ev.state;
+            seats.set(ev.seat, { ...seats.get(ev.seat), seat: ev.seat, row: ev.row, number: ev.number, state });
+            render();
+        });
+        source.onerror = () => { $("#seatLive").textContent = "Reconnecting..."; };
+    };
+
+    box.addEventListener("click", (e) => {
+        const b = e.target.closest("button[data-seat]");
+        if (!b || seats.get(Number(b.dataset.seat))?.state !== "free") return;
+        $("#seatId").value = b.dataset.seat;
+        render();
+    });
+    $("#seatId").addEventListener("input", render);
+    $("#sessionId").addEventListener("change", connect);
+    connect();
+}
+
 // ===== Page: Ticket =====
+// The server lists the wallets it can sign passes for; a failed lookup
+// just means no wallet links.
+let walletsPromise = null;
+function availableWallets() {
+    walletsPromise ??= api("/wallets").catch(() => ({}));
+    return walletsPromise;
+}
+
 async function ticketLoad() {
     const box = $("#ticketBox");
     if (!box) return;
@@ -297,7 +436,10 @@ async function ticketLoad() {
     box.innerHTML = `<p class="small">Loading ticket...</p>`;
 
     try {
-        const t = await api(`/ticket?id=${id}`);
+        const [t, wallets] = await Promise.all([api(`/ticket?id=${id}`), availableWallets()]);
+        const appleLink = wallets.apple
+            ? ` · <a href="${API_BASE}/tickets/${id}/wallet.pkpass">Add to Apple Wallet</a>`
+            : "";
         box.innerHTML = `
       <div class="grid">
         <div class="col-6 card soft">
@@ -308,6 +450,11 @@ async function ticketLoad() {
           <p class="small">Seat: <kbd>${escapeHtml(String(t.seat_id ?? "-"))}</kbd></p>
           <p class="small">User: <kbd>${escapeHtml(String(t.user_id ?? "-"))}</kbd></p>
           <p class="small">Price: <kbd>${escapeHtml(String(t.price ?? 0))} KZT</kbd></p>
+          <div class="hr"></div>
+          <img src="${API_BASE}/tickets/${id}/qr?format=svg" alt="Ticket QR code" width="220" height="220"
+               style="background:#fff; border-radius:8px"/>
+          <p class="small">Show this code at the entrance.</p>
+          <p class="small"><a href="${API_BASE}/tickets/${id}.pdf" target="_blank">Download PDF ticket</a>${appleLink}</p>
         </div>
         <div class="col-6 card soft">
           <h2>Raw JSON</h2>
@@ -338,6 +485,7 @@ document.addEventListener("DOMContentLoaded", () => {
     moviesWire();
     loadMovies();
     bookWire();
+    seatStreamWire();
     ticketLoad();
 
     // Health ping (optional)